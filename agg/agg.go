@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/jinzhu/copier"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 )
 
@@ -18,8 +19,11 @@ func New() *Hub {
 		Streams:    make(map[string]map[*hub.Client]bool),
 		SubClients: make(map[*hub.Client]map[*SubClient]bool),
 		Rules:      make(map[string][]string),
+		Muxed:      make(map[string]bool),
+		Filters:    make(map[string]map[string]Filter),
 		Add:        make(chan Rule),
 		Delete:     make(chan string),
+		Ping:       make(chan struct{}),
 	}
 
 	return h
@@ -47,7 +51,9 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 		select {
 		case <-closed:
 			return
+		case <-h.Ping:
 		case client := <-h.Register:
+			h.Events.Publish(events.Event{Kind: events.ClientRegistered, Topic: client.Topic, Id: client.Name})
 			if strings.HasPrefix(client.Topic, "stream/") {
 				// register the client to the stream
 				if _, ok := h.Streams[client.Topic]; !ok {
@@ -58,14 +64,18 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 				// register the client to any feeds currently set by stream rule
 				if feeds, ok := h.Rules[client.Topic]; ok {
 					h.SubClients[client] = make(map[*SubClient]bool)
+					muxer := h.muxer(client.Topic)
 					wg := &sync.WaitGroup{}
-					for _, feed := range feeds {
+					for i, feed := range feeds {
 						// create and store the subclients we will register with the hub
 						subClient := &SubClient{Client: &hub.Client{}}
 						copier.Copy(&subClient.Client, client)
 						subClient.Client.Topic = feed
 						subClient.Client.Send = make(chan hub.Message)
 						subClient.Stopped = make(chan struct{})
+						subClient.Muxer = muxer
+						subClient.Index = i
+						subClient.filter = h.filter(client.Topic, feed)
 						h.SubClients[client][subClient] = true
 						wg.Add(1)
 						go subClient.RelayTo(client)
@@ -78,6 +88,7 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 				h.Hub.Register <- client
 			}
 		case client := <-h.Unregister:
+			h.Events.Publish(events.Event{Kind: events.ClientUnregistered, Topic: client.Topic, Id: client.Name})
 			if strings.HasPrefix(client.Topic, "stream/") {
 				// unregister any subclients that are registered to feeds
 				for subClient := range h.SubClients[client] {
@@ -99,7 +110,11 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 		case msg := <-h.Broadcast:
 			// defer handling to hub
 			// note that non-responsive clients will get deleted
-			h.Hub.Broadcast <- msg
+			select {
+			case h.Hub.Broadcast <- msg:
+			case <-closed:
+				return
+			}
 		case rule := <-h.Add:
 			if rule.Stream == "deleteAll" {
 				break //reserved ID for deleting all rules
@@ -111,21 +126,39 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 						h.Hub.Unregister <- subClient.Client
 						close(subClient.Stopped)
 					}
+					delete(h.SubClients, client)
 				}
 			}
 			//set new rule
+			h.mux.Lock()
 			h.Rules[rule.Stream] = rule.Feeds
+			if rule.Mux {
+				h.Muxed[rule.Stream] = true
+			} else {
+				delete(h.Muxed, rule.Stream)
+			}
+			if len(rule.Filters) > 0 {
+				h.Filters[rule.Stream] = rule.Filters
+			} else {
+				delete(h.Filters, rule.Stream)
+			}
+			h.mux.Unlock()
+			h.Events.Publish(events.Event{Kind: events.StreamAdded, Topic: rule.Stream, Detail: strings.Join(rule.Feeds, ",")})
 			// register the clients to any feeds currently set by stream rule
 			if feeds, ok := h.Rules[rule.Stream]; ok {
 				for client, _ := range h.Streams[rule.Stream] {
 					h.SubClients[client] = make(map[*SubClient]bool)
-					for _, feed := range feeds {
+					muxer := h.muxer(rule.Stream)
+					for i, feed := range feeds {
 						// create and store the subclients we will register with the hub
 						subClient := &SubClient{Client: &hub.Client{}}
 						copier.Copy(&subClient.Client, client)
 						subClient.Client.Topic = feed
 						subClient.Client.Send = make(chan hub.Message)
 						subClient.Stopped = make(chan struct{})
+						subClient.Muxer = muxer
+						subClient.Index = i
+						subClient.filter = h.filter(rule.Stream, feed)
 						h.SubClients[client][subClient] = true
 						go subClient.RelayTo(client)
 						h.Hub.Register <- subClient.Client
@@ -144,7 +177,17 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 					}
 				}
 
+				h.SubClients = make(map[*hub.Client]map[*SubClient]bool)
+
+				for stream := range h.Rules {
+					h.Events.Publish(events.Event{Kind: events.StreamDeleted, Topic: stream})
+				}
+
+				h.mux.Lock()
 				h.Rules = make(map[string][]string)
+				h.Muxed = make(map[string]bool)
+				h.Filters = make(map[string]map[string]Filter)
+				h.mux.Unlock()
 
 			} else { //single stream
 
@@ -155,11 +198,19 @@ func (h *Hub) RunOptionalStats(closed chan struct{}, withStats bool) {
 							h.Hub.Unregister <- subClient.Client
 							close(subClient.Stopped)
 						}
+						delete(h.SubClients, client)
 					}
 				}
 
 				// delete rule
+				if _, ok := h.Rules[stream]; ok {
+					h.Events.Publish(events.Event{Kind: events.StreamDeleted, Topic: stream})
+				}
+				h.mux.Lock()
 				delete(h.Rules, stream)
+				delete(h.Muxed, stream)
+				delete(h.Filters, stream)
+				h.mux.Unlock()
 			}
 		}
 	}
@@ -173,6 +224,18 @@ func (sc *SubClient) RelayTo(c *hub.Client) {
 			return
 		case msg, ok := <-sc.Client.Send:
 			if ok {
+				if sc.filter != nil {
+					msg.Data = sc.filter.Write(msg.Data)
+					if len(msg.Data) == 0 {
+						break
+					}
+				}
+				if sc.Muxer != nil {
+					msg.Data = sc.Muxer.Mux(sc.Index, msg.Data)
+					if len(msg.Data) == 0 {
+						break
+					}
+				}
 				c.Send <- msg
 			} else {
 				return
@@ -180,3 +243,66 @@ func (sc *SubClient) RelayTo(c *hub.Client) {
 		}
 	}
 }
+
+// muxer returns a new Muxer for each client of a stream that has a mux
+// rule, or nil if the feeds are to be passed through as they are
+func (h *Hub) muxer(stream string) *Muxer {
+	if !h.Muxed[stream] {
+		return nil
+	}
+	return NewMuxer(len(h.Rules[stream]))
+}
+
+// filter returns a new filter for a feed of a stream, or nil if the
+// feed is to be passed through as it is
+func (h *Hub) filter(stream, feed string) *filter {
+	f, ok := h.Filters[stream][feed]
+	if !ok {
+		return nil
+	}
+	return newFilter(f)
+}
+
+// Rule returns a copy of the rule for a stream, as it was added,
+// which is safe to use while the hub is running
+func (h *Hub) Rule(stream string) (Rule, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.rule(stream)
+}
+
+// Snapshot returns a copy of every stream rule, which is safe to
+// use while the hub is running
+func (h *Hub) Snapshot() map[string]Rule {
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	rules := make(map[string]Rule)
+
+	for stream := range h.Rules {
+		rules[stream], _ = h.rule(stream)
+	}
+
+	return rules
+}
+
+// rule copies the rule for a stream, so callers can change it freely
+func (h *Hub) rule(stream string) (Rule, bool) {
+
+	feeds, ok := h.Rules[stream]
+	if !ok {
+		return Rule{}, false
+	}
+
+	rule := Rule{Stream: stream, Feeds: append([]string{}, feeds...), Mux: h.Muxed[stream]}
+
+	if filters, ok := h.Filters[stream]; ok {
+		rule.Filters = make(map[string]Filter)
+		for feed, filter := range filters {
+			rule.Filters[feed] = filter
+		}
+	}
+
+	return rule, true
+}
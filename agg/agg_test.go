@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 )
 
@@ -505,6 +506,20 @@ func TestAddRuleAddStreamDeleteRule(t *testing.T) {
 			t.Error("after deleting rule, found subclient for", feeds[i])
 		}
 	}
+
+	if _, ok := h.SubClients[c]; ok {
+		t.Error("after deleting rule, subclients still listed")
+	}
+
+	// unregistering the stream client after its rule has gone must not
+	// try to stop the subclients a second time
+	h.Unregister <- c
+
+	time.Sleep(time.Millisecond)
+
+	if _, ok := h.Streams[stream][c]; ok {
+		t.Error("Stream client not unregistered")
+	}
 }
 
 func TestStreamGetsFeedMessges(t *testing.T) {
@@ -724,3 +739,42 @@ func TestStreamWithRuleChange(t *testing.T) {
 		t.Error("Did not get message from c3")
 	}
 }
+
+func TestEvents(t *testing.T) {
+	h := New()
+	h.Events = events.New()
+	closed := make(chan struct{})
+	defer close(closed)
+	go h.Run(closed)
+
+	ch := h.Events.Subscribe(10)
+
+	stream := "stream/large"
+	r := &Rule{Stream: stream, Feeds: []string{"video0", "audio"}}
+	h.Add <- *r
+
+	c := &hub.Client{Hub: h.Hub, Name: "aa", Topic: "video0", Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+	h.Register <- c
+	h.Unregister <- c
+
+	h.Delete <- stream
+
+	expected := []events.Event{
+		{Kind: events.StreamAdded, Topic: stream, Detail: "video0,audio"},
+		{Kind: events.ClientRegistered, Topic: "video0", Id: "aa"},
+		{Kind: events.ClientUnregistered, Topic: "video0", Id: "aa"},
+		{Kind: events.StreamDeleted, Topic: stream},
+	}
+
+	for _, want := range expected {
+		select {
+		case got := <-ch:
+			got.Time = time.Time{}
+			if got != want {
+				t.Errorf("Wrong event got/wanted %v/%v", got, want)
+			}
+		case <-time.After(10 * time.Millisecond):
+			t.Errorf("timed out waiting for %s", want.Kind)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package agg
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+
+	"github.com/timdrysdale/vw/ts"
+)
+
+// Filter selects elementary streams within an MPEG-TS feed, by PID,
+// PMT stream type, or kind. Matching streams are kept and the rest
+// dropped, or the other way round if Drop is set.
+type Filter struct {
+	PIDs  []uint16 `json:"pids,omitempty"`
+	Types []int    `json:"types,omitempty"` // e.g. 27 for H.264, 15 for AAC
+	Kinds []string `json:"kinds,omitempty"` // video, audio or data
+	Drop  bool     `json:"drop,omitempty"`
+}
+
+var errFilterFeed = errors.New("Filter for a feed that is not in the stream")
+var errFilterKind = errors.New("Filter kinds must be video, audio or data")
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	for feed, filter := range rule.Filters {
+		found := false
+		for _, f := range rule.Feeds {
+			found = found || f == feed
+		}
+		if !found {
+			return errFilterFeed
+		}
+		for _, kind := range filter.Kinds {
+			if kind != ts.KindVideo && kind != ts.KindAudio && kind != ts.KindData {
+				return errFilterKind
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f Filter) match(es ts.ES) bool {
+	for _, pid := range f.PIDs {
+		if es.PID == pid {
+			return true
+		}
+	}
+	for _, t := range f.Types {
+		if int(es.Type) == t {
+			return true
+		}
+	}
+	for _, kind := range f.Kinds {
+		if ts.Kind(es.Type) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// filter applies a Filter to the messages from one feed. Only the
+// elementary streams in the latest PMT that pass the filter get
+// through, so nothing leaks before the first PMT. The PMT is rewritten
+// to list only those streams, and if the PCR is on a stream that is
+// dropped, its PCRs are still sent, without the payload.
+type filter struct {
+	Filter
+	pmtPIDs map[uint16]bool
+	kept    map[uint16]bool
+	pcrPID  uint16
+	program ts.Program // as last sent
+	version uint8
+	partial []byte
+}
+
+func newFilter(f Filter) *filter {
+	return &filter{Filter: f,
+		pmtPIDs: make(map[uint16]bool),
+		kept:    make(map[uint16]bool),
+		pcrPID:  nullPID,
+	}
+}
+
+// Write returns the whole packets from data that pass the filter
+func (f *filter) Write(data []byte) []byte {
+
+	if len(f.partial) > 0 {
+		data = append(f.partial, data...)
+		f.partial = nil
+	}
+
+	out := []byte{}
+
+	for len(data) > 0 {
+
+		if data[0] != ts.SyncByte {
+			i := bytes.IndexByte(data, ts.SyncByte)
+			if i < 0 {
+				data = nil
+				break
+			}
+			data = data[i:]
+		}
+
+		if len(data) < ts.PacketSize {
+			break
+		}
+
+		p := data[:ts.PacketSize]
+		data = data[ts.PacketSize:]
+
+		pid := ts.PID(p)
+
+		switch {
+
+		case pid == 0:
+			if programs, ok := ts.PAT(p); ok {
+				f.pmtPIDs = make(map[uint16]bool)
+				for _, pmt := range programs {
+					f.pmtPIDs[pmt] = true
+				}
+			}
+			out = append(out, p...)
+
+		case f.pmtPIDs[pid]:
+			number, program, ok := ts.PMT(p)
+			if !ok {
+				break // can't rewrite a PMT that spans packets
+			}
+			out = append(out, f.rewrite(pid, number, program, ts.ContinuityCounter(p))...)
+
+		case f.kept[pid]:
+			out = append(out, p...)
+
+		case pid == f.pcrPID:
+			if pcr, ok := ts.PCR(p); ok {
+				out = append(out, ts.Encode(ts.Header{PID: pid, CC: ts.ContinuityCounter(p), HasPCR: true, PCR: pcr}, nil)...)
+			}
+		}
+	}
+
+	if len(data) > 0 {
+		f.partial = append([]byte{}, data...)
+	}
+
+	return out
+}
+
+// rewrite returns a PMT packet listing only the streams we keep
+func (f *filter) rewrite(pid, number uint16, program ts.Program, cc uint8) []byte {
+
+	filtered := ts.Program{PCRPID: program.PCRPID}
+
+	f.kept = make(map[uint16]bool)
+
+	for _, es := range program.Streams {
+		if f.match(es) != f.Drop {
+			filtered.Streams = append(filtered.Streams, es)
+			f.kept[es.PID] = true
+		}
+	}
+
+	f.pcrPID = program.PCRPID
+
+	if !reflect.DeepEqual(filtered, f.program) {
+		f.program = filtered
+		f.version = (f.version + 1) & 0x1f
+	}
+
+	return ts.Encode(ts.Header{PID: pid, PUSI: true, CC: cc}, ts.PMTPayload(number, filtered, f.version))
+}
@@ -0,0 +1,136 @@
+package agg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+// avFeed makes a combined feed, with H.264 video carrying the PCR on PID
+// 0x100, AAC audio on PID 0x101, and its PMT on PID 0x1000
+func avFeed(frames int) []byte {
+
+	data := ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))
+
+	program := ts.Program{PCRPID: 0x100, Streams: []ts.ES{{Type: 0x1b, PID: 0x100}, {Type: 0x0f, PID: 0x101}}}
+	data = append(data, ts.Encode(ts.Header{PID: 0x1000, PUSI: true}, ts.PMTPayload(1, program, 0))...)
+
+	for i := 0; i < frames; i++ {
+		pcr := uint64(i) * 40 * ts.PCRHz / 1000
+		data = append(data, ts.Encode(ts.Header{PID: 0x100, PUSI: true, CC: uint8(i), HasPCR: true, PCR: pcr}, []byte{'v'})...)
+		data = append(data, ts.Encode(ts.Header{PID: 0x101, PUSI: true, CC: uint8(i)}, []byte{'a'})...)
+	}
+
+	return data
+}
+
+func TestCheck(t *testing.T) {
+
+	tests := []struct {
+		rule Rule
+		want error
+	}{
+		{Rule{Stream: "stream/a", Feeds: []string{"av0"}}, nil},
+		{Rule{Stream: "stream/a", Feeds: []string{"av0"}, Filters: map[string]Filter{"av0": {Kinds: []string{"audio"}, Drop: true}}}, nil},
+		{Rule{Stream: "stream/a", Feeds: []string{"av0"}, Filters: map[string]Filter{"av1": {Kinds: []string{"audio"}}}}, errFilterFeed},
+		{Rule{Stream: "stream/a", Feeds: []string{"av0"}, Filters: map[string]Filter{"av0": {Kinds: []string{"subtitles"}}}}, errFilterKind},
+	}
+
+	for _, tt := range tests {
+		if got := Check(tt.rule); got != tt.want {
+			t.Errorf("Check(%+v) got/wanted %v/%v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestFilterDropAudio(t *testing.T) {
+
+	f := newFilter(Filter{Kinds: []string{"audio"}, Drop: true})
+
+	// nothing before the first PMT gets through, in case it is audio
+	early := ts.Encode(ts.Header{PID: 0x101, PUSI: true}, []byte{'a'})
+
+	data := append(early, avFeed(3)...)
+
+	// split mid-packet
+	out := f.Write(data[:500])
+	out = append(out, f.Write(data[500:])...)
+
+	packets := testPackets(t, out)
+
+	if len(packets[0x101]) != 0 {
+		t.Errorf("Audio got through %d times", len(packets[0x101]))
+	}
+
+	if len(packets[0x100]) != 3 || len(packets[0]) != 1 || len(packets[0x1000]) != 1 {
+		t.Errorf("Wrong packets %v", packets)
+	}
+
+	number, program, ok := ts.PMT(packets[0x1000][0])
+	if !ok || number != 1 || len(program.Streams) != 1 || program.Streams[0].PID != 0x100 || program.PCRPID != 0x100 {
+		t.Errorf("Wrong PMT %+v", program)
+	}
+}
+
+func TestFilterKeepAudio(t *testing.T) {
+
+	f := newFilter(Filter{PIDs: []uint16{0x101}})
+
+	packets := testPackets(t, f.Write(avFeed(3)))
+
+	if len(packets[0x101]) != 3 {
+		t.Errorf("Wrong number of audio packets; got/wanted %d/%d", len(packets[0x101]), 3)
+	}
+
+	_, program, ok := ts.PMT(packets[0x1000][0])
+	if !ok || len(program.Streams) != 1 || program.Streams[0].PID != 0x101 {
+		t.Errorf("Wrong PMT %+v", program)
+	}
+
+	// the video is gone, but its PCRs are still needed
+	if len(packets[0x100]) != 3 {
+		t.Fatalf("Wrong number of PCR packets; got/wanted %d/%d", len(packets[0x100]), 3)
+	}
+
+	for i, p := range packets[0x100] {
+		if ts.HasPayload(p) {
+			t.Error("Video payload got through")
+		}
+		if pcr, ok := ts.PCR(p); !ok || pcr != uint64(i)*40*ts.PCRHz/1000 {
+			t.Errorf("Wrong PCR %d", pcr)
+		}
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	h := New()
+	closed := make(chan struct{})
+	defer close(closed)
+	go h.Run(closed)
+
+	stream := "stream/front"
+
+	h.Add <- Rule{Stream: stream, Feeds: []string{"av0"}, Filters: map[string]Filter{"av0": {Types: []int{0x0f}, Drop: true}}}
+
+	c := &hub.Client{Hub: h.Hub, Name: "aa", Topic: stream, Send: make(chan hub.Message, 10), Stats: hub.NewClientStats()}
+
+	h.Register <- c
+
+	c1 := &hub.Client{Hub: h.Hub, Name: "1", Topic: "av0", Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+
+	time.Sleep(time.Millisecond)
+
+	h.Broadcast <- hub.Message{Data: avFeed(3), Sender: *c1, Sent: time.Now()}
+
+	select {
+	case msg := <-c.Send:
+		packets := testPackets(t, msg.Data)
+		if len(packets[0x101]) != 0 || len(packets[0x100]) != 3 {
+			t.Errorf("Wrong packets %v", packets)
+		}
+	case <-time.After(10 * time.Millisecond):
+		t.Error("timed out waiting for message")
+	}
+}
@@ -0,0 +1,191 @@
+package agg
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/ts"
+)
+
+const (
+	MuxProgram  = 1      // program number of the combined stream
+	MuxPMTPID   = 0x1000 // PID of the combined program map table
+	muxFirstPID = 0x100  // PIDs for the feeds are handed out from here
+	nullPID     = 0x1fff
+)
+
+// Muxer combines the MPEG-TS feeds of a stream into a single program.
+// Each feed's PIDs are rewritten so they can't collide, and the feeds'
+// program tables are replaced by one PAT and PMT listing all of them.
+// Output only ever contains whole packets, so messages from different
+// feeds interleave on packet boundaries.
+type Muxer struct {
+	mux     sync.Mutex
+	feeds   []*muxFeed
+	next    uint16     // next PID to hand out
+	program ts.Program // combined, with rewritten PIDs
+	version uint8
+	patCC   uint8
+	pmtCC   uint8
+}
+
+type muxFeed struct {
+	pids    map[uint16]uint16 // original PID to rewritten PID
+	pmts    map[uint16]bool   // PIDs carrying this feed's PMT
+	program ts.Program        // as last seen, with original PIDs
+	partial []byte            // start of a packet, awaiting the rest
+}
+
+func NewMuxer(feeds int) *Muxer {
+	m := &Muxer{next: muxFirstPID}
+	for i := 0; i < feeds; i++ {
+		m.feeds = append(m.feeds, &muxFeed{
+			pids: make(map[uint16]uint16),
+			pmts: make(map[uint16]bool),
+		})
+	}
+	return m
+}
+
+// Mux returns the whole packets in data from the feed with this index,
+// with PIDs rewritten and program tables replaced. The combined PAT and
+// PMT go in front whenever they change, and each time the first feed
+// sends its PAT. Anything that is not MPEG-TS is skipped.
+func (m *Muxer) Mux(feed int, data []byte) []byte {
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if feed < 0 || feed >= len(m.feeds) {
+		return nil
+	}
+
+	f := m.feeds[feed]
+
+	if len(f.partial) > 0 {
+		data = append(f.partial, data...)
+		f.partial = nil
+	}
+
+	out := []byte{}
+	tables := false
+
+	for len(data) > 0 {
+
+		if data[0] != ts.SyncByte {
+			// skip to the next packet, if there is one
+			log.WithField("feed", feed).Debug("Dropping data that is not MPEG-TS")
+			i := bytes.IndexByte(data, ts.SyncByte)
+			if i < 0 {
+				data = nil
+				break
+			}
+			data = data[i:]
+		}
+
+		if len(data) < ts.PacketSize {
+			break
+		}
+
+		p := data[:ts.PacketSize]
+		data = data[ts.PacketSize:]
+
+		pid := ts.PID(p)
+
+		switch {
+
+		case pid == 0:
+			if programs, ok := ts.PAT(p); ok {
+				f.pmts = make(map[uint16]bool)
+				for _, pmt := range programs {
+					f.pmts[pmt] = true
+				}
+				tables = tables || feed == 0
+			}
+
+		case f.pmts[pid]:
+			if _, program, ok := ts.PMT(p); ok && !reflect.DeepEqual(program, f.program) {
+				f.program = program
+				m.combine()
+				tables = true
+			}
+
+		case pid < 0x20 || pid == nullPID:
+			// other tables, and stuffing, don't survive muxing
+
+		default:
+			q := make([]byte, ts.PacketSize)
+			copy(q, p)
+			rewritten := m.pid(f, pid)
+			q[1] = q[1]&0xe0 | byte(rewritten>>8&0x1f)
+			q[2] = byte(rewritten)
+			out = append(out, q...)
+		}
+	}
+
+	if len(data) > 0 {
+		f.partial = append([]byte{}, data...)
+	}
+
+	if tables && len(m.program.Streams) > 0 {
+		out = append(m.tables(), out...)
+	}
+
+	return out
+}
+
+// pid returns the rewritten PID for a feed's PID, handing out the next
+// free PID the first time it is seen
+func (m *Muxer) pid(f *muxFeed, pid uint16) uint16 {
+	if rewritten, ok := f.pids[pid]; ok {
+		return rewritten
+	}
+	if m.next == MuxPMTPID {
+		m.next++
+	}
+	rewritten := m.next
+	m.next++
+	f.pids[pid] = rewritten
+	return rewritten
+}
+
+// combine lists every feed's streams in one program, taking the PCR from
+// the first feed that has one
+func (m *Muxer) combine() {
+
+	program := ts.Program{PCRPID: nullPID}
+
+	for _, f := range m.feeds {
+		if program.PCRPID == nullPID && len(f.program.Streams) > 0 && f.program.PCRPID != nullPID {
+			program.PCRPID = m.pid(f, f.program.PCRPID)
+		}
+		for _, es := range f.program.Streams {
+			program.Streams = append(program.Streams, ts.ES{Type: es.Type, PID: m.pid(f, es.PID), Info: es.Info})
+		}
+	}
+
+	m.program = program
+	m.version = (m.version + 1) & 0x1f
+}
+
+// tables returns the combined PAT and PMT
+func (m *Muxer) tables() []byte {
+
+	pmt := ts.PMTPayload(MuxProgram, m.program, m.version)
+
+	if len(pmt) > ts.PacketSize-4 {
+		log.WithField("streams", len(m.program.Streams)).Error("Combined PMT does not fit in one packet")
+		return nil
+	}
+
+	pat := ts.Encode(ts.Header{PID: 0, PUSI: true, CC: m.patCC},
+		ts.PATPayload(map[uint16]uint16{MuxProgram: MuxPMTPID}, m.version))
+	m.patCC = (m.patCC + 1) & 0x0f
+
+	out := append(pat, ts.Encode(ts.Header{PID: MuxPMTPID, PUSI: true, CC: m.pmtCC}, pmt)...)
+	m.pmtCC = (m.pmtCC + 1) & 0x0f
+
+	return out
+}
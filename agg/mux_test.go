@@ -0,0 +1,173 @@
+package agg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+// testFeed makes a single-program feed, as ffmpeg would, with its only
+// elementary stream on PID 0x100 and its PMT on PID 0x1000, so that
+// any two of them collide
+func testFeed(streamType uint8, marker byte) []byte {
+
+	data := ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))
+
+	program := ts.Program{PCRPID: 0x100, Streams: []ts.ES{{Type: streamType, PID: 0x100}}}
+	data = append(data, ts.Encode(ts.Header{PID: 0x1000, PUSI: true}, ts.PMTPayload(1, program, 0))...)
+
+	for i := 0; i < 3; i++ {
+		data = append(data, ts.Encode(ts.Header{PID: 0x100, PUSI: i == 0, CC: uint8(i)}, bytes.Repeat([]byte{marker}, 184))...)
+	}
+
+	return data
+}
+
+// testPackets sorts the packets in data by PID
+func testPackets(t *testing.T, data []byte) map[uint16][][]byte {
+
+	if len(data)%ts.PacketSize != 0 {
+		t.Fatalf("Output is not whole packets, length %d", len(data))
+	}
+
+	packets := make(map[uint16][][]byte)
+
+	for ; len(data) > 0; data = data[ts.PacketSize:] {
+		p := data[:ts.PacketSize]
+		if !ts.Valid(p) {
+			t.Fatal("Invalid packet in output")
+		}
+		packets[ts.PID(p)] = append(packets[ts.PID(p)], p)
+	}
+
+	return packets
+}
+
+func TestMuxer(t *testing.T) {
+
+	m := NewMuxer(2)
+
+	video := testFeed(0x1b, 'v')
+	audio := testFeed(0x0f, 'a')
+
+	// split the audio mid-packet
+	out := m.Mux(1, audio[:300])
+	out = append(out, m.Mux(0, video)...)
+	out = append(out, m.Mux(1, audio[300:])...)
+	out = append(out, m.Mux(0, []byte("not a packet, and not a multiple of the packet size either"))...)
+
+	packets := testPackets(t, out)
+
+	if len(packets[0]) < 1 {
+		t.Fatal("No PAT")
+	}
+
+	programs, ok := ts.PAT(packets[0][len(packets[0])-1])
+	if !ok || len(programs) != 1 || programs[MuxProgram] != MuxPMTPID {
+		t.Fatalf("Wrong PAT %v", programs)
+	}
+
+	if len(packets[MuxPMTPID]) < 1 {
+		t.Fatal("No PMT")
+	}
+
+	number, program, ok := ts.PMT(packets[MuxPMTPID][len(packets[MuxPMTPID])-1])
+	if !ok || number != MuxProgram {
+		t.Fatalf("Wrong PMT, program %d", number)
+	}
+
+	if len(program.Streams) != 2 {
+		t.Fatalf("Wrong number of streams in PMT; got/wanted %d/%d", len(program.Streams), 2)
+	}
+
+	if program.Streams[0].Type != 0x1b || program.Streams[1].Type != 0x0f {
+		t.Errorf("Wrong stream order or types %+v", program.Streams)
+	}
+
+	if program.PCRPID != program.Streams[0].PID {
+		t.Errorf("PCR not taken from the first feed; got/wanted %x/%x", program.PCRPID, program.Streams[0].PID)
+	}
+
+	for i, marker := range []byte{'v', 'a'} {
+		pid := program.Streams[i].PID
+		if pid == 0x100 && i == 1 {
+			t.Error("PIDs collide")
+		}
+		if len(packets[pid]) != 3 {
+			t.Errorf("Wrong number of packets for %c; got/wanted %d/%d", marker, len(packets[pid]), 3)
+			continue
+		}
+		for _, p := range packets[pid] {
+			if ts.Payload(p)[0] != marker {
+				t.Errorf("Wrong payload on PID %x; got/wanted %c/%c", pid, ts.Payload(p)[0], marker)
+			}
+		}
+	}
+
+	// the input is shared with other clients, so must not be changed
+	if ts.PID(video[2*ts.PacketSize:]) != 0x100 {
+		t.Error("Input was modified")
+	}
+
+	// with nothing changed, only the first feed's PAT brings the tables
+	packets = testPackets(t, m.Mux(1, audio))
+	if len(packets[0]) != 0 {
+		t.Error("Tables repeated for second feed")
+	}
+
+	packets = testPackets(t, m.Mux(0, video))
+	if len(packets[0]) != 1 || len(packets[MuxPMTPID]) != 1 {
+		t.Error("Tables not repeated for first feed")
+	}
+}
+
+func TestStreamMux(t *testing.T) {
+	h := New()
+	closed := make(chan struct{})
+	defer close(closed)
+	go h.Run(closed)
+
+	stream := "stream/large"
+
+	h.Add <- Rule{Stream: stream, Feeds: []string{"video0", "audio0"}, Mux: true}
+
+	c := &hub.Client{Hub: h.Hub, Name: "aa", Topic: stream, Send: make(chan hub.Message, 10), Stats: hub.NewClientStats()}
+
+	h.Register <- c
+
+	c1 := &hub.Client{Hub: h.Hub, Name: "1", Topic: "video0", Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+	c2 := &hub.Client{Hub: h.Hub, Name: "2", Topic: "audio0", Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+
+	time.Sleep(time.Millisecond)
+
+	h.Broadcast <- hub.Message{Data: testFeed(0x1b, 'v'), Sender: *c1, Sent: time.Now()}
+	time.Sleep(time.Millisecond)
+	h.Broadcast <- hub.Message{Data: testFeed(0x0f, 'a'), Sender: *c2, Sent: time.Now()}
+
+	var out []byte
+
+	timer := time.NewTimer(10 * time.Millisecond)
+COLLECT:
+	for {
+		select {
+		case msg := <-c.Send:
+			out = append(out, msg.Data...)
+		case <-timer.C:
+			break COLLECT
+		}
+	}
+
+	packets := testPackets(t, out)
+
+	_, program, ok := ts.PMT(packets[MuxPMTPID][len(packets[MuxPMTPID])-1])
+	if !ok || len(program.Streams) != 2 {
+		t.Fatalf("Wrong PMT %+v", program)
+	}
+
+	if len(packets[program.Streams[0].PID]) != 3 || len(packets[program.Streams[1].PID]) != 3 {
+		t.Error("Missing packets")
+	}
+}
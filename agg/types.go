@@ -1,6 +1,9 @@
 package agg
 
 import (
+	"sync"
+
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 )
 
@@ -11,17 +14,27 @@ type Hub struct {
 	Unregister chan *hub.Client
 	Add        chan Rule
 	Delete     chan string
+	Ping       chan struct{} //received whenever the loop is responsive
+	Events     *events.Bus   //optional, nil is ok
 	Rules      map[string][]string
+	Muxed      map[string]bool              //streams whose feeds are muxed into one program
+	Filters    map[string]map[string]Filter //map stream to feed to Filter
 	Streams    map[string]map[*hub.Client]bool
 	SubClients map[*hub.Client]map[*SubClient]bool
+	mux        sync.Mutex //guards Rules, Muxed and Filters, for Rule() and Snapshot()
 }
 
 type Rule struct {
-	Stream string   `json:"stream"`
-	Feeds  []string `json:"feeds"`
+	Stream  string            `json:"stream"`
+	Feeds   []string          `json:"feeds"`
+	Mux     bool              `json:"mux,omitempty"`     //combine MPEG-TS feeds into one program
+	Filters map[string]Filter `json:"filters,omitempty"` //map feed to Filter
 }
 
 type SubClient struct {
 	Client  *hub.Client
 	Stopped chan struct{}
+	Muxer   *Muxer //nil unless the stream is muxed
+	Index   int    //of the feed in the stream rule, for the Muxer
+	filter  *filter
 }
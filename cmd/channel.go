@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/rwc"
+)
+
+// Channels carry the WS/JSON API to remote endpoints. Each has its own
+// destination, token and scope, so that a dashboard can watch without
+// being able to change anything, next to a booking system that can.
+// A channel is a destination like any other, with the channel's name as
+// its id, plus an internalAPI answering commands on the channel's topic.
+type Channel struct {
+	Name        string `json:"name"`
+	Destination string `json:"destination,omitempty"` //none for one that only answers local clients on /ws/<topic>
+	Token       string `json:"token,omitempty"`
+	Scope       string `json:"scope"`
+}
+
+// Scopes, from least to most privileged
+const (
+	ScopeRead  = "read"  // list, status and events
+	ScopeWrite = "write" // also change rules, except for those of channels
+	ScopeAdmin = "admin" // also add and delete channels
+)
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// apiChannel is the channel set by VW_API, which keeps the id and
+// topic that it had before there were other channels
+const apiChannel = "apiRule"
+
+// channel is a running Channel
+type channel struct {
+	Channel
+	done chan struct{} //closed to stop its internalAPI
+}
+
+var errNoChannelName = errors.New("Channel needs a name")
+var errBadScope = errors.New("Scope must be read, write or admin")
+var errChannelNotFound = errors.New("Channel not found")
+var errScope = errors.New("Not allowed on this channel")
+var errChannelRule = errors.New("Only an admin channel can change the rules of channels")
+var errNoDeleteChannel = errors.New("Cannot delete the channel in use")
+var errRestartScope = errors.New("Only an admin channel can add a watchdog that restarts")
+
+// checkChannel checks a channel before it is added
+func checkChannel(c Channel) error {
+
+	if c.Name == "" || c.Name == "all" || c.Name == "deleteAll" {
+		return errNoChannelName
+	}
+
+	if _, ok := scopeLevels[c.Scope]; !ok {
+		return errBadScope
+	}
+
+	return nil
+}
+
+// topic is where the channel's internalAPI listens
+func (c Channel) topic() string {
+	if c.Name == apiChannel {
+		return "api"
+	}
+	return "api/" + c.Name
+}
+
+func (c Channel) rule() rwc.Rule {
+	return rwc.Rule{Id: c.Name, Stream: c.topic(), Destination: c.Destination, Token: c.Token}
+}
+
+// allows is true if a scope includes another
+func allows(have, need string) bool {
+	return scopeLevels[have] >= scopeLevels[need]
+}
+
+// addChannel adds or replaces a channel. A channel that is replaced keeps
+// its internalAPI, and its connection if the destination and token are
+// the same
+func (app *App) addChannel(c Channel) {
+
+	app.channelMux.Lock()
+
+	if app.channels == nil {
+		app.channels = make(map[string]*channel)
+	}
+
+	ch, ok := app.channels[c.Name]
+	if ok {
+		ch.Channel = c
+	} else {
+		ch = &channel{Channel: c, done: make(chan struct{})}
+		app.channels[c.Name] = ch
+	}
+
+	app.channelMux.Unlock()
+
+	if !ok {
+		go app.internalAPI(ch)
+	}
+
+	if c.Destination != "" {
+		app.Websocket.Add <- c.rule()
+	} else if ok {
+		app.Websocket.Delete <- c.Name
+	}
+
+	log.WithFields(log.Fields{"name": c.Name, "scope": c.Scope, "destination": c.Destination}).Info("Added channel")
+}
+
+// deleteChannel stops a channel and deletes its destination
+func (app *App) deleteChannel(name string) bool {
+
+	app.channelMux.Lock()
+	ch, ok := app.channels[name]
+	delete(app.channels, name)
+	app.channelMux.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	close(ch.done)
+	app.Websocket.Delete <- name
+
+	log.WithField("name", name).Info("Deleted channel")
+
+	return true
+}
+
+// channelList returns every channel
+func (app *App) channelList() map[string]Channel {
+
+	app.channelMux.Lock()
+	defer app.channelMux.Unlock()
+
+	list := make(map[string]Channel)
+
+	for name, ch := range app.channels {
+		list[name] = ch.Channel
+	}
+
+	return list
+}
+
+// scopeOf returns the current scope of a channel, which may have been
+// changed since it was added
+func (app *App) scopeOf(ch *channel) string {
+	app.channelMux.Lock()
+	defer app.channelMux.Unlock()
+	return ch.Scope
+}
+
+func (app *App) isChannel(id string) bool {
+	app.channelMux.Lock()
+	defer app.channelMux.Unlock()
+	_, ok := app.channels[id]
+	return ok
+}
+
+func (app *App) isChannelTopic(topic string) bool {
+
+	app.channelMux.Lock()
+	defer app.channelMux.Unlock()
+
+	for _, ch := range app.channels {
+		if ch.topic() == topic {
+			return true
+		}
+	}
+
+	return false
+}
+
+// guard stops a command that isn't from an admin channel from touching
+// the destinations or topics of channels. Otherwise it could take over
+// a channel with more scope, by sending commands on its topic, or listen
+// in on one.
+func (app *App) guard(cmd Command, ids []string, topics []string) error {
+
+	if allows(cmd.scope(), ScopeAdmin) {
+		return nil
+	}
+
+	for _, id := range ids {
+		if app.isChannel(id) {
+			return forbidden(errChannelRule)
+		}
+	}
+
+	for _, topic := range topics {
+		if app.isChannelTopic(topic) {
+			return forbidden(errChannelRule)
+		}
+	}
+
+	return nil
+}
+
+// redact removes the token from a destination for those without admin
+// scope, so it can't be used to send to the relay, or to connect as a
+// channel, whose destination is just like any other
+func redact(cmd Command, rule rwc.Rule) rwc.Rule {
+	if !allows(cmd.scope(), ScopeAdmin) {
+		rule.Token = ""
+	}
+	return rule
+}
+
+// redactDestinations removes the tokens from destinations, e.g. in the config
+func redactDestinations(rules []rwc.Rule) []rwc.Rule {
+
+	redacted := []rwc.Rule{}
+
+	for _, rule := range rules {
+		rule.Token = ""
+		redacted = append(redacted, rule)
+	}
+
+	return redacted
+}
+
+// redactChannels removes the tokens from channels, e.g. in the config
+func redactChannels(channels []Channel) []Channel {
+
+	redacted := []Channel{}
+
+	for _, c := range channels {
+		c.Token = ""
+		redacted = append(redacted, c)
+	}
+
+	return redacted
+}
+
+func forbidden(err error) error {
+	return apiError{Status: http.StatusForbidden, Err: err}
+}
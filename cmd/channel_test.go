@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/rwc"
+)
+
+func TestChannelScopes(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	a.addChannel(Channel{Name: "dashboard", Destination: "ws://localhost:1/bi/dashboard", Token: "secret", Scope: ScopeRead})
+	a.addChannel(Channel{Name: "booking", Scope: ScopeWrite})
+	a.addChannel(Channel{Name: "admin", Scope: ScopeAdmin})
+
+	time.Sleep(10 * time.Millisecond)
+
+	tests := []struct {
+		channel, command string
+		want             error
+	}{
+		{"dashboard", `{"verb":"list","what":"stream","which":"all"}`, nil},
+		{"dashboard", `{"verb":"add","what":"stream","rule":{"stream":"video0","feeds":["video0"]}}`, errScope},
+		{"booking", `{"verb":"add","what":"stream","rule":{"stream":"video0","feeds":["video0"]}}`, nil},
+		{"booking", `{"verb":"add","what":"stream","rule":{"stream":"video0","feeds":["api/dashboard"]}}`, errChannelRule},
+		{"booking", `{"verb":"add","what":"destination","rule":{"id":"dashboard","stream":"video0","destination":"ws://localhost:1/in"}}`, errChannelRule},
+		{"booking", `{"verb":"add","what":"destination","rule":{"id":"00","stream":"api/admin","destination":"ws://localhost:1/in"}}`, errChannelRule},
+		{"booking", `{"verb":"start","what":"replay","rule":{"feed":"api/admin","file":"x.ts"}}`, errChannelRule},
+		{"booking", `{"verb":"delete","what":"destination","which":"dashboard"}`, errChannelRule},
+		{"booking", `{"verb":"add","what":"watchdog","rule":{"feed":"video0","thresholdMs":100,"actions":["failover"],"failover":"api/admin"}}`, errChannelRule},
+		{"booking", `{"verb":"add","what":"watchdog","rule":{"feed":"api/admin","thresholdMs":100,"actions":["event"]}}`, errChannelRule},
+		{"booking", `{"verb":"add","what":"watchdog","rule":{"feed":"video0","thresholdMs":100,"actions":["restart"]}}`, errRestartScope},
+		{"booking", `{"verb":"add","what":"watchdog","rule":{"feed":"video0","thresholdMs":100,"actions":["event"]}}`, nil},
+		{"booking", `{"verb":"add","what":"channel","rule":{"name":"other","scope":"admin"}}`, errScope},
+		{"admin", `{"verb":"delete","what":"channel","which":"admin"}`, errNoDeleteChannel},
+		{"admin", `{"verb":"add","what":"channel","rule":{"name":"other","scope":"root"}}`, errBadScope},
+		{"admin", `{"verb":"delete","what":"destination","which":"dashboard"}`, nil},
+		{"admin", `{"verb":"add","what":"watchdog","rule":{"feed":"video0","thresholdMs":100,"actions":["restart"]}}`, nil},
+		{"admin", `{"verb":"list","what":"channel","which":"dashboard"}`, errChannelNotFound},
+	}
+
+	for _, tt := range tests {
+		_, err := a.handleChannelMessage(a.channels[tt.channel], []byte(tt.command))
+		if err != tt.want {
+			t.Errorf("%s on %s got/wanted error %v/%v", tt.command, tt.channel, err, tt.want)
+		}
+	}
+}
+
+func TestChannelRedactsToken(t *testing.T) {
+
+	a := testApp(false)
+
+	dashboard := Channel{Name: "dashboard", Destination: "ws://localhost:1/bi/dashboard", Token: "secret", Scope: ScopeRead}
+	ch := &channel{Channel: dashboard}
+	a.channels = map[string]*channel{"dashboard": ch}
+	a.Websocket.Rules["dashboard"] = dashboard.rule()
+
+	reply, err := a.handleChannelMessage(ch, []byte(`{"verb":"list","what":"destination","which":"dashboard"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":"dashboard","stream":"api/dashboard","destination":"ws://localhost:1/bi/dashboard","token":""}`
+	if string(reply) != expected {
+		t.Errorf("Got wrong rule %s/%s", reply, expected)
+	}
+
+	reply, err = a.handleAdminMessage([]byte(`{"verb":"list","what":"channel","which":"dashboard"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `{"name":"dashboard","destination":"ws://localhost:1/bi/dashboard","token":"secret","scope":"read"}`
+	if string(reply) != expected {
+		t.Errorf("Got wrong channel %s/%s", reply, expected)
+	}
+
+	// nor can it see the tokens of ordinary destinations, even in the config
+	relay := rwc.Rule{Id: "0", Stream: "stream/front", Destination: "wss://relay/in/front", Token: "relay-secret"}
+	a.Websocket.Rules["0"] = relay
+	a.Config.Destinations = []rwc.Rule{relay}
+
+	for _, message := range []string{`{"verb":"list","what":"destination","which":"all"}`,
+		`{"verb":"list","what":"destination","which":"0"}`,
+		`{"verb":"list","what":"config"}`} {
+
+		reply, err = a.handleChannelMessage(ch, []byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(reply), "secret") {
+			t.Errorf("Token not redacted from %s", reply)
+		}
+	}
+
+	if a.Config.Destinations[0].Token != "relay-secret" {
+		t.Error("Redacted the config itself")
+	}
+
+	reply, err = a.handleAdminMessage([]byte(`{"verb":"list","what":"destination","which":"all"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(reply), "relay-secret") {
+		t.Errorf("Token redacted for admin %s", reply)
+	}
+}
+
+func TestDiffConfigChannels(t *testing.T) {
+
+	read := Channel{Name: "dashboard", Destination: "ws://localhost:1/bi/dashboard", Scope: ScopeRead}
+	write := Channel{Name: "booking", Destination: "ws://localhost:1/bi/booking", Scope: ScopeWrite}
+	admin := Channel{Name: "admin", Destination: "ws://localhost:1/bi/admin", Scope: ScopeAdmin}
+
+	previous := Config{Channels: []Channel{read, write}}
+
+	changed := read
+	changed.Scope = ScopeWrite
+
+	next := Config{Channels: []Channel{changed, admin}}
+
+	live := map[string]Channel{"dashboard": read, "booking": write, apiChannel: {Name: apiChannel, Scope: ScopeAdmin}}
+
+	diff := diffConfig(previous, next, nil, map[string]rwc.Rule{}, live)
+
+	want := ConfigDiff{ChannelsAdded: []string{"admin"}, ChannelsChanged: []string{"dashboard"}, ChannelsDeleted: []string{"booking"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Wrong diff; got/wanted\n%+v\n%+v", diff, want)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/reconws"
+)
+
+var clientToken string
+var clientBinary bool
+var clientNoClose bool
+var clientVerbose bool
+var clientTimeoutMs int
+
+var errClientTimeout = errors.New("Timed out connecting")
+
+func init() {
+	rootCmd.AddCommand(clientCmd)
+	clientCmd.Flags().StringVar(&clientToken, "token", "", "token for the server, if it needs one, as for a destination")
+	clientCmd.Flags().BoolVar(&clientBinary, "binary", false, "send each line as a binary message, instead of as text")
+	clientCmd.Flags().BoolVarP(&clientNoClose, "no-close", "n", false, "keep printing messages after stdin ends")
+	clientCmd.Flags().IntVar(&clientTimeoutMs, "timeout-ms", 5000, "how long to wait to connect, and for the token to be accepted")
+	clientCmd.Flags().BoolVarP(&clientVerbose, "verbose", "v", false, "log the connection to stderr")
+}
+
+var clientCmd = &cobra.Command{
+	Use:   "client <feed>|<url>",
+	Short: "Send lines from stdin to a feed, and print the messages from it",
+	Long: `client connects to a feed on the local instance, at ws://localhost:VW_PORT/ws/<feed>,
+or to any websocket server if given a ws:// or wss:// url. Each line of stdin is sent
+as a message, and each message received is written to stdout, with a newline after
+text messages. It exits when stdin ends, unless --no-close is set, or when the server
+closes the connection. If --token is set, it is sent first, as it would be by a
+destination with a token. It exits with the same codes as the streams command.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		log.SetOutput(ioutil.Discard)
+		if clientVerbose {
+			log.SetOutput(os.Stderr)
+			log.SetLevel(log.DebugLevel)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		channelSignal := make(chan os.Signal, 1)
+		signal.Notify(channelSignal, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-channelSignal
+			cancel()
+		}()
+
+		timeout := time.Duration(clientTimeoutMs) * time.Millisecond
+
+		err := runClient(ctx, clientURL(args[0], localSpecification().Port), clientToken, timeout, os.Stdin, os.Stdout)
+
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "Error:", err)
+
+		if e, ok := err.(remoteError); ok {
+			os.Exit(e.Code)
+		}
+
+		os.Exit(exitFailed)
+	},
+}
+
+// clientURL is the url as given, or else the url of the feed on the
+// local instance
+func clientURL(feed string, port int) string {
+
+	if strings.HasPrefix(feed, "ws://") || strings.HasPrefix(feed, "wss://") {
+		return feed
+	}
+
+	return fmt.Sprintf("ws://localhost:%d/ws/%s", port, trimPath(feed))
+}
+
+// runClient sends lines from in to the server at url, and writes the
+// messages from it to out, until the connection closes or ctx is done.
+// It returns an error with exitUnavailable if it could not connect within
+// the timeout, or with exitFailed if the token was refused or in could
+// not be read.
+func runClient(ctx context.Context, url, token string, timeout time.Duration, in io.Reader, out io.Writer) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the connection is followed through its events, as for a destination
+	bus := events.New()
+	connection := bus.Subscribe(10)
+	defer bus.Unsubscribe(connection)
+
+	r := reconws.New()
+	r.Events = bus
+	r.Id = "client"
+
+	dialed := make(chan error, 1)
+	failed := make(chan error, 1)
+
+	go func() {
+		if token == "" {
+			dialed <- r.Dial(ctx, url)
+		} else {
+			dialed <- r.DialAuth(ctx, url, token)
+		}
+	}()
+
+	mt := websocket.TextMessage
+	if clientBinary {
+		mt = websocket.BinaryMessage
+	}
+
+	var refused bool
+
+	// a server that is not expecting a token never replies to it,
+	// so the timeout covers the token being accepted too
+	connecting := time.After(timeout)
+
+	handle := func(e events.Event) {
+		switch e.Kind {
+		case events.DestinationConnected:
+			connecting = nil
+			// start sending now, so that input ending early
+			// cannot stop the dial
+			go sendLines(ctx, cancel, in, r.Out, mt, failed)
+		case events.DestinationAuthFailed:
+			refused = true
+		}
+	}
+
+	for {
+		select {
+
+		case msg := <-r.In:
+			out.Write(msg.Data)
+			if msg.Type == websocket.TextMessage {
+				io.WriteString(out, "\n")
+			}
+
+		case e := <-connection:
+			handle(e)
+
+		case <-connecting:
+			return remoteError{exitUnavailable, errClientTimeout}
+
+		case err := <-failed:
+			return remoteError{exitFailed, err}
+
+		case err := <-dialed:
+
+			for len(connection) > 0 {
+				handle(<-connection)
+			}
+
+			// the dial ends when sendLines cancels it, so check
+			// whether that was because in could not be read
+			select {
+			case err := <-failed:
+				return remoteError{exitFailed, err}
+			default:
+			}
+
+			switch {
+			case err == nil:
+				return nil
+			case refused:
+				return remoteError{exitFailed, err}
+			default:
+				return remoteError{exitUnavailable, err}
+			}
+		}
+	}
+}
+
+// sendLines sends each line from in as a message, then closes the
+// connection when in ends, unless --no-close is set. If in cannot be
+// read, such as when a line is longer than maxMessageSize, the error is
+// sent on failed and the connection is closed whatever the setting.
+func sendLines(ctx context.Context, cancel context.CancelFunc, in io.Reader, send chan reconws.WsMessage, mt int, failed chan error) {
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+
+	for scanner.Scan() {
+
+		data := make([]byte, len(scanner.Bytes()))
+		copy(data, scanner.Bytes())
+
+		select {
+		case send <- reconws.WsMessage{Data: data, Type: mt}:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		failed <- err
+		cancel()
+		return
+	}
+
+	if !clientNoClose {
+		cancel()
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	crossbar "github.com/timdrysdale/crossbar/cmd"
+)
+
+// echoAuth sends back every message, after checking the token if there is one
+func echoAuth(authToken string) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		c, err := testUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		if authToken != "" {
+
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			reply := crossbar.AuthMessage{Authorised: string(message) == authToken, Reason: "Denied"}
+			message, _ = json.Marshal(&reply)
+
+			if c.WriteMessage(mt, message) != nil || !reply.Authorised {
+				return
+			}
+		}
+
+		for {
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				break
+			}
+			c.WriteMessage(mt, message)
+		}
+	}
+}
+
+func TestClientURL(t *testing.T) {
+
+	if u := clientURL("/pendulum", 8888); u != "ws://localhost:8888/ws/pendulum" {
+		t.Errorf("Wrong local url %s", u)
+	}
+
+	if u := clientURL("wss://relay.practable.io/bi/demo/data", 8888); u != "wss://relay.practable.io/bi/demo/data" {
+		t.Errorf("Wrong remote url %s", u)
+	}
+}
+
+func TestClientEcho(t *testing.T) {
+
+	for _, token := range []string{"", "secret"} {
+
+		server := httptest.NewServer(echoAuth(token))
+		url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+
+		done := make(chan error)
+		go func() { done <- runClient(context.Background(), url, token, time.Second, inR, outW) }()
+
+		received := bufio.NewReader(outR)
+
+		for _, line := range []string{"hello", "world"} {
+
+			io.WriteString(inW, line+"\n")
+
+			got, err := received.ReadString('\n')
+			if err != nil || got != line+"\n" {
+				t.Errorf("Wrong message %q %v", got, err)
+			}
+		}
+
+		inW.Close()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Did not close when stdin ended")
+		}
+
+		server.Close()
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+
+	server := httptest.NewServer(echoAuth("secret"))
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	err := runClient(context.Background(), url, "wrong", time.Second, strings.NewReader(""), ioutil.Discard)
+	if e, ok := err.(remoteError); !ok || e.Code != exitFailed {
+		t.Errorf("Wrong error for a refused token %v", err)
+	}
+
+	server.Close()
+
+	// a server that is not expecting a token does not reply to it
+	silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := testUpgrader.Upgrade(w, r, nil); err == nil {
+			c.ReadMessage()
+			time.Sleep(time.Second)
+			c.Close()
+		}
+	}))
+	defer silent.Close()
+
+	err = runClient(context.Background(), "ws"+strings.TrimPrefix(silent.URL, "http"), "secret", 100*time.Millisecond, strings.NewReader(""), ioutil.Discard)
+	if e, ok := err.(remoteError); !ok || e.Code != exitUnavailable || e.Err != errClientTimeout {
+		t.Errorf("Wrong error for a silent server %v", err)
+	}
+
+	err = runClient(context.Background(), url, "", time.Second, strings.NewReader(""), ioutil.Discard)
+	if e, ok := err.(remoteError); !ok || e.Code != exitUnavailable {
+		t.Errorf("Wrong error for no server %v", err)
+	}
+
+	// a line too long to send must not be dropped silently
+	echo := httptest.NewServer(echoAuth(""))
+	defer echo.Close()
+
+	long := strings.Repeat("x", maxMessageSize+1) + "\n"
+
+	err = runClient(context.Background(), "ws"+strings.TrimPrefix(echo.URL, "http"), "", time.Second, strings.NewReader(long), ioutil.Discard)
+	if e, ok := err.(remoteError); !ok || e.Code != exitFailed || e.Err != bufio.ErrTooLong {
+		t.Errorf("Wrong error for a line that is too long %v", err)
+	}
+}
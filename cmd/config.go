@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/rwc"
+)
+
+// Config holds the rules read from VW_CONFIG_FILE
+type Config struct {
+	Streams      []agg.Rule `json:"streams"`
+	Destinations []rwc.Rule `json:"destinations"`
+	Channels     []Channel  `json:"channels,omitempty"`
+}
+
+// ConfigDiff lists what a reload changed, by stream name, destination id
+// and channel name
+type ConfigDiff struct {
+	StreamsAdded        []string `json:"streamsAdded,omitempty"`
+	StreamsChanged      []string `json:"streamsChanged,omitempty"`
+	StreamsDeleted      []string `json:"streamsDeleted,omitempty"`
+	DestinationsAdded   []string `json:"destinationsAdded,omitempty"`
+	DestinationsChanged []string `json:"destinationsChanged,omitempty"`
+	DestinationsDeleted []string `json:"destinationsDeleted,omitempty"`
+	ChannelsAdded       []string `json:"channelsAdded,omitempty"`
+	ChannelsChanged     []string `json:"channelsChanged,omitempty"`
+	ChannelsDeleted     []string `json:"channelsDeleted,omitempty"`
+}
+
+var errNoConfigFile = errors.New("no config file set")
+var errConfigChannel = errors.New("is a channel that the config file does not own")
+
+func loadConfig(name string) (Config, error) {
+
+	var config Config
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	streams := make(map[string]bool)
+
+	for i, rule := range config.Streams {
+		rule.Stream = strings.TrimPrefix(rule.Stream, "/") //to match trimming we do in handleStreamAdd
+		if rule.Stream == "" || rule.Stream == "deleteAll" {
+			return config, fmt.Errorf("stream %d: bad name %q", i, rule.Stream)
+		}
+		if streams[rule.Stream] {
+			return config, fmt.Errorf("stream %s: duplicated", rule.Stream)
+		}
+		if err := agg.Check(rule); err != nil {
+			return config, fmt.Errorf("stream %s: %s", rule.Stream, err)
+		}
+		streams[rule.Stream] = true
+		config.Streams[i] = rule
+	}
+
+	ids := make(map[string]bool)
+
+	for i, rule := range config.Destinations {
+		rule.Stream = strings.TrimPrefix(rule.Stream, "/")
+		for j, stream := range rule.Streams {
+			rule.Streams[j] = strings.TrimPrefix(stream, "/")
+		}
+		if err := rwc.Check(rule); err != nil {
+			return config, fmt.Errorf("destination %d: %s", i, err)
+		}
+		if rule.Id == apiChannel {
+			return config, fmt.Errorf("destination %s: %s", rule.Id, errConfigChannel)
+		}
+		if ids[rule.Id] {
+			return config, fmt.Errorf("destination %s: duplicated", rule.Id)
+		}
+		ids[rule.Id] = true
+		config.Destinations[i] = rule
+	}
+
+	// a channel's name is also the id of its destination
+	for _, c := range config.Channels {
+		if err := checkChannel(c); err != nil {
+			return config, fmt.Errorf("channel %q: %s", c.Name, err)
+		}
+		if ids[c.Name] {
+			return config, fmt.Errorf("channel %s: duplicated", c.Name)
+		}
+		ids[c.Name] = true
+	}
+
+	return config, nil
+}
+
+// diffConfig compares the new config against the live rules. Rules that
+// are live but not in the config are only deleted if they came from the
+// previous config, so that rules added over the API are left alone.
+func diffConfig(previous, next Config, streams map[string]agg.Rule, destinations map[string]rwc.Rule, channels map[string]Channel) ConfigDiff {
+
+	var diff ConfigDiff
+
+	wanted := make(map[string]bool)
+
+	for _, rule := range next.Streams {
+		wanted[rule.Stream] = true
+		live, ok := streams[rule.Stream]
+		switch {
+		case !ok:
+			diff.StreamsAdded = append(diff.StreamsAdded, rule.Stream)
+		case !reflect.DeepEqual(normalStream(live), normalStream(rule)):
+			diff.StreamsChanged = append(diff.StreamsChanged, rule.Stream)
+		}
+	}
+
+	for _, rule := range previous.Streams {
+		if _, ok := streams[rule.Stream]; ok && !wanted[rule.Stream] {
+			diff.StreamsDeleted = append(diff.StreamsDeleted, rule.Stream)
+		}
+	}
+
+	wanted = make(map[string]bool)
+
+	for _, rule := range next.Destinations {
+		wanted[rule.Id] = true
+		live, ok := destinations[rule.Id]
+		switch {
+		case !ok:
+			diff.DestinationsAdded = append(diff.DestinationsAdded, rule.Id)
+		case !reflect.DeepEqual(normalDestination(live), normalDestination(rule)):
+			diff.DestinationsChanged = append(diff.DestinationsChanged, rule.Id)
+		}
+	}
+
+	for _, rule := range previous.Destinations {
+		if _, ok := destinations[rule.Id]; ok && !wanted[rule.Id] {
+			diff.DestinationsDeleted = append(diff.DestinationsDeleted, rule.Id)
+		}
+	}
+
+	wanted = make(map[string]bool)
+
+	for _, c := range next.Channels {
+		wanted[c.Name] = true
+		live, ok := channels[c.Name]
+		switch {
+		case !ok:
+			diff.ChannelsAdded = append(diff.ChannelsAdded, c.Name)
+		case live != c:
+			diff.ChannelsChanged = append(diff.ChannelsChanged, c.Name)
+		}
+	}
+
+	for _, c := range previous.Channels {
+		if _, ok := channels[c.Name]; ok && !wanted[c.Name] {
+			diff.ChannelsDeleted = append(diff.ChannelsDeleted, c.Name)
+		}
+	}
+
+	for _, list := range [][]string{diff.StreamsAdded, diff.StreamsChanged, diff.StreamsDeleted,
+		diff.DestinationsAdded, diff.DestinationsChanged, diff.DestinationsDeleted,
+		diff.ChannelsAdded, diff.ChannelsChanged, diff.ChannelsDeleted} {
+		sort.Strings(list)
+	}
+
+	return diff
+}
+
+// normalStream makes empty lists and maps nil, so that rules which only
+// differ in that compare equal, as they work the same
+func normalStream(rule agg.Rule) agg.Rule {
+
+	if len(rule.Feeds) == 0 {
+		rule.Feeds = nil
+	}
+
+	if len(rule.Filters) == 0 {
+		rule.Filters = nil
+		return rule
+	}
+
+	filters := make(map[string]agg.Filter)
+
+	for feed, f := range rule.Filters {
+		if len(f.PIDs) == 0 {
+			f.PIDs = nil
+		}
+		if len(f.Types) == 0 {
+			f.Types = nil
+		}
+		if len(f.Kinds) == 0 {
+			f.Kinds = nil
+		}
+		filters[feed] = f
+	}
+
+	rule.Filters = filters
+
+	return rule
+}
+
+// normalDestination makes an empty list of streams nil, as for normalStream
+func normalDestination(rule rwc.Rule) rwc.Rule {
+
+	if len(rule.Streams) == 0 {
+		rule.Streams = nil
+	}
+
+	return rule
+}
+
+// checkChannels stops a destination in the config from replacing the
+// connection of a channel that the config does not own, i.e. one added
+// over the API, or from VW_API
+func checkChannels(previous, next Config, channels map[string]Channel) error {
+
+	owned := make(map[string]bool)
+
+	for _, c := range previous.Channels {
+		owned[c.Name] = true
+	}
+
+	for _, rule := range next.Destinations {
+		if _, ok := channels[rule.Id]; ok && !owned[rule.Id] {
+			return fmt.Errorf("destination %s: %s", rule.Id, errConfigChannel)
+		}
+	}
+
+	return nil
+}
+
+// currentConfig returns the rules last applied from the config file
+func (app *App) currentConfig() Config {
+	app.configMux.Lock()
+	defer app.configMux.Unlock()
+	return app.Config
+}
+
+// reloadConfig re-reads Opts.ConfigFile and applies only what has changed,
+// so unchanged destinations keep their connections
+func (app *App) reloadConfig() (ConfigDiff, error) {
+
+	if app.Opts.ConfigFile == "" {
+		return ConfigDiff{}, errNoConfigFile
+	}
+
+	app.configMux.Lock()
+	defer app.configMux.Unlock()
+
+	next, err := loadConfig(app.Opts.ConfigFile)
+	if err == nil {
+		err = checkChannels(app.Config, next, app.channelList())
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"file": app.Opts.ConfigFile, "error": err}).Error("Could not load config")
+		app.Events.Publish(events.Event{Kind: events.ConfigFailed, Id: app.Opts.ConfigFile, Detail: err.Error()})
+		return ConfigDiff{}, err
+	}
+
+	diff := diffConfig(app.Config, next, app.Hub.Snapshot(), app.Websocket.Snapshot(), app.channelList())
+
+	streams := make(map[string]agg.Rule)
+	for _, rule := range next.Streams {
+		streams[rule.Stream] = rule
+	}
+
+	destinations := make(map[string]rwc.Rule)
+	for _, rule := range next.Destinations {
+		destinations[rule.Id] = rule
+	}
+
+	channels := make(map[string]Channel)
+	for _, c := range next.Channels {
+		channels[c.Name] = c
+	}
+
+	for _, name := range diff.ChannelsDeleted {
+		app.deleteChannel(name)
+	}
+
+	for _, id := range diff.DestinationsDeleted {
+		app.Websocket.Delete <- id
+	}
+
+	for _, stream := range diff.StreamsDeleted {
+		app.Hub.Delete <- stream
+	}
+
+	for _, stream := range append(diff.StreamsAdded, diff.StreamsChanged...) {
+		app.Hub.Add <- streams[stream]
+	}
+
+	for _, id := range append(diff.DestinationsAdded, diff.DestinationsChanged...) {
+		app.Websocket.Add <- destinations[id]
+	}
+
+	for _, name := range append(diff.ChannelsAdded, diff.ChannelsChanged...) {
+		app.addChannel(channels[name])
+	}
+
+	app.Config = next
+
+	detail, _ := json.Marshal(diff)
+
+	log.WithFields(log.Fields{"file": app.Opts.ConfigFile, "diff": diff}).Info("Reloaded config")
+	app.Events.Publish(events.Event{Kind: events.ConfigReloaded, Id: app.Opts.ConfigFile, Detail: string(detail)})
+
+	return diff, nil
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/rwc"
+)
+
+func TestConfigReload(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "vw-config-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	write := func(s string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := testApp(true)
+	defer close(a.Closed)
+	a.Opts.ConfigFile = f.Name()
+
+	write(`{"streams":[{"stream":"/stream/front","feeds":["video0","audio0"]},{"stream":"stream/back","feeds":["video1"]}],
+"destinations":[{"id":"0","stream":"stream/front","destination":"ws://localhost:1/front"},
+{"id":"1","stream":"stream/back","destination":"ws://localhost:1/back"}]}`)
+
+	diff, err := a.reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ConfigDiff{StreamsAdded: []string{"stream/back", "stream/front"}, DestinationsAdded: []string{"0", "1"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Wrong diff; got/wanted\n%+v\n%+v", diff, want)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// a rule added over the API should survive reloads
+	a.Websocket.Add <- rwc.Rule{Id: "api", Stream: "stream/back", Destination: "ws://localhost:1/api"}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// the loop has finished changing Clients once it takes a ping
+	a.Websocket.Ping <- struct{}{}
+	front := a.Websocket.Clients["0"]
+
+	write(`{"streams":[{"stream":"stream/front","feeds":["video0","audio0"]}],
+"destinations":[{"id":"0","stream":"stream/front","destination":"ws://localhost:1/front"},
+{"id":"2","stream":"stream/front","destination":"ws://localhost:1/other"}]}`)
+
+	diff, err = a.reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = ConfigDiff{StreamsDeleted: []string{"stream/back"}, DestinationsAdded: []string{"2"}, DestinationsDeleted: []string{"1"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Wrong diff; got/wanted\n%+v\n%+v", diff, want)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	a.Websocket.Ping <- struct{}{}
+	if a.Websocket.Clients["0"] != front {
+		t.Error("Unchanged destination was reconnected")
+	}
+
+	destinations := a.Websocket.Snapshot()
+
+	for _, id := range []string{"0", "2", "api"} {
+		if _, ok := destinations[id]; !ok {
+			t.Errorf("Missing destination %s", id)
+		}
+	}
+
+	if _, ok := destinations["1"]; ok {
+		t.Error("Destination 1 not deleted")
+	}
+
+	if _, ok := a.Hub.Snapshot()["stream/back"]; ok {
+		t.Error("Stream not deleted")
+	}
+
+	write(`{"streams":[{"stream":"stream/front","feeds":["video0","audio0"],"mux":true}],
+"destinations":[{"id":"0","stream":"stream/front","destination":"ws://localhost:1/front"},
+{"id":"2","stream":"stream/front","destination":"ws://localhost:1/other"}]}`)
+
+	diff, err = a.reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = ConfigDiff{StreamsChanged: []string{"stream/front"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("Wrong diff; got/wanted\n%+v\n%+v", diff, want)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	write(`{"streams":[{"stream":"stream/front"}],"destinations":[{"id":"0","destination":"ws://localhost:1/front"}]}`)
+
+	if _, err := a.reloadConfig(); err == nil {
+		t.Error("Accepted destination without a stream")
+	}
+
+	if len(a.Config.Destinations) != 2 {
+		t.Error("Failed reload changed the applied config")
+	}
+}
+
+func TestDiffConfigEmpty(t *testing.T) {
+
+	// as read from a config file, without the optional lists
+	next := Config{Streams: []agg.Rule{{Stream: "stream/front", Feeds: []string{"video0"},
+		Filters: map[string]agg.Filter{"video0": {Drop: true}}}},
+		Destinations: []rwc.Rule{{Id: "0", Stream: "stream/front", Destination: "ws://localhost:1/front"}}}
+
+	// as added over the API, with them empty
+	streams := map[string]agg.Rule{"stream/front": {Stream: "stream/front", Feeds: []string{"video0"},
+		Filters: map[string]agg.Filter{"video0": {PIDs: []uint16{}, Kinds: []string{}, Drop: true}}}}
+	destinations := map[string]rwc.Rule{"0": {Id: "0", Stream: "stream/front", Destination: "ws://localhost:1/front", Streams: []string{}}}
+
+	if diff := diffConfig(Config{}, next, streams, destinations, nil); !reflect.DeepEqual(diff, ConfigDiff{}) {
+		t.Errorf("Unchanged rules reported as changed %+v", diff)
+	}
+
+	next.Streams[0].Filters = nil
+
+	if diff := diffConfig(Config{}, next, streams, destinations, nil); !reflect.DeepEqual(diff.StreamsChanged, []string{"stream/front"}) {
+		t.Errorf("Changed filter not reported %+v", diff)
+	}
+}
+
+func TestConfigChannelIds(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "vw-config-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	a := testApp(true)
+	defer close(a.Closed)
+	a.Opts.ConfigFile = f.Name()
+
+	a.addChannel(Channel{Name: apiChannel, Scope: ScopeAdmin})
+	a.addChannel(Channel{Name: "dashboard", Scope: ScopeRead})
+
+	for _, id := range []string{apiChannel, "dashboard"} {
+
+		ioutil.WriteFile(f.Name(), []byte(`{"destinations":[{"id":"`+id+`","stream":"stream/front","destination":"ws://localhost:1/front"}]}`), 0644)
+
+		if _, err := a.reloadConfig(); err == nil {
+			t.Errorf("Accepted destination with the id of channel %s", id)
+		}
+	}
+
+	// but a channel that the config owns can become a destination
+	ioutil.WriteFile(f.Name(), []byte(`{"channels":[{"name":"booking","scope":"write"}]}`), 0644)
+
+	if _, err := a.reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"destinations":[{"id":"booking","stream":"stream/front","destination":"ws://localhost:1/front"}]}`), 0644)
+
+	if _, err := a.reloadConfig(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
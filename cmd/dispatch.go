@@ -0,0 +1,987 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/framing"
+	"github.com/timdrysdale/vw/hls"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/inspect"
+	"github.com/timdrysdale/vw/recorder"
+	"github.com/timdrysdale/vw/replay"
+	"github.com/timdrysdale/vw/rwc"
+	"github.com/timdrysdale/vw/serial"
+	"github.com/timdrysdale/vw/watchdog"
+)
+
+// The REST API and the WS/JSON API both run their commands through
+// dispatch, so they can do the same things, with the same JSON. Each
+// operation is one verb on one kind of thing. The same table generates
+// the OpenAPI and JSON-schema documents, see schema.go
+type operation struct {
+	Verbs   []string // the first is documented, the rest are aliases
+	What    string
+	Which   string      // a literal such as "all", a name such as "{stream}", or "" if not used
+	Method  string      // REST method and path, if there is one; a {name} in
+	Path    string      // the path is Which, or, if Which isn't used, is the rule
+	Summary string      // for the documents
+	Rule    interface{} // zero value of the rule's type, or nil if there is no rule
+	Result  interface{} // zero value of the result's type
+	Scope   string      // least scope of a channel that can run it, if not the default, see scope()
+	run     func(app *App, cmd Command) (interface{}, error)
+}
+
+// readVerbs don't change anything, so they are all a read scope allows
+var readVerbs = []string{"healthcheck", "list", "status", "subscribe", "unsubscribe"}
+
+// scope is the least scope of a channel that can run the operation
+func (op operation) scope() string {
+
+	switch {
+	case op.Scope != "":
+		return op.Scope
+	case contains(readVerbs, op.Verbs[0]):
+		return ScopeRead
+	}
+
+	return ScopeWrite
+}
+
+// Deleted is the result of deleting things
+type Deleted struct {
+	Deleted string `json:"deleted"`
+}
+
+// errorReply is how an error is sent on both APIs
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+// apiError carries the HTTP status to use for an error
+type apiError struct {
+	Status int
+	Err    error
+}
+
+func (e apiError) Error() string {
+	return e.Err.Error()
+}
+
+func badRequest(err error) error {
+	return apiError{Status: http.StatusBadRequest, Err: err}
+}
+
+func notFound(err error) error {
+	return apiError{Status: http.StatusNotFound, Err: err}
+}
+
+// status is the HTTP status code for an error from dispatch
+func status(err error) int {
+	if e, ok := err.(apiError); ok {
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
+
+var errStreamNotFound = errors.New("Stream not found")
+var errDestinationNotFound = errors.New("Destination not found")
+var errFramingNotFound = errors.New("Feed is not framed")
+var errHlsNotFound = errors.New("HLS stream not found")
+var errRecordingNotFound = errors.New("Recording not found")
+var errReplayNotFound = errors.New("Replay not found")
+var errSerialNotFound = errors.New("Serial port not found")
+var errWatchdogNotFound = errors.New("Watchdog not found")
+var errNoRule = errors.New("Command needs a rule")
+var errNoStats = errors.New("Hub did not respond")
+
+// operations is filled in by init, because some operations describe the
+// table itself, which would otherwise be an initialisation loop
+var operations []operation
+
+func init() {
+	operations = []operation{
+		{Verbs: []string{"healthcheck"},
+			Summary: "Check the WS/JSON API is working",
+			Result:  map[string]string{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return map[string]string{"healthcheck": "ok"}, nil
+			}},
+		{Verbs: []string{"list"}, What: "health", Which: "live",
+			Method: "GET", Path: "/healthcheck",
+			Summary: "Liveness: whether the main loops are responding",
+			Result:  Health{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				health := app.health()
+				health.Status = healthStatus(health.Live)
+				return health, nil
+			}},
+		{Verbs: []string{"list"}, What: "health", Which: "ready",
+			Method: "GET", Path: "/readyz",
+			Summary: "Readiness: also whether required feeds and destinations are working",
+			Result:  Health{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				health := app.health()
+				health.Status = healthStatus(health.Ready)
+				return health, nil
+			}},
+		{Verbs: []string{"list"}, What: "stats",
+			Method: "GET", Path: "/api/stats",
+			Summary: "Message statistics for the hub",
+			Result:  hub.HubReport{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				report, ok := app.Hub.Hub.Report(time.Duration(app.Opts.HealthTimeoutMs) * time.Millisecond)
+				if !ok {
+					return nil, apiError{Status: http.StatusServiceUnavailable, Err: errNoStats}
+				}
+				return report, nil
+			}},
+		{Verbs: []string{"list"}, What: "config",
+			Method: "GET", Path: "/api/config",
+			Summary: "The rules last loaded from the config file",
+			Result:  Config{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				config := app.currentConfig()
+				if !allows(cmd.scope(), ScopeAdmin) {
+					config.Destinations = redactDestinations(config.Destinations)
+					config.Channels = redactChannels(config.Channels)
+				}
+				return config, nil
+			}},
+		{Verbs: []string{"reload"}, What: "config",
+			Method: "POST", Path: "/api/config/reload",
+			Summary: "Reload the config file, applying only what changed",
+			Result:  ConfigDiff{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				diff, err := app.reloadConfig()
+				if err != nil {
+					return nil, badRequest(err)
+				}
+				return diff, nil
+			}},
+		{Verbs: []string{"subscribe"}, What: "events", Which: "{kind}",
+			Method: "GET", Path: "/api/events",
+			Summary: "Receive events whose kind starts with which (all for every event); the REST API uses Server-Sent Events, with ?kind=",
+			Result:  events.Event{}},
+		{Verbs: []string{"unsubscribe"}, What: "events",
+			Summary: "Stop receiving events",
+			Result:  map[string]string{}},
+		{Verbs: []string{"list"}, What: "openapi",
+			Method: "GET", Path: "/api/openapi.json",
+			Summary: "The OpenAPI document for the REST API",
+			Result:  map[string]interface{}{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return openAPI(), nil
+			}},
+		{Verbs: []string{"list"}, What: "schema",
+			Method: "GET", Path: "/api/schema.json",
+			Summary: "The JSON schema for WS/JSON API commands",
+			Result:  map[string]interface{}{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return commandSchema(), nil
+			}},
+
+		{Verbs: []string{"add"}, What: "stream",
+			Method: "POST", Path: "/api/streams",
+			Summary: "Add or replace a stream",
+			Rule:    agg.Rule{},
+			Result:  agg.Rule{},
+			run:     (*App).addStream},
+		{Verbs: []string{"list"}, What: "stream", Which: "all",
+			Method: "GET", Path: "/api/streams/all",
+			Summary: "List the feeds in every stream",
+			Result:  map[string][]string{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				feeds := make(map[string][]string)
+				for stream, rule := range app.Hub.Snapshot() {
+					feeds[stream] = rule.Feeds
+				}
+				return feeds, nil
+			}},
+		{Verbs: []string{"list"}, What: "stream", Which: "{stream}",
+			Method: "GET", Path: "/api/streams/{stream}",
+			Summary: "Show a stream",
+			Result:  agg.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				rule, ok := app.Hub.Rule(cmd.Which)
+				if !ok {
+					return nil, notFound(errStreamNotFound)
+				}
+				return rule, nil
+			}},
+		{Verbs: []string{"delete"}, What: "stream", Which: "all",
+			Method: "DELETE", Path: "/api/streams/all",
+			Summary: "Delete every stream",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Hub.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "stream", Which: "{stream}",
+			Method: "DELETE", Path: "/api/streams/{stream}",
+			Summary: "Delete a stream",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				if err := app.guard(cmd, nil, []string{cmd.Which}); err != nil {
+					return nil, err
+				}
+				app.Hub.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "destination",
+			Method: "POST", Path: "/api/destinations",
+			Summary: "Add or replace a destination",
+			Rule:    rwc.Rule{},
+			Result:  rwc.Rule{},
+			run:     (*App).addDestination},
+		{Verbs: []string{"list"}, What: "destination", Which: "all",
+			Method: "GET", Path: "/api/destinations/all",
+			Summary: "List every destination",
+			Result:  map[string]rwc.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				rules := app.Websocket.Snapshot()
+				for id, rule := range rules {
+					rules[id] = redact(cmd, rule)
+				}
+				return rules, nil
+			}},
+		{Verbs: []string{"list"}, What: "destination", Which: "{id}",
+			Method: "GET", Path: "/api/destinations/{id}",
+			Summary: "Show a destination",
+			Result:  rwc.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				rule, ok := app.Websocket.Snapshot()[cmd.Which]
+				if !ok {
+					return nil, notFound(errDestinationNotFound)
+				}
+				return redact(cmd, rule), nil
+			}},
+		{Verbs: []string{"status"}, What: "destination", Which: "all",
+			Method: "GET", Path: "/api/destinations/status",
+			Summary: "Connection state and bandwidth limiting of every destination",
+			Result:  map[string]rwc.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Websocket.Status(), nil
+			}},
+		{Verbs: []string{"status"}, What: "destination", Which: "{id}",
+			Method: "GET", Path: "/api/destinations/{id}/status",
+			Summary: "Connection state and bandwidth limiting of a destination",
+			Result:  rwc.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Websocket.Status()[cmd.Which]
+				if !ok {
+					return nil, notFound(errDestinationNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"delete"}, What: "destination", Which: "all",
+			Method: "DELETE", Path: "/api/destinations/all",
+			Summary: "Delete every destination except those of channels",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Websocket.Delete <- "deleteAll"
+				// don't lock ourselves out!
+				for _, c := range app.channelList() {
+					if c.Destination != "" {
+						app.Websocket.Add <- c.rule()
+					}
+				}
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "destination", Which: "{id}",
+			Method: "DELETE", Path: "/api/destinations/{id}",
+			Summary: "Delete a destination; deleting a channel's destination deletes the channel",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				if app.isChannel(cmd.Which) {
+					return app.removeChannel(cmd)
+				}
+				app.Websocket.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "channel",
+			Method: "POST", Path: "/api/channels",
+			Summary: "Add or replace a control channel for the WS/JSON API",
+			Rule:    Channel{},
+			Result:  Channel{},
+			Scope:   ScopeAdmin,
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var c Channel
+				if err := unmarshalRule(cmd, &c); err != nil {
+					return nil, err
+				}
+				if err := checkChannel(c); err != nil {
+					return nil, badRequest(err)
+				}
+				app.addChannel(c)
+				return c, nil
+			}},
+		{Verbs: []string{"list"}, What: "channel", Which: "all",
+			Method: "GET", Path: "/api/channels/all",
+			Summary: "List every control channel; tokens are only shown to admin channels",
+			Result:  map[string]Channel{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				list := app.channelList()
+				if !allows(cmd.scope(), ScopeAdmin) {
+					for name, c := range list {
+						c.Token = ""
+						list[name] = c
+					}
+				}
+				return list, nil
+			}},
+		{Verbs: []string{"list"}, What: "channel", Which: "{name}",
+			Method: "GET", Path: "/api/channels/{name}",
+			Summary: "Show a control channel; the token is only shown to admin channels",
+			Result:  Channel{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				c, ok := app.channelList()[cmd.Which]
+				if !ok {
+					return nil, notFound(errChannelNotFound)
+				}
+				if !allows(cmd.scope(), ScopeAdmin) {
+					c.Token = ""
+				}
+				return c, nil
+			}},
+		{Verbs: []string{"delete"}, What: "channel", Which: "{name}",
+			Method: "DELETE", Path: "/api/channels/{name}",
+			Summary: "Delete a control channel, other than the one in use",
+			Result:  Deleted{},
+			Scope:   ScopeAdmin,
+			run:     (*App).removeChannel},
+
+		{Verbs: []string{"list"}, What: "feed", Which: "all",
+			Method: "GET", Path: "/api/feeds/all",
+			Summary: "List every feed that is being sent, watched, or is in a stream",
+			Result:  map[string]Feed{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.feeds(), nil
+			}},
+
+		{Verbs: []string{"add"}, What: "analysis",
+			Method: "POST", Path: "/api/feeds/{topic}/analysis",
+			Summary: "Start analysing the MPEG-TS on a feed or stream",
+			Rule:    inspect.Rule{},
+			Result:  inspect.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule inspect.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := inspect.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Topic}); err != nil {
+					return nil, err
+				}
+				app.Inspector.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "analysis", Which: "all",
+			Method: "GET", Path: "/api/feeds/analysis/all",
+			Summary: "Show every analysis",
+			Result:  map[string]inspect.Analysis{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Inspector.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "analysis", Which: "{topic}",
+			Method: "GET", Path: "/api/feeds/{topic}/analysis",
+			Summary: "Show the analysis of a feed or stream",
+			Result:  inspect.Analysis{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				analysis, err := app.Inspector.Analysis(cmd.Which)
+				if err != nil {
+					return nil, notFound(err)
+				}
+				return analysis, nil
+			}},
+		{Verbs: []string{"delete"}, What: "analysis", Which: "all",
+			Method: "DELETE", Path: "/api/feeds/analysis/all",
+			Summary: "Stop every analysis",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Inspector.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "analysis", Which: "{topic}",
+			Method: "DELETE", Path: "/api/feeds/{topic}/analysis",
+			Summary: "Stop analysing a feed or stream",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Inspector.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "framing",
+			Method: "POST", Path: "/api/framing",
+			Summary: "Frame the data arriving at a feed into records, or change how",
+			Rule:    framing.Rule{},
+			Result:  framing.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule framing.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := framing.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Feed}); err != nil {
+					return nil, err
+				}
+				app.Framer.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "framing", Which: "all",
+			Method: "GET", Path: "/api/framing/all",
+			Summary: "Show the framing of every framed feed",
+			Result:  map[string]framing.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Framer.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "framing", Which: "{feed}",
+			Method: "GET", Path: "/api/framing/{feed}",
+			Summary: "Show the framing of a feed, with counts of records and malformed records",
+			Result:  framing.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Framer.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errFramingNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"delete"}, What: "framing", Which: "all",
+			Method: "DELETE", Path: "/api/framing/all",
+			Summary: "Stop framing every feed",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Framer.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "framing", Which: "{feed}",
+			Method: "DELETE", Path: "/api/framing/{feed}",
+			Summary: "Stop framing a feed; its data is sent as it arrives",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Framer.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "hls",
+			Method: "POST", Path: "/api/hls",
+			Summary: "Start segmenting a stream for HLS",
+			Rule:    hls.Rule{},
+			Result:  hls.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule hls.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := hls.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Stream}); err != nil {
+					return nil, err
+				}
+				app.Hls.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "hls", Which: "all",
+			Method: "GET", Path: "/api/hls/all",
+			Summary: "Show every HLS stream",
+			Result:  map[string]hls.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Hls.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "hls", Which: "{stream}",
+			Method: "GET", Path: "/api/hls/{stream}",
+			Summary: "Show an HLS stream",
+			Result:  hls.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Hls.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errHlsNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"delete"}, What: "hls", Which: "all",
+			Method: "DELETE", Path: "/api/hls/all",
+			Summary: "Stop every HLS stream",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Hls.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "hls", Which: "{stream}",
+			Method: "DELETE", Path: "/api/hls/{stream}",
+			Summary: "Stop an HLS stream",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Hls.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"start", "add"}, What: "recording",
+			Method: "POST", Path: "/api/recordings",
+			Summary: "Start recording a feed or stream",
+			Rule:    recorder.Rule{},
+			Result:  recorder.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule recorder.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := recorder.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Topic}); err != nil {
+					return nil, err
+				}
+				app.Recorder.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "recording", Which: "all",
+			Method: "GET", Path: "/api/recordings/all",
+			Summary: "Show every recording",
+			Result:  map[string]recorder.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Recorder.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "recording", Which: "{id}",
+			Method: "GET", Path: "/api/recordings/{id}",
+			Summary: "Show a recording",
+			Result:  recorder.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Recorder.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errRecordingNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"stop", "delete"}, What: "recording", Which: "all",
+			Method: "DELETE", Path: "/api/recordings/all",
+			Summary: "Stop every recording",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Recorder.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"stop", "delete"}, What: "recording", Which: "{id}",
+			Method: "DELETE", Path: "/api/recordings/{id}",
+			Summary: "Stop a recording; the current file is kept",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Recorder.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"start", "add"}, What: "replay",
+			Method: "POST", Path: "/api/replays",
+			Summary: "Start replaying a recording into a feed",
+			Rule:    replay.Rule{},
+			Result:  replay.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule replay.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := replay.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Feed}); err != nil {
+					return nil, err
+				}
+				if err := replay.CheckFile(app.Replayer.Dir, rule.File); err != nil {
+					return nil, badRequest(err)
+				}
+				app.Replayer.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"seek"}, What: "replay", Which: "{feed}",
+			Method: "POST", Path: "/api/replays/{feed}/seek/{positionMs}",
+			Summary: "Move a replay to a position in its file",
+			Rule:    Seek{},
+			Result:  Seek{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var seek Seek
+				if err := unmarshalRule(cmd, &seek); err != nil {
+					return nil, err
+				}
+				if err := app.Replayer.Seek(cmd.Which, seek.PositionMs); err != nil {
+					return nil, notFound(err)
+				}
+				return seek, nil
+			}},
+		{Verbs: []string{"loop"}, What: "replay", Which: "{feed}",
+			Method: "POST", Path: "/api/replays/{feed}/loop/{loop}",
+			Summary: "Turn looping on or off for a replay",
+			Rule:    Loop{},
+			Result:  Loop{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var loop Loop
+				if err := unmarshalRule(cmd, &loop); err != nil {
+					return nil, err
+				}
+				if err := app.Replayer.SetLoop(cmd.Which, loop.Loop); err != nil {
+					return nil, notFound(err)
+				}
+				return loop, nil
+			}},
+		{Verbs: []string{"list"}, What: "replay", Which: "all",
+			Method: "GET", Path: "/api/replays/all",
+			Summary: "Show every replay",
+			Result:  map[string]replay.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Replayer.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "replay", Which: "{feed}",
+			Method: "GET", Path: "/api/replays/{feed}",
+			Summary: "Show a replay",
+			Result:  replay.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Replayer.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errReplayNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"stop", "delete"}, What: "replay", Which: "all",
+			Method: "DELETE", Path: "/api/replays/all",
+			Summary: "Stop every replay",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Replayer.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"stop", "delete"}, What: "replay", Which: "{feed}",
+			Method: "DELETE", Path: "/api/replays/{feed}",
+			Summary: "Stop a replay; the feed goes silent",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Replayer.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "serial",
+			Method: "POST", Path: "/api/serials",
+			Summary: "Open a serial device as a feed, or replace the one on the feed",
+			Rule:    serial.Rule{},
+			Result:  serial.Rule{},
+			Scope:   ScopeAdmin,
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule serial.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := serial.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				app.Serial.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "serial", Which: "all",
+			Method: "GET", Path: "/api/serials/all",
+			Summary: "Show every serial port",
+			Result:  map[string]serial.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Serial.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "serial", Which: "{feed}",
+			Method: "GET", Path: "/api/serials/{feed}",
+			Summary: "Show a serial port",
+			Result:  serial.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Serial.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errSerialNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"delete"}, What: "serial", Which: "all",
+			Method: "DELETE", Path: "/api/serials/all",
+			Summary: "Close every serial port",
+			Result:  Deleted{},
+			Scope:   ScopeAdmin,
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Serial.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "serial", Which: "{feed}",
+			Method: "DELETE", Path: "/api/serials/{feed}",
+			Summary: "Close a serial port; the feed goes silent",
+			Result:  Deleted{},
+			Scope:   ScopeAdmin,
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Serial.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+
+		{Verbs: []string{"add"}, What: "watchdog",
+			Method: "POST", Path: "/api/watchdogs",
+			Summary: "Add or replace a watchdog on a feed",
+			Rule:    watchdog.Rule{},
+			Result:  watchdog.Rule{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				var rule watchdog.Rule
+				if err := unmarshalRule(cmd, &rule); err != nil {
+					return nil, err
+				}
+				if err := watchdog.Check(rule); err != nil {
+					return nil, badRequest(err)
+				}
+				if err := app.guard(cmd, nil, []string{rule.Feed, rule.Failover}); err != nil {
+					return nil, err
+				}
+				// restarting runs a command on the host
+				for _, action := range rule.Actions {
+					if action == watchdog.ActionRestart && !allows(cmd.scope(), ScopeAdmin) {
+						return nil, forbidden(errRestartScope)
+					}
+				}
+				app.Watchdog.Add <- rule
+				return rule, nil
+			}},
+		{Verbs: []string{"list"}, What: "watchdog", Which: "all",
+			Method: "GET", Path: "/api/watchdogs/all",
+			Summary: "Show every watchdog",
+			Result:  map[string]watchdog.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				return app.Watchdog.Report(), nil
+			}},
+		{Verbs: []string{"list"}, What: "watchdog", Which: "{feed}",
+			Method: "GET", Path: "/api/watchdogs/{feed}",
+			Summary: "Show a watchdog",
+			Result:  watchdog.Status{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				status, ok := app.Watchdog.Report()[cmd.Which]
+				if !ok {
+					return nil, notFound(errWatchdogNotFound)
+				}
+				return status, nil
+			}},
+		{Verbs: []string{"delete"}, What: "watchdog", Which: "all",
+			Method: "DELETE", Path: "/api/watchdogs/all",
+			Summary: "Delete every watchdog",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Watchdog.Delete <- "deleteAll"
+				return Deleted{"deleteAll"}, nil
+			}},
+		{Verbs: []string{"delete"}, What: "watchdog", Which: "{feed}",
+			Method: "DELETE", Path: "/api/watchdogs/{feed}",
+			Summary: "Delete a watchdog",
+			Result:  Deleted{},
+			run: func(app *App, cmd Command) (interface{}, error) {
+				app.Watchdog.Delete <- cmd.Which
+				return Deleted{cmd.Which}, nil
+			}},
+	}
+}
+
+// Seek is the rule for moving a replay
+type Seek struct {
+	PositionMs int64 `json:"positionMs"`
+}
+
+// Loop is the rule for turning looping on or off for a replay
+type Loop struct {
+	Loop bool `json:"loop"`
+}
+
+// dispatch runs a command from either API
+func (app *App) dispatch(cmd Command) (interface{}, error) {
+
+	op, ok := findOperation(cmd)
+
+	if !ok || op.run == nil {
+		return nil, badRequest(errBadCommand)
+	}
+
+	if !allows(cmd.scope(), op.scope()) {
+		return nil, forbidden(errScope)
+	}
+
+	return op.run(app, cmd)
+}
+
+// findOperation prefers an operation whose Which is the literal in the
+// command, such as "all", to one that takes a name
+func findOperation(cmd Command) (operation, bool) {
+
+	var named operation
+	found := false
+
+	for _, op := range operations {
+		if op.What != cmd.What || !contains(op.Verbs, cmd.Verb) {
+			continue
+		}
+		switch {
+		case op.Which == "" || op.Which == cmd.Which:
+			return op, true
+		case isName(op.Which) && cmd.Which != "":
+			named = op
+			found = true
+		}
+	}
+
+	return named, found
+}
+
+func isName(which string) bool {
+	return strings.HasPrefix(which, "{")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unmarshalRule(cmd Command, rule interface{}) error {
+
+	if cmd.Rule == nil {
+		return badRequest(errNoRule)
+	}
+
+	if err := json.Unmarshal(*cmd.Rule, rule); err != nil {
+		return badRequest(err)
+	}
+
+	return nil
+}
+
+func (app *App) addStream(cmd Command) (interface{}, error) {
+
+	var rule agg.Rule
+
+	if err := unmarshalRule(cmd, &rule); err != nil {
+		return nil, err
+	}
+
+	rule.Stream = strings.TrimPrefix(rule.Stream, "/") //can't delete a stream registered with leading prefix
+
+	if err := agg.Check(rule); err != nil {
+		return nil, badRequest(err)
+	}
+
+	if err := app.guard(cmd, nil, append([]string{rule.Stream}, rule.Feeds...)); err != nil {
+		return nil, err
+	}
+
+	app.Hub.Add <- rule
+
+	return rule, nil
+}
+
+func (app *App) addDestination(cmd Command) (interface{}, error) {
+
+	var rule rwc.Rule
+
+	if err := unmarshalRule(cmd, &rule); err != nil {
+		return nil, err
+	}
+
+	rule.Stream = strings.TrimPrefix(rule.Stream, "/") //to match trimming we do in addStream
+	for i, stream := range rule.Streams {
+		rule.Streams[i] = strings.TrimPrefix(stream, "/")
+	}
+
+	if err := rwc.Check(rule); err != nil {
+		return nil, badRequest(err)
+	}
+
+	if err := app.guard(cmd, []string{rule.Id}, append([]string{rule.Stream}, rule.Streams...)); err != nil {
+		return nil, err
+	}
+
+	app.Websocket.Add <- rule
+
+	return rule, nil
+}
+
+// removeChannel deletes the channel named by Which, but not the one the
+// command came on, which would lock it out
+func (app *App) removeChannel(cmd Command) (interface{}, error) {
+
+	if err := app.guard(cmd, []string{cmd.Which}, nil); err != nil {
+		return nil, err
+	}
+
+	if cmd.Which == cmd.channel {
+		return nil, forbidden(errNoDeleteChannel)
+	}
+
+	if !app.deleteChannel(cmd.Which) {
+		return nil, notFound(errChannelNotFound)
+	}
+
+	return Deleted{cmd.Which}, nil
+}
+
+// serve runs a command for the REST API
+func (app *App) serve(w http.ResponseWriter, cmd Command) {
+	result, err := app.dispatch(cmd)
+	writeResult(w, result, err)
+}
+
+// serveRule runs a command for the REST API, with the request body as the rule
+func (app *App) serveRule(w http.ResponseWriter, r *http.Request, cmd Command) {
+
+	b, err := ioutil.ReadAll(r.Body)
+
+	defer r.Body.Close()
+
+	if err != nil {
+		writeResult(w, nil, err)
+		return
+	}
+
+	rule := json.RawMessage(b)
+	cmd.Rule = &rule
+
+	app.serve(w, cmd)
+}
+
+// serveValue runs a command for the REST API, with a rule taken from the path
+func (app *App) serveValue(w http.ResponseWriter, cmd Command, rule interface{}) {
+
+	b, err := json.Marshal(rule)
+	if err != nil {
+		writeResult(w, nil, err)
+		return
+	}
+
+	raw := json.RawMessage(b)
+	cmd.Rule = &raw
+
+	app.serve(w, cmd)
+}
+
+func writeResult(w http.ResponseWriter, result interface{}, err error) {
+
+	w.Header().Set("content-type", "application/json")
+
+	var output []byte
+
+	if err == nil {
+		output, err = json.Marshal(result)
+	}
+
+	if err != nil {
+		output, _ = json.Marshal(errorReply{err.Error()})
+		w.WriteHeader(status(err))
+	}
+
+	w.Write(output)
+}
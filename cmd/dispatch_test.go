@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/rwc"
+)
+
+// Every operation with a REST path must be routed
+func TestDispatchRoutes(t *testing.T) {
+
+	a := testApp(false)
+	router := a.router()
+
+	for _, op := range operations {
+
+		if op.Path == "" {
+			continue
+		}
+
+		path := pathNames.ReplaceAllStringFunc(op.Path, func(name string) string {
+			switch name {
+			case "{positionMs}":
+				return "1000"
+			case "{loop}":
+				return "true"
+			}
+			return "x"
+		})
+
+		req, err := http.NewRequest(op.Method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var match mux.RouteMatch
+		if !router.Match(req, &match) || match.MatchErr != nil {
+			t.Errorf("No route for %s %s (%s)", op.Method, op.Path, op.id())
+		}
+	}
+}
+
+// The REST and WS/JSON APIs give the same JSON for the same command
+func TestDispatchParity(t *testing.T) {
+
+	a := testApp(false)
+	a.Hub.Rules["stream/large"] = []string{"audio", "video0"}
+	a.Hub.Muxed["stream/large"] = true
+
+	tests := []struct {
+		method, path, command string
+		status                int
+	}{
+		{"GET", "/api/streams/stream/large", `{"verb":"list","what":"stream","which":"stream/large"}`, 200},
+		{"GET", "/api/streams/all", `{"verb":"list","what":"stream","which":"all"}`, 200},
+		{"GET", "/api/streams/stream/none", `{"verb":"list","what":"stream","which":"stream/none"}`, 404},
+		{"GET", "/api/destinations/00/status", `{"verb":"status","what":"destination","which":"00"}`, 404},
+		{"GET", "/api/watchdogs/video0", `{"verb":"list","what":"watchdog","which":"video0"}`, 404},
+		{"POST", "/api/config/reload", `{"verb":"reload","what":"config"}`, 400},
+	}
+
+	router := a.router()
+
+	for _, tt := range tests {
+
+		req, err := http.NewRequest(tt.method, tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: wrong status code; got/wanted %d/%d", tt.method, tt.path, rr.Code, tt.status)
+		}
+
+		reply, err := a.handleAdminMessage([]byte(tt.command))
+		if err != nil {
+			reply, _ = json.Marshal(errorReply{err.Error()})
+		}
+
+		if rr.Body.String() != string(reply) {
+			t.Errorf("%s: REST and WS differ\n%s\n%s", tt.command, rr.Body.String(), reply)
+		}
+	}
+}
+
+func TestDispatchErrorIsJSON(t *testing.T) {
+
+	a := testApp(false)
+
+	req, err := http.NewRequest("POST", "/api/streams", bytes.NewBufferString(`{"stream":"a" "feeds":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	a.router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status code; got/wanted %d/%d", rr.Code, http.StatusBadRequest)
+	}
+
+	// the message has a quote in it
+	var reply errorReply
+	if err := json.Unmarshal(rr.Body.Bytes(), &reply); err != nil || !strings.Contains(reply.Error, `'"'`) {
+		t.Errorf("Bad error %s (%v)", rr.Body.String(), err)
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+
+	s := schemaOf(agg.Rule{})
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// filters are optional, kinds are inside each filter
+	want := []string{`"required":["feeds","stream"]`, `"filters":{"additionalProperties":{"properties":{"drop":{"type":"boolean"}`, `"kinds":{"items":{"type":"string"},"type":"array"}`}
+
+	for _, w := range want {
+		if !strings.Contains(string(data), w) {
+			t.Errorf("Missing %s from %s", w, data)
+		}
+	}
+
+	// units are described
+	data, _ = json.Marshal(schemaOf(rwc.Status{}))
+
+	if w := `"throughputBytesPerSec":{"description":"bytes per second","type":"integer"}`; !strings.Contains(string(data), w) {
+		t.Errorf("Missing %s from %s", w, data)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+
+	a := testApp(false)
+
+	reply, err := a.handleAdminMessage([]byte(`{"verb":"list","what":"openapi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationId string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+			} `json:"parameters"`
+			RequestBody *json.RawMessage `json:"requestBody"`
+		} `json:"paths"`
+	}
+
+	if err := json.Unmarshal(reply, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI == "" {
+		t.Error("Not an OpenAPI document")
+	}
+
+	if op := doc.Paths["/api/streams/{stream}"]["get"]; op.OperationId != "listStream" || len(op.Parameters) != 1 || op.Parameters[0].Name != "stream" {
+		t.Errorf("Wrong operation %+v", op)
+	}
+
+	if op := doc.Paths["/api/streams"]["post"]; op.OperationId != "addStream" || op.RequestBody == nil {
+		t.Errorf("Wrong operation %+v", op)
+	}
+
+	if op := doc.Paths["/api/replays/{feed}/seek/{positionMs}"]["post"]; op.RequestBody != nil || len(op.Parameters) != 2 {
+		t.Errorf("Wrong operation %+v", op)
+	}
+
+	reply, err = a.handleAdminMessage([]byte(`{"verb":"list","what":"schema"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		OneOf []struct {
+			Title string `json:"title"`
+		} `json:"oneOf"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+
+	if err := json.Unmarshal(reply, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schema.OneOf) != len(operations) {
+		t.Errorf("Wrong number of commands; got/wanted %d/%d", len(schema.OneOf), len(operations))
+	}
+
+	if _, ok := schema.Definitions["statusDestination"]; !ok {
+		t.Error("Missing result for statusDestination")
+	}
+}
+
+func TestListWhileChanging(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	done := make(chan struct{})
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			id := strconv.Itoa(i)
+			a.Hub.Add <- agg.Rule{Stream: "stream/" + id, Feeds: []string{"video" + id}}
+			a.Websocket.Add <- rwc.Rule{Id: id, Stream: "stream/" + id, Destination: "ws://localhost:1/" + id}
+		}
+		close(done)
+	}()
+
+	for {
+		for _, message := range []string{`{"verb":"list","what":"stream","which":"all"}`,
+			`{"verb":"list","what":"destination","which":"all"}`,
+			`{"verb":"list","what":"destination","which":"0"}`} {
+			a.handleAdminMessage([]byte(message))
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"sort"
+)
+
+// Feed is what we know about a feed, from its ingest connections,
+// watchdog and the streams that include it
+type Feed struct {
+	Feed     string   `json:"feed"`
+	Ingest   int      `json:"ingest"`             // connections sending to /ts/<feed>
+	Watchdog string   `json:"watchdog,omitempty"` // active or stale, if it has a watchdog
+	Streams  []string `json:"streams,omitempty"`
+}
+
+// ingestStarted counts a connection sending to a feed
+func (app *App) ingestStarted(feed string) {
+	app.ingestMux.Lock()
+	defer app.ingestMux.Unlock()
+	if app.ingest == nil {
+		app.ingest = make(map[string]int)
+	}
+	app.ingest[feed]++
+}
+
+func (app *App) ingestStopped(feed string) {
+	app.ingestMux.Lock()
+	defer app.ingestMux.Unlock()
+	app.ingest[feed]--
+	if app.ingest[feed] <= 0 {
+		delete(app.ingest, feed)
+	}
+}
+
+// feeds lists every feed that is being sent, watched, or is in a stream
+func (app *App) feeds() map[string]Feed {
+
+	feeds := make(map[string]Feed)
+
+	feed := func(name string) Feed {
+		f, ok := feeds[name]
+		if !ok {
+			f.Feed = name
+		}
+		return f
+	}
+
+	app.ingestMux.Lock()
+	for name, count := range app.ingest {
+		f := feed(name)
+		f.Ingest = count
+		feeds[name] = f
+	}
+	app.ingestMux.Unlock()
+
+	for name, status := range app.Watchdog.Report() {
+		f := feed(name)
+		f.Watchdog = "active"
+		if status.Stale {
+			f.Watchdog = "stale"
+		}
+		feeds[name] = f
+	}
+
+	for stream, rule := range app.Hub.Snapshot() {
+		for _, name := range rule.Feeds {
+			f := feed(name)
+			f.Streams = append(f.Streams, stream)
+			feeds[name] = f
+		}
+	}
+
+	for name, f := range feeds {
+		sort.Strings(f.Streams)
+		feeds[name] = f
+	}
+
+	return feeds
+}
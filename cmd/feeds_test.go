@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+)
+
+func TestFeeds(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	a.ingestStarted("video0")
+	a.Hub.Add <- agg.Rule{Stream: "stream/front", Feeds: []string{"video0", "audio0"}}
+	a.Hub.Add <- agg.Rule{Stream: "stream/back", Feeds: []string{"video0"}}
+
+	time.Sleep(2 * time.Millisecond)
+
+	done := make(chan struct{})
+
+	// streams can change while we list them
+	go func() {
+		for i := 0; i < 100; i++ {
+			a.Hub.Add <- agg.Rule{Stream: "stream/" + strconv.Itoa(i), Feeds: []string{"video1"}}
+		}
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		a.feeds()
+	}
+
+	// the loop has finished the last rule once it takes a ping
+	a.Hub.Ping <- struct{}{}
+
+	feeds := a.feeds()
+
+	want := Feed{Feed: "video0", Ingest: 1, Streams: []string{"stream/back", "stream/front"}}
+	if !reflect.DeepEqual(feeds["video0"], want) {
+		t.Errorf("Wrong feed got/wanted\n%+v\n%+v", feeds["video0"], want)
+	}
+
+	if len(feeds["video1"].Streams) != 100 {
+		t.Errorf("Wrong number of streams for video1 got/wanted %d/%d", len(feeds["video1"].Streams), 100)
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/timdrysdale/vw/inspect"
+)
+
+// Start analysing a feed or stream
+//
+// curl -X POST http://localhost:8888/api/feeds/video0/analysis
+func (app *App) handleAnalysisAdd(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serveValue(w, Command{Verb: "add", What: "analysis"}, inspect.Rule{Topic: vars["feed"]})
+}
+
+// curl -X GET http://localhost:8888/api/feeds/video0/analysis
+func (app *App) handleAnalysisShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "analysis", Which: vars["feed"]})
+}
+
+// curl -X GET http://localhost:8888/api/feeds/analysis/all
+func (app *App) handleAnalysisShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "analysis", Which: "all"})
+}
+
+// curl -X DELETE http://localhost:8888/api/feeds/video0/analysis
+func (app *App) handleAnalysisDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "analysis", Which: vars["feed"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/feeds/analysis/all
+func (app *App) handleAnalysisDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "delete", What: "analysis", Which: "all"})
+}
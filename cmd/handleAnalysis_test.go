@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/timdrysdale/vw/inspect"
+)
+
+func TestHandleAnalysis(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	router := mux.NewRouter()
+	router.HandleFunc(`/api/feeds/{feed:[a-zA-Z0-9\-\/]+}/analysis`, a.handleAnalysisAdd).Methods("POST")
+	router.HandleFunc(`/api/feeds/{feed:[a-zA-Z0-9\-\/]+}/analysis`, a.handleAnalysisShow).Methods("GET")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/feeds/stream/front/analysis", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/api/feeds/stream/front/analysis", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/feeds/stream/front/analysis", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var analysis inspect.Analysis
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &analysis); err != nil {
+		t.Fatal(err)
+	}
+
+	if analysis.Topic != "stream/front" || analysis.PIDs == nil {
+		t.Errorf("Wrong analysis %s", rr.Body.String())
+	}
+}
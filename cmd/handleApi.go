@@ -4,6 +4,16 @@ import (
 	"net/http"
 )
 
+// The OpenAPI document for the REST API, also at /api
+//
+// curl -X GET http://localhost:8888/api/openapi.json
 func (app *App) handleApi(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "openapi"})
+}
 
+// The JSON schema for commands on the WS/JSON API
+//
+// curl -X GET http://localhost:8888/api/schema.json
+func (app *App) handleSchema(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "schema"})
 }
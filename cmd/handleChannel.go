@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/channels/all
+func (app *App) handleChannelShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "channel", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/channels/dashboard
+func (app *App) handleChannelShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "channel", Which: vars["name"]})
+}
+
+// Add or replace a control channel for the WS/JSON API
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"name":"dashboard","destination":"wss://some.relay.server:443/bi/dashboard","token":"<token>","scope":"read"}' \
+// http://localhost:8888/api/channels
+func (app *App) handleChannelAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "channel"})
+}
+
+// curl -X DELETE http://localhost:8888/api/channels/dashboard
+func (app *App) handleChannelDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "channel", Which: vars["name"]})
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"net/http"
+)
+
+// The rules last loaded from VW_CONFIG_FILE
+//
+// curl -X GET http://localhost:8888/api/config
+func (app *App) handleConfigShow(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "config"})
+}
+
+// Reload VW_CONFIG_FILE, as for SIGHUP
+//
+// curl -X POST http://localhost:8888/api/config/reload
+func (app *App) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "reload", What: "config"})
+}
@@ -1,109 +1,55 @@
 package cmd
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/timdrysdale/vw/rwc"
 )
 
 // curl -X GET http://localhost:8888/api/destinations/all
 func (app *App) handleDestinationShowAll(w http.ResponseWriter, r *http.Request) {
-
-	output, err := json.Marshal(app.Websocket.Rules)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	w.Header().Set("content-type", "application/json")
-	w.Write(output)
-
+	app.serve(w, Command{Verb: "list", What: "destination", Which: "all"})
 }
 
 // curl -X GET http://localhost:8888/api/destinations/01
 func (app *App) handleDestinationShow(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
-
-	output, err := json.Marshal(app.Websocket.Rules[id])
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	w.Header().Set("content-type", "application/json")
-	w.Write(output)
-
+	app.serve(w, Command{Verb: "list", What: "destination", Which: vars["id"]})
 }
 
-/*  Add a new stream rule
+/*  Add a new destination rule
 
 Example:
 
 curl -X POST -H "Content-Type: application/json" \
--d '{"stream":"/stream/front/large","feeds":["video0","audio0"]}'\
-http://localhost:8888/api/streams/video
+-d '{"stream":"/stream/front/large","destination":"wss://<some.relay.server>/in/video0","id":"0"}'\
+http://localhost:8888/api/destinations
 
 */
 func (app *App) handleDestinationAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "destination"})
+}
 
-	b, err := ioutil.ReadAll(r.Body)
-
-	defer r.Body.Close()
-
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	var rule rwc.Rule
-	err = json.Unmarshal(b, &rule)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-
-	rule.Stream = strings.TrimPrefix(rule.Stream, "/") //to match trimming we do in handleStreamAdd
-
-	app.Websocket.Add <- rule
+// Connection state, and how much has been shaped or dropped by bandwidth limits
+//
+// curl -X GET http://localhost:8888/api/destinations/status
+func (app *App) handleDestinationStatus(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "status", What: "destination", Which: "all"})
+}
 
-	output, err := json.Marshal(rule)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	w.Header().Set("content-type", "application/json")
-	w.Write(output)
+// curl -X GET http://localhost:8888/api/destinations/00/status
+func (app *App) handleDestinationStatusOne(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "status", What: "destination", Which: vars["id"]})
 }
 
 // curl -X DELETE http://localhost:8888/api/destinations/00
 func (app *App) handleDestinationDelete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
-
-	app.Websocket.Delete <- id
-
-	output, err := json.Marshal(id)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	w.Header().Set("content-type", "application/json")
-	w.Write(output)
+	app.serve(w, Command{Verb: "delete", What: "destination", Which: vars["id"]})
 }
 
+// curl -X DELETE http://localhost:8888/api/destinations/all
 func (app *App) handleDestinationDeleteAll(w http.ResponseWriter, r *http.Request) {
-
-	id := "deleteAll"
-
-	app.Websocket.Delete <- id
-
-	output, err := json.Marshal(id)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	w.Header().Set("content-type", "application/json")
-	w.Write(output)
+	app.serve(w, Command{Verb: "delete", What: "destination", Which: "all"})
 }
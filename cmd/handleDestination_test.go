@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/timdrysdale/vw/rwc"
@@ -196,3 +197,31 @@ func TestHandleDestinationShowAll(t *testing.T) {
 	}
 
 }
+
+func TestHandleDestinationStatus(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	a.Websocket.Add <- rwc.Rule{Stream: "stream/large",
+		Destination:     "ws://localhost:1/nowhere",
+		Id:              "00",
+		RateBytesPerSec: 100000}
+
+	time.Sleep(2 * time.Millisecond)
+
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(a.handleDestinationStatus).ServeHTTP(rr, httptest.NewRequest("GET", "/api/destinations/status", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"00":{"id":"00","stream":"stream/large","destination":"ws://localhost:1/nowhere","connected":false,"shaped":0,"dropped":0,"droppedBytes":0}}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
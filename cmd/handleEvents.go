@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/events"
+)
+
+const (
+	// Events buffered per subscriber before we start dropping them
+	eventBufferLength = 64
+
+	// Send a comment at this period to stop proxies timing out an idle stream
+	eventKeepAlive = 15 * time.Second
+)
+
+// Server-Sent Events stream of hub and rule changes, optionally filtered
+// by kind prefix, e.g. ?kind=destination
+//
+// curl -N http://localhost:8888/api/events
+func (app *App) handleEvents(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", 500)
+		return
+	}
+
+	filter := r.URL.Query().Get("kind")
+
+	ch := app.Events.Subscribe(eventBufferLength)
+	defer app.Events.Unsubscribe(ch)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+
+	// tell each new subscriber when we started, so restarts can be spotted
+	if err := writeEvent(w, app.startedEvent()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			if !strings.HasPrefix(e.Kind, filter) {
+				break
+			}
+			if err := writeEvent(w, e); err != nil {
+				log.WithField("error", err).Debug("Writing event")
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-app.Closed:
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+
+	return err
+}
+
+func (app *App) startedEvent() events.Event {
+	return events.Event{Kind: events.ProcessStarted, Id: strconv.Itoa(os.Getpid()), Time: app.Started}
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/events"
+)
+
+func readEvent(t *testing.T, reader *bufio.Reader) events.Event {
+
+	var e events.Event
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(clean(line), "data: ")), &e); err != nil {
+				t.Fatalf("bad event data %s: %v", line, err)
+			}
+			return e
+		}
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+
+	a := testApp(false)
+	defer close(a.Closed)
+
+	s := httptest.NewServer(http.HandlerFunc(a.handleEvents))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "?kind=stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Errorf("wrong content-type %s", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+
+	e := readEvent(t, reader)
+
+	if e.Kind != events.ProcessStarted || !e.Time.Equal(a.Started) {
+		t.Errorf("first event should be process started, got %v", e)
+	}
+
+	// filtered out
+	a.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: "00"})
+	a.Events.Publish(events.Event{Kind: events.StreamAdded, Topic: "stream/large"})
+
+	done := make(chan events.Event)
+	go func() { done <- readEvent(t, reader) }()
+
+	select {
+	case e = <-done:
+		if e.Kind != events.StreamAdded || e.Topic != "stream/large" {
+			t.Errorf("got wrong event %v", e)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("timed out waiting for event")
+	}
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/framing/all
+func (app *App) handleFramingShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "framing", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/framing/pendulum
+func (app *App) handleFramingShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "framing", Which: vars["feed"]})
+}
+
+// Send one message per line (or delimiter, or length prefix) arriving at a
+// feed, optionally dropping records that aren't JSON
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"feed":"pendulum","mode":"line","json":true}' \
+// http://localhost:8888/api/framing
+func (app *App) handleFramingAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "framing"})
+}
+
+// curl -X DELETE http://localhost:8888/api/framing/pendulum
+func (app *App) handleFramingDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "framing", Which: vars["feed"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/framing/all
+func (app *App) handleFramingDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "delete", What: "framing", Which: "all"})
+}
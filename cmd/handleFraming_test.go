@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleFramingAdd(t *testing.T) {
+
+	rule := []byte(`{"feed":"pendulum","mode":"line","json":true}`)
+
+	req, err := http.NewRequest("POST", "/api/framing", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleFramingAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Framer.Add
+
+	if got.Feed != "pendulum" || got.Mode != "line" || !got.JSON {
+		t.Errorf("Wrong rule %v", got)
+	}
+}
+
+func TestHandleFramingAddBadMode(t *testing.T) {
+
+	rule := []byte(`{"feed":"pendulum","mode":"csv"}`)
+
+	req, err := http.NewRequest("POST", "/api/framing", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleFramingAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleFramingShowNotFound(t *testing.T) {
+
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"feed": "pendulum"})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleFramingShow).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/hls/all
+func (app *App) handleHlsShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "hls", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/hls/stream/front/large
+func (app *App) handleHlsShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "hls", Which: vars["stream"]})
+}
+
+// Start segmenting a stream for HLS; the playlist is then served
+// at /hls/front/large/index.m3u8
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"stream":"stream/front/large","targetMs":2000,"length":6}' \
+// http://localhost:8888/api/hls
+func (app *App) handleHlsAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "hls"})
+}
+
+// curl -X DELETE http://localhost:8888/api/hls/stream/front/large
+func (app *App) handleHlsDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "hls", Which: vars["stream"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/hls/all
+func (app *App) handleHlsDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "delete", What: "hls", Which: "all"})
+}
+
+// Serve the live playlist for a stream
+//
+// ffplay http://localhost:8888/hls/front/large/index.m3u8
+func (app *App) handleHlsPlaylist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stream := "stream/" + vars["name"]
+
+	playlist, err := app.Hls.Playlist(stream)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	w.Header().Set("content-type", "application/vnd.apple.mpegurl")
+	w.Header().Set("cache-control", "no-cache")
+	w.Write(playlist)
+}
+
+// Serve one segment of a stream
+func (app *App) handleHlsSegment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stream := "stream/" + vars["name"]
+
+	sequence, err := strconv.Atoi(vars["sequence"])
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	segment, err := app.Hls.Segment(stream, sequence)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	w.Header().Set("content-type", "video/mp2t")
+	w.Write(segment)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/timdrysdale/vw/hls"
+)
+
+func TestHandleHlsAdd(t *testing.T) {
+
+	rule := []byte(`{"stream":"stream/front/large","targetMs":1000}`)
+
+	req, err := http.NewRequest("POST", "/api/hls", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleHlsAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Hls.Add
+
+	if got.Stream != "stream/front/large" || got.TargetMs != 1000 {
+		t.Errorf("Wrong rule %v", got)
+	}
+}
+
+func TestHandleHlsAddNotStream(t *testing.T) {
+
+	req, err := http.NewRequest("POST", "/api/hls", bytes.NewBuffer([]byte(`{"stream":"video0"}`)))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleHlsAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleHlsPlaylist(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	a.Hls.Add <- hls.Rule{Stream: "stream/front/large"}
+
+	time.Sleep(2 * time.Millisecond)
+
+	router := mux.NewRouter()
+	router.HandleFunc(`/hls/{name:[a-zA-Z0-9\-\/]+}/index.m3u8`, a.handleHlsPlaylist).Methods("GET")
+	router.HandleFunc(`/hls/{name:[a-zA-Z0-9\-\/]+}/{sequence:[0-9]+}.ts`, a.handleHlsSegment).Methods("GET")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/hls/front/large/index.m3u8", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if rr.Header().Get("content-type") != "application/vnd.apple.mpegurl" || !strings.HasPrefix(rr.Body.String(), "#EXTM3U\n") {
+		t.Errorf("Wrong playlist %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/hls/front/large/0.ts", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for missing segment: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/hls/back/index.m3u8", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for missing stream: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
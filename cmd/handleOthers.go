@@ -3,8 +3,159 @@ package cmd
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 )
 
+// Health is reported by /healthcheck (liveness) and /readyz (readiness)
+type Health struct {
+	Status       string            `json:"status"`
+	Live         bool              `json:"live"`
+	Ready        bool              `json:"ready"`
+	Loops        map[string]string `json:"loops"`
+	Feeds        map[string]string `json:"feeds,omitempty"`
+	Destinations map[string]string `json:"destinations,omitempty"`
+	Stale        []string          `json:"stale,omitempty"`
+	Failed       []string          `json:"failed,omitempty"`
+}
+
+// curl -X GET http://localhost:8888/healthcheck
+// 503 if any of the hub, agg or rwc loops are not responding
 func (app *App) handleHealthcheck(w http.ResponseWriter, r *http.Request) {
-	json.NewEncoder(w).Encode("ok")
+	health := app.health()
+	writeHealth(w, health, health.Live)
+}
+
+// curl -X GET http://localhost:8888/readyz
+// 503 if not live, or any required feed or destination is not working
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	health := app.health()
+	writeHealth(w, health, health.Ready)
+}
+
+func writeHealth(w http.ResponseWriter, health Health, ok bool) {
+
+	w.Header().Set("content-type", "application/json")
+
+	health.Status = healthStatus(ok)
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(health)
+}
+
+func healthStatus(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "fail"
+}
+
+// Message statistics for the hub
+//
+// curl -X GET http://localhost:8888/api/stats
+func (app *App) handleStats(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "stats"})
+}
+
+func (app *App) health() Health {
+
+	health := Health{Loops: app.pingLoops(),
+		Feeds:        make(map[string]string),
+		Destinations: make(map[string]string)}
+
+	for name, status := range health.Loops {
+		if status != "ok" {
+			health.Failed = append(health.Failed, "loop/"+name)
+		}
+	}
+
+	health.Live = len(health.Failed) == 0
+
+	for feed, status := range app.Watchdog.Report() {
+		if status.Stale {
+			health.Feeds[feed] = "stale"
+			health.Stale = append(health.Stale, feed)
+		} else {
+			health.Feeds[feed] = "active"
+		}
+	}
+
+	for _, feed := range app.Opts.RequiredFeeds {
+		if _, ok := health.Feeds[feed]; !ok {
+			health.Feeds[feed] = "unwatched"
+		}
+		if health.Feeds[feed] != "active" {
+			health.Failed = append(health.Failed, "feed/"+feed)
+		}
+	}
+
+	for id, status := range app.Websocket.Status() {
+		if status.Connected {
+			health.Destinations[id] = "connected"
+		} else {
+			health.Destinations[id] = "disconnected"
+		}
+	}
+
+	for _, id := range app.Opts.RequiredDestinations {
+		if _, ok := health.Destinations[id]; !ok {
+			health.Destinations[id] = "missing"
+		}
+		if health.Destinations[id] != "connected" {
+			health.Failed = append(health.Failed, "destination/"+id)
+		}
+	}
+
+	health.Ready = len(health.Failed) == 0
+
+	sort.Strings(health.Stale)
+	sort.Strings(health.Failed)
+
+	return health
+}
+
+// pingLoops checks the main loops are still taking messages from their channels
+func (app *App) pingLoops() map[string]string {
+
+	timeout := time.Duration(app.Opts.HealthTimeoutMs) * time.Millisecond
+
+	loops := map[string]chan struct{}{
+		"hub": app.Hub.Hub.Ping,
+		"agg": app.Hub.Ping,
+		"rwc": app.Websocket.Ping,
+	}
+
+	status := make(map[string]string)
+
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, ping := range loops {
+		wg.Add(1)
+		go func(name string, ping chan struct{}) {
+			defer wg.Done()
+			result := "ok"
+			select {
+			case ping <- struct{}{}:
+			case <-time.After(timeout):
+				result = "timeout"
+			}
+			mux.Lock()
+			status[name] = result
+			mux.Unlock()
+		}(name, ping)
+	}
+
+	wg.Wait()
+
+	return status
+}
+
+// curl -X GET http://localhost:8888/api/feeds/all
+func (app *App) handleFeedShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "feed", Which: "all"})
 }
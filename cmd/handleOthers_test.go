@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func getHealth(t *testing.T, handler http.HandlerFunc) (int, Health) {
+
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	var health Health
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &health); err != nil {
+		t.Errorf("Bad JSON %s: %v", rr.Body.String(), err)
+	}
+
+	return rr.Code, health
+}
+
+func TestHandleHealthcheckLoopsNotRunning(t *testing.T) {
+
+	a := testApp(false)
+
+	code, health := getHealth(t, a.handleHealthcheck)
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Wrong status code got/wanted %d/%d", code, http.StatusServiceUnavailable)
+	}
+
+	expected := []string{"loop/agg", "loop/hub", "loop/rwc"}
+	if health.Status != "fail" || !reflect.DeepEqual(health.Failed, expected) {
+		t.Errorf("Wrong health %v", health)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	code, health := getHealth(t, a.handleReadyz)
+
+	if code != http.StatusOK || health.Status != "ok" || !health.Ready {
+		t.Errorf("Not ready without requirements %d %v", code, health)
+	}
+
+	a.Opts.RequiredFeeds = []string{"video0"}
+	a.Opts.RequiredDestinations = []string{"00"}
+
+	code, health = getHealth(t, a.handleReadyz)
+
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Wrong status code got/wanted %d/%d", code, http.StatusServiceUnavailable)
+	}
+
+	if health.Feeds["video0"] != "unwatched" || health.Destinations["00"] != "missing" {
+		t.Errorf("Wrong requirement status %v", health)
+	}
+
+	expected := []string{"destination/00", "feed/video0"}
+	if !reflect.DeepEqual(health.Failed, expected) {
+		t.Errorf("Wrong failures got/wanted %v/%v", health.Failed, expected)
+	}
+
+	// still live though
+	code, health = getHealth(t, a.handleHealthcheck)
+
+	if code != http.StatusOK || !health.Live {
+		t.Errorf("Liveness should not depend on requirements %d %v", code, health)
+	}
+}
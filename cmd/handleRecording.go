@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/recordings/all
+func (app *App) handleRecordingShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "recording", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/recordings/r0
+func (app *App) handleRecordingShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "recording", Which: vars["id"]})
+}
+
+// Start recording a feed or stream, into files under VW_RECORD_DIR
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"id":"r0","topic":"stream/front/large","maxDurationMs":600000,"retainBytes":10000000000}' \
+// http://localhost:8888/api/recordings
+func (app *App) handleRecordingAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "start", What: "recording"})
+}
+
+// Stop recording; the current file is closed and kept
+//
+// curl -X DELETE http://localhost:8888/api/recordings/r0
+func (app *App) handleRecordingDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "stop", What: "recording", Which: vars["id"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/recordings/all
+func (app *App) handleRecordingDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "stop", What: "recording", Which: "all"})
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleRecordingAdd(t *testing.T) {
+
+	rule := []byte(`{"id":"r0","topic":"stream/large","maxDurationMs":60000,"retainBytes":1000000}`)
+
+	req, err := http.NewRequest("POST", "/api/recordings", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleRecordingAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Recorder.Add
+
+	if got.Id != "r0" || got.Topic != "stream/large" || got.MaxDurationMs != 60000 || got.RetainBytes != 1000000 {
+		t.Errorf("Wrong rule %v", got)
+	}
+}
+
+func TestHandleRecordingAddBadTemplate(t *testing.T) {
+
+	rule := []byte(`{"id":"r0","topic":"video0","template":"/etc/{feed}-{time}.ts"}`)
+
+	req, err := http.NewRequest("POST", "/api/recordings", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleRecordingAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleRecordingDelete(t *testing.T) {
+
+	req, err := http.NewRequest("DELETE", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{
+		"id": "r0",
+	})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleRecordingDelete)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Recorder.Delete
+
+	if got != "r0" {
+		t.Error("Wrong id")
+	}
+}
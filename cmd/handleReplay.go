@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/replays/all
+func (app *App) handleReplayShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "replay", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/replays/video0
+func (app *App) handleReplayShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "replay", Which: vars["feed"]})
+}
+
+// Start replaying a file from under VW_RECORD_DIR into a feed
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"feed":"video0","file":"video0-20200101T120000.000Z.ts","loop":true}' \
+// http://localhost:8888/api/replays
+func (app *App) handleReplayAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "start", What: "replay"})
+}
+
+// Move a replay to a position in its file
+//
+// curl -X POST http://localhost:8888/api/replays/video0/seek/30000
+func (app *App) handleReplaySeek(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	positionMs, err := strconv.ParseInt(vars["ms"], 10, 64)
+	if err != nil {
+		writeResult(w, nil, badRequest(err))
+		return
+	}
+
+	app.serveValue(w, Command{Verb: "seek", What: "replay", Which: vars["feed"]}, Seek{PositionMs: positionMs})
+}
+
+// Turn looping on or off for a replay
+//
+// curl -X POST http://localhost:8888/api/replays/video0/loop/false
+func (app *App) handleReplayLoop(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	loop, err := strconv.ParseBool(vars["loop"])
+	if err != nil {
+		writeResult(w, nil, badRequest(err))
+		return
+	}
+
+	app.serveValue(w, Command{Verb: "loop", What: "replay", Which: vars["feed"]}, Loop{Loop: loop})
+}
+
+// Stop a replay; the feed goes silent
+//
+// curl -X DELETE http://localhost:8888/api/replays/video0
+func (app *App) handleReplayDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "stop", What: "replay", Which: vars["feed"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/replays/all
+func (app *App) handleReplayDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "stop", What: "replay", Which: "all"})
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleReplayAdd(t *testing.T) {
+
+	rule := []byte(`{"feed":"video0","file":"video0/a.ts","loop":true,"startMs":1000}`)
+
+	req, err := http.NewRequest("POST", "/api/replays", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	defer os.RemoveAll(replayFiles(t, a, "video0/a.ts"))
+
+	handler := http.HandlerFunc(a.handleReplayAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Replayer.Add
+
+	if got.Feed != "video0" || got.File != "video0/a.ts" || !got.Loop || got.StartMs != 1000 {
+		t.Errorf("Wrong rule %v", got)
+	}
+}
+
+func TestHandleReplayAddOutsideDir(t *testing.T) {
+
+	rule := []byte(`{"feed":"video0","file":"/etc/passwd"}`)
+
+	req, err := http.NewRequest("POST", "/api/replays", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleReplayAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleReplayAddBadFile(t *testing.T) {
+
+	a := testApp(false)
+	dir := replayFiles(t, a)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), bytes.Repeat([]byte("no sync bytes here\n"), 20), 0644)
+
+	for _, file := range []string{"missing.ts", "notes.txt"} {
+
+		req, err := http.NewRequest("POST", "/api/replays", bytes.NewBufferString(`{"feed":"video0","file":"`+file+`"}`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(a.handleReplayAdd).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v",
+				file, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleReplaySeekNotFound(t *testing.T) {
+
+	req, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{
+		"feed": "video0",
+		"ms":   "1000",
+	})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	http.HandlerFunc(a.handleReplaySeek).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestHandleReplayDelete(t *testing.T) {
+
+	req, err := http.NewRequest("DELETE", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{
+		"feed": "video0",
+	})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleReplayDelete)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+	}()
+
+	if got := <-a.Replayer.Delete; got != "video0" {
+		t.Errorf("Wrong feed deleted got/wanted %s/%s", got, "video0")
+	}
+}
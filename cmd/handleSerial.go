@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/serials/all
+func (app *App) handleSerialShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "serial", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/serials/pendulum
+func (app *App) handleSerialShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "serial", Which: vars["feed"]})
+}
+
+// Open a serial device as a feed; it is reopened if it is unplugged
+//
+// curl -X POST -H "Content-Type: application/json" \
+// -d '{"feed":"pendulum","device":"/dev/ttyUSB0","baud":57600,"lineEnding":"crlf"}' \
+// http://localhost:8888/api/serials
+func (app *App) handleSerialAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "serial"})
+}
+
+// Close a serial port; the feed goes silent
+//
+// curl -X DELETE http://localhost:8888/api/serials/pendulum
+func (app *App) handleSerialDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "serial", Which: vars["feed"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/serials/all
+func (app *App) handleSerialDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "delete", What: "serial", Which: "all"})
+}
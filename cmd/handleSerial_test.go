@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleSerialAdd(t *testing.T) {
+
+	rule := []byte(`{"feed":"pendulum","device":"/dev/ttyUSB0","baud":57600,"lineEnding":"crlf"}`)
+
+	req, err := http.NewRequest("POST", "/api/serials", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleSerialAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Serial.Add
+
+	if got.Feed != "pendulum" || got.Device != "/dev/ttyUSB0" || got.Baud != 57600 || got.LineEnding != "crlf" {
+		t.Errorf("Wrong rule %v", got)
+	}
+}
+
+func TestHandleSerialAddBadBaud(t *testing.T) {
+
+	rule := []byte(`{"feed":"pendulum","device":"/dev/ttyUSB0","baud":57601}`)
+
+	req, err := http.NewRequest("POST", "/api/serials", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleSerialAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleSerialShowNotFound(t *testing.T) {
+
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"feed": "pendulum"})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleSerialShow).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -60,6 +60,29 @@ func TestHandleStreamAdd(t *testing.T) {
 
 }
 
+func TestHandleStreamAddBadFilter(t *testing.T) {
+
+	rule := []byte(`{"stream":"/stream/large","feeds":["av0"],"filters":{"av1":{"kinds":["audio"]}}}`)
+
+	req, err := http.NewRequest("PUT", "/api/streams", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleStreamAdd)
+
+	handler.ServeHTTP(rr, req) // would block if the rule was sent to the hub
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
 func TestHandleStreamDelete(t *testing.T) {
 
 	req, err := http.NewRequest("DELETE", "", nil)
@@ -153,7 +176,8 @@ func TestHandleStreamShow(t *testing.T) {
 			status, http.StatusOK)
 	}
 
-	expected := "[\"audio\",\"video0\"]"
+	// same as the WS/JSON API
+	expected := `{"stream":"stream/large","feeds":["audio","video0"]}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
@@ -6,10 +6,10 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/gobwas/ws"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 )
 
@@ -41,6 +41,8 @@ func (app *App) handleTs(w http.ResponseWriter, r *http.Request) {
 
 	tCh := make(chan int)
 
+	readDone := make(chan struct{})
+
 	//Method for detecting packet boundaries: identify empty buffer via delay on reading a byte
 	//after 13.738µs got 188 bytes
 	//after 13.027µs got 120 bytes
@@ -62,6 +64,7 @@ func (app *App) handleTs(w http.ResponseWriter, r *http.Request) {
 			select {
 			case <-myDetails.Send:
 			case <-app.Closed:
+				return
 			}
 		}
 	}()
@@ -69,6 +72,8 @@ func (app *App) handleTs(w http.ResponseWriter, r *http.Request) {
 	// Read from the buffer, blocking if empty
 	go func() {
 
+		defer close(readDone)
+
 		for {
 
 			tCh <- 0 //tell the monitoring routine we're alive
@@ -96,6 +101,49 @@ func (app *App) handleTs(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	app.Events.Publish(events.Event{Kind: events.FeedStarted, Topic: topic, Id: name})
+
+	app.ingestStarted(topic)
+	defer app.ingestStopped(topic)
+
+	// text feeds can be framed into records, whatever chunks they arrive in
+	splitter := app.Framer.Splitter(topic)
+
+	//flush buffer to internal send channel
+	flush := func() {
+		frameBuffer.mux.Lock()
+
+		n, err := frameBuffer.b.Read(rawFrame)
+
+		frame := rawFrame[:n]
+
+		frameBuffer.b.Reset()
+
+		frameBuffer.mux.Unlock()
+
+		if err != nil || n == 0 {
+			return
+		}
+
+		if records, framed := splitter.Write(frame); framed {
+			for _, record := range records {
+				select {
+				case app.Hub.Broadcast <- hub.Message{Sender: *myDetails, Type: splitter.Kind(), Data: record, Sent: time.Now()}:
+				case <-app.Closed:
+					return
+				}
+			}
+			return
+		}
+
+		msg := hub.Message{Sender: *myDetails, Type: hub.Binary, Data: frame, Sent: time.Now()}
+
+		select {
+		case app.Hub.Broadcast <- msg:
+		case <-app.Closed:
+		}
+	}
+
 	for {
 
 		select {
@@ -110,21 +158,19 @@ func (app *App) handleTs(w http.ResponseWriter, r *http.Request) {
 			// non-empty buffer so _should_ be ok, but recheck if errors crop up on
 			// lower powered system. Assume am on same computer as capture routine
 
-			//flush buffer to internal send channel
-			frameBuffer.mux.Lock()
-
-			n, err := frameBuffer.b.Read(rawFrame)
-
-			frame := rawFrame[:n]
-
-			frameBuffer.b.Reset()
+			flush()
 
-			frameBuffer.mux.Unlock()
+		case <-readDone:
+			// sender has gone away, so send what we have, then stop
+			flush()
+			app.Events.Publish(events.Event{Kind: events.FeedSilent, Topic: topic, Id: name, Detail: "connection closed"})
+			return
 
-			if err == nil && n > 0 {
-				msg := hub.Message{Sender: *myDetails, Type: int(ws.OpBinary), Data: frame, Sent: time.Now()}
-				app.Hub.Broadcast <- msg
-			}
+		case <-app.Stopping:
+			// shutting down, so send what we have while the hub still runs
+			flush()
+			log.WithFields(log.Fields{"Name": name, "Topic": topic}).Info("http.muxHandler closed")
+			return
 
 		case <-app.Closed:
 			log.WithFields(log.Fields{"Name": name, "Topic": topic}).Info("http.muxHandler closed")
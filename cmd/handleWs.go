@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -30,17 +32,53 @@ const (
 // this number does not limit message size
 // So for key frames we just make a few more syscalls
 // null subprotocol required by Chrome
-// TODO restrict CheckOrigin
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  4096,
-	WriteBufferSize: 4096,
-	Subprotocols:    []string{"null"},
-	CheckOrigin:     func(r *http.Request) bool { return true },
+func (app *App) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		Subprotocols:    []string{"null"},
+		CheckOrigin:     app.checkOrigin,
+	}
+}
+
+// checkOrigin allows requests without an Origin header (non-browser
+// clients), same-origin requests, and origins on the allow-list.
+// Everything is allowed if VW_ALLOW_ANY_ORIGIN is set.
+func (app *App) checkOrigin(r *http.Request) bool {
+
+	origin := r.Header.Get("Origin")
+
+	if origin == "" || app.Opts.AllowAnyOrigin {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	if err == nil {
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+
+		for _, allowed := range app.Opts.AllowedOrigins {
+			if strings.EqualFold(strings.TrimRight(allowed, "/"), u.Scheme+"://"+u.Host) {
+				return true
+			}
+		}
+	}
+
+	app.OriginDenied.Increment()
+
+	log.WithFields(log.Fields{"origin": origin,
+		"path":       r.URL.Path,
+		"remoteAddr": r.RemoteAddr,
+		"denied":     app.OriginDenied.Read()}).Warn("Websocket origin not allowed")
+
+	return false
 }
 
 func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := app.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		log.WithField("error", err).Error("Failed upgrading to websocket connection in wsHandler")
 		return
@@ -59,6 +97,7 @@ func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 	client := &WsHandlerClient{
 		Messages:   messageClient,
 		Conn:       conn,
+		Framing:    app.Framer.Splitter(topic),
 		UserAgent:  r.UserAgent(),
 		RemoteAddr: r.Header.Get("X-Forwarded-For"),
 	}
@@ -67,8 +106,8 @@ func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
-	go client.writePump(app.Closed)
-	go client.readPump()
+	go client.writePump(app.Stopping)
+	go client.readPump(app.Closed)
 
 }
 
@@ -77,9 +116,12 @@ func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 // The application runs readPump in a per-connection goroutine. The application
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
-func (c *WsHandlerClient) readPump() {
+func (c *WsHandlerClient) readPump(closed <-chan struct{}) {
 	defer func() {
-		c.Messages.Hub.Unregister <- c.Messages
+		select {
+		case c.Messages.Hub.Unregister <- c.Messages:
+		case <-closed:
+		}
 		c.Conn.Close()
 	}()
 	c.Conn.SetReadLimit(maxMessageSize)
@@ -96,7 +138,63 @@ func (c *WsHandlerClient) readPump() {
 
 		t := time.Now()
 
-		c.Messages.Hub.Broadcast <- hub.Message{Sender: *c.Messages, Data: data, Type: mt, Sent: t}
+		if records, framed := c.Framing.Write(data); framed {
+			for _, record := range records {
+				select {
+				case c.Messages.Hub.Broadcast <- hub.Message{Sender: *c.Messages, Data: record, Type: c.Framing.Kind(), Sent: t}:
+				case <-closed:
+					return
+				}
+			}
+			continue
+		}
+
+		select {
+		case c.Messages.Hub.Broadcast <- hub.Message{Sender: *c.Messages, Data: data, Type: hub.Kind(mt), Sent: t}:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// write sends a message as the kind it arrived as. Binary chunks
+// already queued are added to a binary message, without delimiter,
+// but anything else is sent as it is, so that text records stay whole
+func (c *WsHandlerClient) write(message hub.Message) error {
+
+	for {
+		if message.Type.IsControl() {
+			return c.Conn.WriteControl(int(message.Type), message.Data, time.Now().Add(writeWait))
+		}
+
+		w, err := c.Conn.NextWriter(int(message.Type))
+		if err != nil {
+			return err
+		}
+
+		w.Write(message.Data)
+
+		var next *hub.Message
+
+		n := len(c.Messages.Send)
+		for i := 0; i < n && message.Type == hub.Binary; i++ {
+			followOnMessage := <-c.Messages.Send
+			if followOnMessage.Type != hub.Binary {
+				next = &followOnMessage
+				break
+			}
+			w.Write(followOnMessage.Data)
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		message = *next
 	}
 }
 
@@ -121,24 +219,7 @@ func (c *WsHandlerClient) writePump(closed <-chan struct{}) {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(message.Type)
-			if err != nil {
-				return
-			}
-
-			w.Write(message.Data)
-
-			size := len(message.Data)
-
-			// Add queued chunks to the current websocket message, without delimiter.
-			n := len(c.Messages.Send)
-			for i := 0; i < n; i++ {
-				followOnMessage := <-c.Messages.Send
-				w.Write(followOnMessage.Data)
-				size += len(followOnMessage.Data)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.write(message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -13,6 +13,7 @@ import (
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/framing"
 	"github.com/timdrysdale/vw/hub"
 	"github.com/timdrysdale/vw/reconws"
 )
@@ -35,7 +36,7 @@ func TestHandleWsSendMessageViaClient(t *testing.T) {
 
 	greeting := []byte("hello")
 
-	m := &hub.Message{Sender: *ctx, Sent: time.Now(), Data: greeting, Type: websocket.TextMessage}
+	m := &hub.Message{Sender: *ctx, Sent: time.Now(), Data: greeting, Type: hub.Text}
 	h.Broadcast <- *m
 
 	time.Sleep(time.Millisecond)
@@ -135,7 +136,7 @@ func TestHandleWsSendMessageViaWs(t *testing.T) {
 					t.Errorf("Greeting content unexpected; got/wanted %v/%v\n", string(msg.Data), string(greeting))
 				}
 				//reply
-				crx.Hub.Broadcast <- hub.Message{Sender: *crx, Sent: time.Now(), Data: reply, Type: websocket.TextMessage}
+				crx.Hub.Broadcast <- hub.Message{Sender: *crx, Sent: time.Now(), Data: reply, Type: hub.Text}
 
 			} else {
 				t.Error("channel not ok") //this test seems sensitive to timing off the sleeps, registration delay?
@@ -170,6 +171,62 @@ func TestHandleWsSendMessageViaWs(t *testing.T) {
 
 }
 
+func TestHandleWsMessageTypes(t *testing.T) {
+
+	app := testApp(true)
+	defer close(app.Closed)
+
+	app.Framer.Add <- framing.Rule{Feed: "pendulum", Mode: framing.ModeLine}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/{feed}", http.HandlerFunc(app.handleWs))
+
+	s := httptest.NewServer(router)
+	defer s.Close()
+
+	base := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"
+
+	dial := func(feed string) *websocket.Conn {
+		c, _, err := websocket.DefaultDialer.Dial(base+feed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	receive := func(c *websocket.Conn, mt int, expected string) {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		gotType, got, err := c.ReadMessage()
+		if err != nil || gotType != mt || string(got) != expected {
+			t.Errorf("Wrong message %q (type %d, error %v), wanted %q (type %d)", got, gotType, err, expected, mt)
+		}
+	}
+
+	for _, feed := range []string{"video0", "pendulum"} {
+
+		tx, rx := dial(feed), dial(feed)
+		defer tx.Close()
+		defer rx.Close()
+
+		time.Sleep(10 * time.Millisecond)
+
+		if feed == "video0" {
+			// sent on as they arrive
+			tx.WriteMessage(websocket.BinaryMessage, []byte{0x47, 0x00})
+			receive(rx, websocket.BinaryMessage, "\x47\x00")
+			tx.WriteMessage(websocket.TextMessage, []byte("hello"))
+			receive(rx, websocket.TextMessage, "hello")
+			continue
+		}
+
+		// framed into text records, whatever they arrive as
+		tx.WriteMessage(websocket.BinaryMessage, []byte("swing 1\nswi"))
+		receive(rx, websocket.TextMessage, "swing 1")
+		tx.WriteMessage(websocket.BinaryMessage, []byte("ng 2\n"))
+		receive(rx, websocket.TextMessage, "swing 2")
+	}
+}
+
 // this test only shows that the httptest server is working ok
 func TestHandleWsEcho(t *testing.T) {
 
@@ -217,3 +274,46 @@ func echo(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+func TestHandleWsCheckOrigin(t *testing.T) {
+
+	a := testApp(false)
+	a.Opts.AllowedOrigins = []string{"https://dashboard.practable.io", "http://localhost:3000/"}
+
+	tests := []struct {
+		origin string
+		host   string
+		want   bool
+	}{
+		{"", "localhost:8888", true},                               // non-browser client
+		{"http://localhost:8888", "localhost:8888", true},          // same origin
+		{"https://dashboard.practable.io", "localhost:8888", true}, // allow-listed
+		{"http://localhost:3000", "localhost:8888", true},          // allow-listed with trailing slash
+		{"https://DASHBOARD.practable.io", "localhost:8888", true}, // case insensitive
+		{"http://dashboard.practable.io", "localhost:8888", false}, // wrong scheme
+		{"https://evil.example.com", "localhost:8888", false},
+		{"null", "localhost:8888", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://"+tt.host+"/ws/video0", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := a.checkOrigin(r); got != tt.want {
+			t.Errorf("checkOrigin(%q) got/wanted %v/%v", tt.origin, got, tt.want)
+		}
+	}
+
+	if a.OriginDenied.Read() != 3 {
+		t.Errorf("Wrong number of denied origins counted; got/wanted %d/%d", a.OriginDenied.Read(), 3)
+	}
+
+	a.Opts.AllowAnyOrigin = true
+	r := httptest.NewRequest("GET", "http://localhost:8888/ws/video0", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	if !a.checkOrigin(r) {
+		t.Error("AllowAnyOrigin did not allow origin")
+	}
+
+}
@@ -3,9 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	_ "net/http/pprof"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -13,27 +15,35 @@ import (
 )
 
 func (app *App) startHttp() {
-	defer app.WaitGroup.Done()
+	defer app.ingestGroup.Done()
 	log.WithField("opts", app.Opts).Debug("http.Server looking at opts....")
 	log.WithField("port", app.Opts.Port).Debug("http.Server listening port set")
 
-	srv := app.startHttpServer(app.Opts.Port)
+	servers := []*http.Server{app.startHttpServer(app.Opts.Port)}
+
+	if app.Opts.Socket != "" {
+		srv, err := app.startSocketServer(app.Opts.Socket)
+		if err != nil {
+			log.WithFields(log.Fields{"socket": app.Opts.Socket, "error": err}).Fatal("Could not listen on socket")
+		}
+		servers = append(servers, srv)
+	}
 
 	log.Debug("Started http.Server")
 
-	<-app.Closed // wait for shutdown
+	<-app.Stopping // wait for shutdown
 
 	log.Debug("Starting to close http.Server")
-	if err := srv.Shutdown(context.TODO()); err != nil {
-		log.WithField("error", err).Fatal("Failure/timeout shutting down the http.Server gracefully")
-	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(app.Opts.HttpWaitMs)*time.Millisecond)
 	defer cancel()
 
-	srv.SetKeepAlivesEnabled(false)
-	if err := srv.Shutdown(ctx); err != nil {
-		log.WithField("error", err).Fatal("Could not gracefully shutdown http.Server")
+	for _, srv := range servers {
+		srv.SetKeepAlivesEnabled(false)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.WithField("error", err).Warn("Could not gracefully shutdown http.Server")
+			srv.Close()
+		}
 	}
 
 	log.Debug("Stopped http.Server")
@@ -44,7 +54,57 @@ func (app *App) startHttp() {
 func (app *App) startHttpServer(port int) *http.Server {
 
 	addr := fmt.Sprintf(":%d", port)
-	srv := &http.Server{Addr: addr}
+	srv := &http.Server{Addr: addr, Handler: app.router()}
+
+	go func() {
+		//https://stackoverflow.com/questions/39320025/how-to-stop-http-listenandserve
+		// returns ErrServerClosed on graceful close
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.WithField("error", err).Fatal("http.ListenAndServe")
+		}
+		log.Debug("Exiting http.Server")
+	}()
+
+	// returning reference so caller can call Shutdown()
+	return srv
+}
+
+// startSocketServer serves the same routes on a Unix socket, which only
+// the user running vw can connect to, e.g. for the vw commands that
+// manage a running instance
+func (app *App) startSocketServer(path string) (*http.Server, error) {
+
+	// left behind if we didn't shut down cleanly
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: app.router()}
+
+	go func() {
+		// the listener removes the socket when it is closed
+		if err := srv.Serve(listener); err != http.ErrServerClosed {
+			log.WithField("error", err).Error("http.Serve on socket")
+		}
+		log.Debug("Exiting http.Server on socket")
+	}()
+
+	return srv, nil
+}
+
+// router has a route for every operation in dispatch.go that has a Path,
+// as well as for ingest, HLS and profiling
+func (app *App) router() *mux.Router {
 
 	var router = mux.NewRouter()
 
@@ -60,31 +120,72 @@ func (app *App) startHttpServer(port int) *http.Server {
 	router.Handle("/debug/pprof/block", pprof.Handler("block"))
 
 	router.HandleFunc("/api", app.handleApi)
+	router.HandleFunc("/api/openapi.json", app.handleApi).Methods("GET")
+	router.HandleFunc("/api/schema.json", app.handleSchema).Methods("GET")
+	router.HandleFunc("/api/stats", app.handleStats).Methods("GET")
+	router.HandleFunc("/api/config", app.handleConfigShow).Methods("GET")
+	router.HandleFunc("/api/config/reload", app.handleConfigReload).Methods("PUT", "POST")
+	router.HandleFunc("/api/events", app.handleEvents).Methods("GET")
+	router.HandleFunc("/api/channels", app.handleChannelAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc("/api/channels/all", app.handleChannelShowAll).Methods("GET")
+	router.HandleFunc(`/api/channels/{name:[a-zA-Z0-9\-\/]+}`, app.handleChannelShow).Methods("GET")
+	router.HandleFunc(`/api/channels/{name:[a-zA-Z0-9\-\/]+}`, app.handleChannelDelete).Methods("DELETE")
 	router.HandleFunc("/api/destinations", app.handleDestinationAdd).Methods("PUT", "POST", "UPDATE")
 	router.HandleFunc(`/api/destinations/{id:[a-zA-Z0-9\-\/]+}`, app.handleDestinationDelete).Methods("DELETE")
 	router.HandleFunc("/api/destinations/all", app.handleDestinationShowAll).Methods("GET")
+	router.HandleFunc("/api/destinations/status", app.handleDestinationStatus).Methods("GET")
 	router.HandleFunc("/api/destinations/all", app.handleDestinationDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/destinations/{id:[a-zA-Z0-9\-\/]+}/status`, app.handleDestinationStatusOne).Methods("GET")
 	router.HandleFunc(`/api/destinations/{id:[a-zA-Z0-9\-\/]+}`, app.handleDestinationShow).Methods("GET")
 	router.HandleFunc("/api/streams", app.handleStreamAdd).Methods("PUT", "POST", "UPDATE")
 	router.HandleFunc(`/api/streams/{stream:[a-zA-Z0-9\-\/]+}`, app.handleStreamDelete).Methods("DELETE")
 	router.HandleFunc("/api/streams/all", app.handleStreamShowAll).Methods("GET")
 	router.HandleFunc("/api/streams/all", app.handleStreamDeleteAll).Methods("DELETE")
 	router.HandleFunc(`/api/streams/{stream:[a-zA-Z0-9\-\/]+}`, app.handleStreamShow).Methods("GET")
+	router.HandleFunc("/api/feeds/all", app.handleFeedShowAll).Methods("GET")
+	router.HandleFunc("/api/feeds/analysis/all", app.handleAnalysisShowAll).Methods("GET")
+	router.HandleFunc("/api/feeds/analysis/all", app.handleAnalysisDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/feeds/{feed:[a-zA-Z0-9\-\/]+}/analysis`, app.handleAnalysisAdd).Methods("PUT", "POST")
+	router.HandleFunc(`/api/feeds/{feed:[a-zA-Z0-9\-\/]+}/analysis`, app.handleAnalysisShow).Methods("GET")
+	router.HandleFunc(`/api/feeds/{feed:[a-zA-Z0-9\-\/]+}/analysis`, app.handleAnalysisDelete).Methods("DELETE")
+	router.HandleFunc("/api/framing", app.handleFramingAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/framing/{feed:[a-zA-Z0-9\-\/]+}`, app.handleFramingDelete).Methods("DELETE")
+	router.HandleFunc("/api/framing/all", app.handleFramingShowAll).Methods("GET")
+	router.HandleFunc("/api/framing/all", app.handleFramingDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/framing/{feed:[a-zA-Z0-9\-\/]+}`, app.handleFramingShow).Methods("GET")
+	router.HandleFunc("/api/hls", app.handleHlsAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/hls/{stream:[a-zA-Z0-9\-\/]+}`, app.handleHlsDelete).Methods("DELETE")
+	router.HandleFunc("/api/hls/all", app.handleHlsShowAll).Methods("GET")
+	router.HandleFunc("/api/hls/all", app.handleHlsDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/hls/{stream:[a-zA-Z0-9\-\/]+}`, app.handleHlsShow).Methods("GET")
+	router.HandleFunc("/api/recordings", app.handleRecordingAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/recordings/{id:[a-zA-Z0-9\-\/]+}`, app.handleRecordingDelete).Methods("DELETE")
+	router.HandleFunc("/api/recordings/all", app.handleRecordingShowAll).Methods("GET")
+	router.HandleFunc("/api/recordings/all", app.handleRecordingDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/recordings/{id:[a-zA-Z0-9\-\/]+}`, app.handleRecordingShow).Methods("GET")
+	router.HandleFunc("/api/replays", app.handleReplayAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/replays/{feed:[a-zA-Z0-9\-\/]+}/seek/{ms:[0-9]+}`, app.handleReplaySeek).Methods("PUT", "POST")
+	router.HandleFunc(`/api/replays/{feed:[a-zA-Z0-9\-\/]+}/loop/{loop:true|false}`, app.handleReplayLoop).Methods("PUT", "POST")
+	router.HandleFunc(`/api/replays/{feed:[a-zA-Z0-9\-\/]+}`, app.handleReplayDelete).Methods("DELETE")
+	router.HandleFunc("/api/replays/all", app.handleReplayShowAll).Methods("GET")
+	router.HandleFunc("/api/replays/all", app.handleReplayDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/replays/{feed:[a-zA-Z0-9\-\/]+}`, app.handleReplayShow).Methods("GET")
+	router.HandleFunc("/api/serials", app.handleSerialAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/serials/{feed:[a-zA-Z0-9\-\/]+}`, app.handleSerialDelete).Methods("DELETE")
+	router.HandleFunc("/api/serials/all", app.handleSerialShowAll).Methods("GET")
+	router.HandleFunc("/api/serials/all", app.handleSerialDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/serials/{feed:[a-zA-Z0-9\-\/]+}`, app.handleSerialShow).Methods("GET")
+	router.HandleFunc("/api/watchdogs", app.handleWatchdogAdd).Methods("PUT", "POST", "UPDATE")
+	router.HandleFunc(`/api/watchdogs/{feed:[a-zA-Z0-9\-\/]+}`, app.handleWatchdogDelete).Methods("DELETE")
+	router.HandleFunc("/api/watchdogs/all", app.handleWatchdogShowAll).Methods("GET")
+	router.HandleFunc("/api/watchdogs/all", app.handleWatchdogDeleteAll).Methods("DELETE")
+	router.HandleFunc(`/api/watchdogs/{feed:[a-zA-Z0-9\-\/]+}`, app.handleWatchdogShow).Methods("GET")
 	router.HandleFunc("/healthcheck", app.handleHealthcheck).Methods("GET")
+	router.HandleFunc("/readyz", app.handleReadyz).Methods("GET")
+	router.HandleFunc(`/hls/{name:[a-zA-Z0-9\-\/]+}/index.m3u8`, app.handleHlsPlaylist).Methods("GET")
+	router.HandleFunc(`/hls/{name:[a-zA-Z0-9\-\/]+}/{sequence:[0-9]+}.ts`, app.handleHlsSegment).Methods("GET")
 	router.HandleFunc(`/ts/{feed:[a-zA-Z0-9\-\/]+}`, app.handleTs)
 	router.HandleFunc(`/ws/{feed:[a-zA-Z0-9\-\/]+}`, app.handleWs)
 
-	srv.Handler = router
-
-	go func() {
-		//https://stackoverflow.com/questions/39320025/how-to-stop-http-listenandserve
-		// returns ErrServerClosed on graceful close
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.WithField("error", err).Fatal("http.ListenAndServe")
-		}
-		log.Debug("Exiting http.Server")
-	}()
-
-	// returning reference so caller can call Shutdown()
-	return srv
+	return router
 }
@@ -6,23 +6,35 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
-	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
-	"github.com/timdrysdale/vw/rwc"
 )
 
-func (app *App) internalAPI(topic string) {
+// internalAPI answers commands on a channel's topic, until the channel
+// is deleted
+func (app *App) internalAPI(ch *channel) {
 
 	c := &hub.Client{Hub: app.Hub.Hub,
 		Name:  "admin",
 		Send:  make(chan hub.Message),
 		Stats: hub.NewClientStats(),
-		Topic: topic,
+		Topic: ch.topic(),
 	}
 
 	app.Hub.Register <- c
 
+	// events are only forwarded after a subscribe command,
+	// in the envelope if that command had one
+	var subscription chan events.Event
+	var filter string
+	var versioned bool
+
+	defer func() {
+		if subscription != nil {
+			app.Events.Unsubscribe(subscription)
+		}
+	}()
+
 	for {
 		select {
 		case message, ok := <-c.Send:
@@ -32,14 +44,84 @@ func (app *App) internalAPI(topic string) {
 				return
 			}
 
-			reply, err := app.handleAdminMessage(message.Data)
+			var reply []byte
+			var err error
 
-			if err == nil {
-				c.Hub.Broadcast <- hub.Message{Sender: *c, Data: reply, Type: websocket.TextMessage, Sent: time.Now()} //mmmm type needed here == too much coupling ...!!
+			var cmd Command
+			parsed := json.Unmarshal(message.Data, &cmd) == nil
+
+			switch {
+			case cmd.Version > apiVersion:
+				err = errBadVersion
+			case parsed && cmd.What == "events":
+				// every scope can have events
+				switch cmd.Verb {
+				case "subscribe":
+					if subscription == nil {
+						subscription = app.Events.Subscribe(eventBufferLength)
+					}
+					filter = cmd.Which
+					if filter == "all" {
+						filter = ""
+					}
+					versioned = cmd.envelope()
+					reply = []byte(`{"subscribed":"events"}`)
+				case "unsubscribe":
+					if subscription != nil {
+						app.Events.Unsubscribe(subscription)
+						subscription = nil
+					}
+					reply = []byte(`{"unsubscribed":"events"}`)
+				default:
+					err = errBadCommand
+				}
+			default:
+				reply, err = app.handleChannelMessage(ch, message.Data)
+			}
+
+			if cmd.envelope() {
+				reply, err = respond(cmd, reply, err)
+			}
+
+			if err != nil {
+				reply, _ = json.Marshal(errorReply{err.Error()})
+			}
+
+			select {
+			case c.Hub.Broadcast <- hub.Message{Sender: *c, Data: reply, Type: hub.Text, Sent: time.Now()}:
+			case <-app.Closed:
+				return
+			}
+
+		case e := <-subscription:
+
+			if !strings.HasPrefix(e.Kind, filter) {
+				break
+			}
+
+			var data []byte
+			var err error
+
+			if versioned {
+				data, err = json.Marshal(Notification{Version: apiVersion, Event: e})
 			} else {
-				c.Hub.Broadcast <- hub.Message{Sender: *c, Data: []byte(`{"error":"` + err.Error() + `"}`), Type: websocket.TextMessage, Sent: time.Now()}
+				data, err = json.Marshal(struct {
+					Event events.Event `json:"event"`
+				}{e})
 			}
 
+			if err == nil {
+				select {
+				case c.Hub.Broadcast <- hub.Message{Sender: *c, Data: data, Type: hub.Text, Sent: time.Now()}:
+				case <-app.Closed:
+					return
+				}
+			}
+
+		case <-ch.done:
+			app.Hub.Unregister <- c
+			return
+
 		case <-app.Closed:
 			return
 		}
@@ -47,10 +129,64 @@ func (app *App) internalAPI(topic string) {
 }
 
 type Command struct {
-	Verb  string
-	What  string
-	Which string
-	Rule  *json.RawMessage
+	Verb    string
+	What    string
+	Which   string
+	Rule    *json.RawMessage
+	Id      *json.RawMessage // optional, echoed in the reply
+	Version int              // optional, asks for the reply in an envelope
+	channel string           // the channel it came on, "" for the REST API
+	granted string           // that channel's scope
+}
+
+// scope is what the command may do; the REST API is local, so it may
+// do anything
+func (cmd Command) scope() string {
+	if cmd.channel == "" {
+		return ScopeAdmin
+	}
+	return cmd.granted
+}
+
+// envelope is true if the reply should be a Response rather than
+// the bare reply, which is kept for older clients
+func (cmd Command) envelope() bool {
+	return cmd.Id != nil || cmd.Version != 0
+}
+
+// the version of the envelope, in case it ever has to change
+const apiVersion = 1
+
+// Response wraps the reply to a command that has an id or version
+type Response struct {
+	Version int              `json:"version"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Ok      bool             `json:"ok"`
+	Error   string           `json:"error,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+}
+
+// Notification is sent without a command, after subscribing to
+// events with a command that has an id or version. It has no ok field,
+// so it can't be mistaken for a Response
+type Notification struct {
+	Version int          `json:"version"`
+	Event   events.Event `json:"event"`
+}
+
+// respond puts a reply or error into a Response
+func respond(cmd Command, reply []byte, err error) ([]byte, error) {
+
+	response := Response{Version: apiVersion, Id: cmd.Id, Ok: err == nil}
+
+	if err != nil {
+		response.Error = err.Error()
+	} else if len(reply) > 0 {
+		result := json.RawMessage(reply)
+		response.Result = &result
+	}
+
+	return json.Marshal(response)
 }
 
 type RuleStream struct {
@@ -59,10 +195,15 @@ type RuleStream struct {
 }
 
 var errBadCommand = errors.New("Unrecognised Command")
-var errNoDeleteApiRule = errors.New("Cannot delete apiRule")
+var errBadVersion = errors.New("Unsupported API version")
 
 // JSON API - note change to singular stream and destination
 //
+// Any command can have an "id" and/or "version":1, e.g.
+// {"id":7,"version":1,"verb":"list","what":"stream","which":"all"}
+// and the reply is then {"version":1,"id":7,"ok":true,"result":<reply>}
+// or {"version":1,"id":7,"ok":false,"error":"<error>"}
+//
 // {"verb":"add","what":"destination","rule":{"stream":"video0","destination":"wss://<some.relay.server>/in/video0","id":"0"}}
 // {"verb":"add","what":"stream","rule":{"stream":"video0","feeds":["video0","audio0"]}}
 //
@@ -72,12 +213,76 @@ var errNoDeleteApiRule = errors.New("Cannot delete apiRule")
 // {"verb":"list","what":"stream","which":"all"}
 // {"verb":"list","what":"destination","which":"all"}
 //
+// {"verb":"status","what":"destination","which":"<id>">}
+// {"verb":"status","what":"destination","which":"all"}
+//
+// {"verb":"list","what":"stats"}
+// {"verb":"list","what":"config"}
+// {"verb":"reload","what":"config"}
+// {"verb":"list","what":"health","which":"live"}
+// {"verb":"list","what":"health","which":"ready"}
+// {"verb":"list","what":"openapi"}
+// {"verb":"list","what":"schema"}
+//
 // {"verb":"delete","what":"stream","which":"<which>"}
 // {"verb":"delete","what":"destination","which":"<id>">}
 //
 // {"verb":"delete","what":"stream","which":"all"}
 // {"verb":"delete","what":"destination","which":"all"}
 //
+// {"verb":"add","what":"analysis","rule":{"topic":"video0"}}
+// {"verb":"list","what":"analysis","which":"<topic>"}
+// {"verb":"list","what":"analysis","which":"all"}
+// {"verb":"delete","what":"analysis","which":"<topic>"}
+// {"verb":"delete","what":"analysis","which":"all"}
+//
+// {"verb":"add","what":"framing","rule":{"feed":"pendulum","mode":"line","json":true}}
+// {"verb":"list","what":"framing","which":"<feed>"}
+// {"verb":"list","what":"framing","which":"all"}
+// {"verb":"delete","what":"framing","which":"<feed>"}
+// {"verb":"delete","what":"framing","which":"all"}
+//
+// {"verb":"add","what":"hls","rule":{"stream":"stream/large","targetMs":2000,"length":6}}
+// {"verb":"list","what":"hls","which":"<stream>"}
+// {"verb":"list","what":"hls","which":"all"}
+// {"verb":"delete","what":"hls","which":"<stream>"}
+// {"verb":"delete","what":"hls","which":"all"}
+//
+// {"verb":"start","what":"recording","rule":{"id":"r0","topic":"stream/large","maxDurationMs":600000}}
+// {"verb":"list","what":"recording","which":"<id>"}
+// {"verb":"list","what":"recording","which":"all"}
+// {"verb":"stop","what":"recording","which":"<id>"}
+// {"verb":"stop","what":"recording","which":"all"}
+//
+// {"verb":"start","what":"replay","rule":{"feed":"video0","file":"video0-20200101T120000.000Z.ts","loop":true}}
+// {"verb":"seek","what":"replay","which":"<feed>","rule":{"positionMs":30000}}
+// {"verb":"loop","what":"replay","which":"<feed>","rule":{"loop":false}}
+// {"verb":"list","what":"replay","which":"<feed>"}
+// {"verb":"list","what":"replay","which":"all"}
+// {"verb":"stop","what":"replay","which":"<feed>"}
+// {"verb":"stop","what":"replay","which":"all"}
+//
+// {"verb":"add","what":"serial","rule":{"feed":"pendulum","device":"/dev/ttyUSB0","baud":57600,"lineEnding":"crlf"}}
+// {"verb":"list","what":"serial","which":"<feed>"}
+// {"verb":"list","what":"serial","which":"all"}
+// {"verb":"delete","what":"serial","which":"<feed>"}
+// {"verb":"delete","what":"serial","which":"all"}
+//
+// {"verb":"add","what":"watchdog","rule":{"feed":"video0","thresholdMs":2000,"actions":["log","event"]}}
+// {"verb":"list","what":"watchdog","which":"<feed>"}
+// {"verb":"list","what":"watchdog","which":"all"}
+// {"verb":"delete","what":"watchdog","which":"<feed>"}
+// {"verb":"delete","what":"watchdog","which":"all"}
+//
+// {"verb":"add","what":"channel","rule":{"name":"dashboard","destination":"wss://<some.relay.server>/bi/dashboard","token":"<token>","scope":"read"}}
+// {"verb":"list","what":"channel","which":"<name>"}
+// {"verb":"list","what":"channel","which":"all"}
+// {"verb":"delete","what":"channel","which":"<name>"}
+//
+// {"verb":"subscribe","what":"events","which":"all"}
+// {"verb":"subscribe","what":"events","which":"<kind prefix, e.g. destination>"}
+// {"verb":"unsubscribe","what":"events"}
+//
 // Which is adapted from the REST-like API
 //
 // destination: POST {"stream":"video0","destination":"wss://<some.relay.server>/in/video0","id":"0"} /api/destinations
@@ -88,98 +293,40 @@ var errNoDeleteApiRule = errors.New("Cannot delete apiRule")
 // DELETE /api/destinations</id>
 // DELETE /api/streams/all
 // DELETE /api/destinations/all
+//
+// and both now run through dispatch, so every command has a REST
+// equivalent with the same JSON; see the operations in dispatch.go
 
+// handleAdminMessage runs a command, other than for events, as if
+// from the REST API
 func (app *App) handleAdminMessage(msg []byte) ([]byte, error) {
+	return app.handleChannelMessage(nil, msg)
+}
 
-	var cmd Command //map[string]*json.RawMessage
-	var reply []byte
+// handleChannelMessage runs a command, other than for events, with
+// the scope of the channel it came on
+func (app *App) handleChannelMessage(ch *channel, msg []byte) ([]byte, error) {
 
-	err := json.Unmarshal(msg, &cmd)
+	var cmd Command
 
-	if err != nil {
-		return reply, errBadCommand
+	if err := json.Unmarshal(msg, &cmd); err != nil {
+		return nil, errBadCommand
 	}
-	if cmd.Verb == "healthcheck" {
-		reply = []byte(`{"healthcheck":"ok"}`)
-	} else {
-		switch cmd.What {
-		case "destination":
-			switch cmd.Verb {
-			case "add":
-				var rule rwc.Rule
-				err = json.Unmarshal(*cmd.Rule, &rule)
-				rule.Stream = strings.TrimPrefix(rule.Stream, "/") //to match trimming we do in handleStreamAdd
-				app.Websocket.Add <- rule
-				reply, err = json.Marshal(rule)
-			case "delete":
-				switch cmd.Which {
-				case "":
-					err = errBadCommand
-				case "all":
-					app.Websocket.Delete <- "deleteAll"
-					// don't lock ourselves out!
-					if app.Opts.API != "" {
-						app.Websocket.Add <- rwc.Rule{Stream: "api", Destination: app.Opts.API, Id: "apiRule"}
-					}
-					reply = []byte(`{"deleted":"deleteAll"}`)
-				default:
-					if cmd.Which != "apiRule" {
-						app.Websocket.Delete <- cmd.Which
-						reply = []byte(`{"deleted":"` + cmd.Which + `"}`)
-					} else {
-						err = errNoDeleteApiRule
-					}
-				}
-			case "list":
-				switch cmd.Which {
-				case "":
-					err = errBadCommand
-				case "all":
-					reply, err = json.Marshal(app.Websocket.Rules)
-				default:
-					reply, err = json.Marshal(app.Websocket.Rules[cmd.Which])
-				}
-			default:
-				err = errBadCommand
-			}
-		case "stream":
-			switch cmd.Verb {
-			case "add":
-				var rule agg.Rule
-				err = json.Unmarshal(*cmd.Rule, &rule)
-				rule.Stream = strings.TrimPrefix(rule.Stream, "/") //to match trimming we do in handleStreamAdd
-				app.Hub.Add <- rule
-				reply, err = json.Marshal(rule)
-			case "delete":
-				switch cmd.Which {
-				case "":
-					err = errBadCommand
-				case "all":
-					app.Hub.Delete <- "deleteAll"
-					reply = []byte(`{"deleted":"deleteAll"}`)
-				default:
-					app.Hub.Delete <- cmd.Which
-					reply = []byte(`{"deleted":"` + cmd.Which + `"}`)
-				}
-			case "list":
-				switch cmd.Which {
-				case "":
-					err = errBadCommand
-				case "all":
-					reply, err = json.Marshal(app.Hub.Rules)
-				default:
-					var feeds []byte // manage scope of err by avoiding :=
-					feeds, err = json.Marshal(app.Hub.Rules[cmd.Which])
-					reply = []byte(`{"feeds":` + string(feeds) + `}`)
-				}
-			default:
-				err = errBadCommand
-			}
-		default:
-			err = errBadCommand
-		}
+
+	if ch != nil {
+		cmd.channel = ch.Name
+		cmd.granted = app.scopeOf(ch)
 	}
 
-	return reply, err
+	result, err := app.dispatch(cmd)
+
+	if err != nil {
+		// there are no status codes here, so just the error itself
+		if e, ok := err.(apiError); ok {
+			err = e.Err
+		}
+		return nil, err
+	}
 
+	return json.Marshal(result)
 }
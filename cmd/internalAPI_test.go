@@ -1,12 +1,13 @@
 package cmd
 
 import (
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 	"github.com/timdrysdale/vw/rwc"
 )
@@ -30,7 +31,7 @@ func TestInternalAPICommunicates(t *testing.T) {
 	app.Websocket = rwc.New(app.Hub)
 
 	name := "api"
-	go app.internalAPI(name)
+	app.addChannel(Channel{Name: apiChannel, Scope: ScopeAdmin})
 
 	client, ok := <-app.Hub.Register
 
@@ -45,7 +46,7 @@ func TestInternalAPICommunicates(t *testing.T) {
 	cmd := []byte(`{"verb":"list","what":"destination","which":"all"}`)
 
 	go func() {
-		client.Send <- hub.Message{Sender: hub.Client{}, Data: cmd, Type: websocket.TextMessage, Sent: time.Now()}
+		client.Send <- hub.Message{Sender: hub.Client{}, Data: cmd, Type: hub.Text, Sent: time.Now()}
 	}()
 
 	time.Sleep(1 * time.Millisecond)
@@ -165,13 +166,17 @@ func TestInternalAPIDestinationDelete(t *testing.T) {
 func TestInternalAPIDestinationDeleteAPIRule(t *testing.T) {
 
 	a := testApp(false)
+	defer close(a.Closed)
+
+	a.addChannel(Channel{Name: apiChannel, Scope: ScopeAdmin})
+	<-a.Hub.Register
 
 	cmd := []byte(`{"verb":"delete","what":"destination","which":"apiRule"}`)
 
 	// note prefix / on stream is removed
-	expected := errNoDeleteApiRule //will be put into error message by internalAPI
+	expected := errNoDeleteChannel //will be put into error message by internalAPI
 
-	_, err := a.handleAdminMessage(cmd)
+	_, err := a.handleChannelMessage(a.channels[apiChannel], cmd)
 	if err == nil {
 		t.Error("Failed to throw error")
 		return
@@ -184,7 +189,8 @@ func TestInternalAPIDestinationDeleteAPIRule(t *testing.T) {
 func TestInternalAPIDestinationDeleteAll(t *testing.T) {
 
 	a := testApp(false)
-	a.Opts.API = "wss://some.relay.server:443/bi/some/where/unique"
+	api := Channel{Name: apiChannel, Destination: "wss://some.relay.server:443/bi/some/where/unique", Scope: ScopeAdmin}
+	a.channels = map[string]*channel{apiChannel: {Channel: api}}
 	cmd := []byte(`{"verb":"delete","what":"destination","which":"all"}`)
 
 	// note prefix / on stream is removed
@@ -209,10 +215,10 @@ func TestInternalAPIDestinationDeleteAll(t *testing.T) {
 
 	added := <-a.Websocket.Add
 
-	if added.Id != "apiRule" {
+	if added.Id != "apiRule" || added.Stream != "api" {
 		t.Error("Did not reinstate apiRule")
 	}
-	if added.Destination != a.Opts.API {
+	if added.Destination != api.Destination {
 		t.Error("Did not reinstate apiRule with correct address")
 	}
 }
@@ -361,7 +367,7 @@ func TestInternalAPIStreamShow(t *testing.T) {
 	a.Hub.Rules["stream/large"] = []string{"audio", "video0"}
 
 	cmd := []byte(`{"verb":"list","what":"stream","which":"stream/large"}`)
-	expected := []byte(`{"feeds":["audio","video0"]}`)
+	expected := []byte(`{"stream":"stream/large","feeds":["audio","video0"]}`)
 
 	reply, err := a.handleAdminMessage(cmd)
 	if err != nil {
@@ -393,3 +399,160 @@ func TestInternalAPIStreamShowAll(t *testing.T) {
 	}
 
 }
+
+func TestInternalAPISubscribeEvents(t *testing.T) {
+
+	a := testApp(false)
+	defer close(a.Closed)
+
+	a.addChannel(Channel{Name: apiChannel, Scope: ScopeAdmin})
+
+	client := <-a.Hub.Register
+
+	send := func(cmd string) {
+		go func() {
+			client.Send <- hub.Message{Sender: hub.Client{}, Data: []byte(cmd), Type: hub.Text, Sent: time.Now()}
+		}()
+	}
+
+	expect := func(expected string) {
+		select {
+		case msg := <-client.Hub.Broadcast:
+			if string(msg.Data) != expected {
+				t.Errorf("Got wrong reply %s/%s\n", expected, msg.Data)
+			}
+		case <-time.After(10 * time.Millisecond):
+			t.Errorf("timeout waiting for %s", expected)
+		}
+	}
+
+	send(`{"verb":"subscribe","what":"events","which":"destination"}`)
+	expect(`{"subscribed":"events"}`)
+
+	then := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	a.Events.Publish(events.Event{Kind: events.StreamAdded, Topic: "stream/large", Time: then})
+	a.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: "00", Time: then})
+	expect(`{"event":{"kind":"destination/connected","id":"00","time":"2019-12-01T00:00:00Z"}}`)
+
+	send(`{"verb":"unsubscribe","what":"events"}`)
+	expect(`{"unsubscribed":"events"}`)
+
+	a.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: "00", Time: then})
+
+	select {
+	case msg := <-client.Hub.Broadcast:
+		t.Errorf("Unexpected message after unsubscribing %s", msg.Data)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestInternalAPIEnvelope(t *testing.T) {
+
+	a := testApp(false)
+	defer close(a.Closed)
+
+	a.addChannel(Channel{Name: apiChannel, Scope: ScopeAdmin})
+
+	client := <-a.Hub.Register
+
+	send := func(cmd string) {
+		go func() {
+			client.Send <- hub.Message{Sender: hub.Client{}, Data: []byte(cmd), Type: hub.Text, Sent: time.Now()}
+		}()
+	}
+
+	expect := func(expected string) {
+		select {
+		case msg := <-client.Hub.Broadcast:
+			if string(msg.Data) != expected {
+				t.Errorf("Got wrong reply %s/%s\n", expected, msg.Data)
+			}
+		case <-time.After(10 * time.Millisecond):
+			t.Errorf("timeout waiting for %s", expected)
+		}
+	}
+
+	// no id or version, so the reply is as before
+	send(`{"verb":"healthcheck"}`)
+	expect(`{"healthcheck":"ok"}`)
+
+	send(`{"id":"op1-7","verb":"healthcheck"}`)
+	expect(`{"version":1,"id":"op1-7","ok":true,"result":{"healthcheck":"ok"}}`)
+
+	send(`{"id":8,"version":1,"verb":"delete","what":"destination","which":"apiRule"}`)
+	expect(`{"version":1,"id":8,"ok":false,"error":"Cannot delete the channel in use"}`)
+
+	send(`{"id":9,"version":2,"verb":"healthcheck"}`)
+	expect(`{"version":1,"id":9,"ok":false,"error":"Unsupported API version"}`)
+
+	send(`{"version":1,"verb":"subscribe","what":"events","which":"destination"}`)
+	expect(`{"version":1,"ok":true,"result":{"subscribed":"events"}}`)
+
+	then := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	a.Events.Publish(events.Event{Kind: events.DestinationAdded, Topic: "video0", Id: "00", Time: then})
+	expect(`{"version":1,"event":{"kind":"destination/added","topic":"video0","id":"00","time":"2019-12-01T00:00:00Z"}}`)
+}
+
+func TestInternalAPIRecordingStart(t *testing.T) {
+
+	a := testApp(false)
+
+	rule := `{"id":"r0","topic":"video0","maxBytes":1000000}`
+	cmd := []byte(`{"verb":"start","what":"recording","rule":` + rule + `}`)
+
+	expected := []byte(rule)
+
+	go func() {
+		reply, err := a.handleAdminMessage(cmd)
+		if err != nil {
+			t.Error("unexpected error")
+			return
+		}
+		if !reflect.DeepEqual(expected, reply) {
+			t.Errorf("Got wrong rule %s/%s\n", expected, reply)
+		}
+	}()
+
+	got := <-a.Recorder.Add
+
+	if got.Id != "r0" || got.Topic != "video0" || got.MaxBytes != 1000000 {
+		t.Errorf("Wrong rule %v", got)
+	}
+
+	_, err := a.handleAdminMessage([]byte(`{"verb":"start","what":"recording","rule":{"id":"r1"}}`))
+
+	if err == nil {
+		t.Error("Failed to throw error for rule without topic")
+	}
+}
+
+func TestInternalAPIReplay(t *testing.T) {
+
+	a := testApp(false)
+	defer os.RemoveAll(replayFiles(t, a, "a.ts"))
+
+	go func() {
+		reply, err := a.handleAdminMessage([]byte(`{"verb":"start","what":"replay","rule":{"feed":"video0","file":"a.ts","loop":true}}`))
+		if err != nil {
+			t.Error("unexpected error")
+			return
+		}
+		if string(reply) != `{"feed":"video0","file":"a.ts","loop":true}` {
+			t.Errorf("Got wrong rule %s", reply)
+		}
+	}()
+
+	got := <-a.Replayer.Add
+
+	if got.Feed != "video0" || got.File != "a.ts" || !got.Loop {
+		t.Errorf("Wrong rule %v", got)
+	}
+
+	if _, err := a.handleAdminMessage([]byte(`{"verb":"start","what":"replay","rule":{"feed":"video0","file":"../a.ts"}}`)); err == nil {
+		t.Error("Failed to throw error for file outside recording directory")
+	}
+
+	if _, err := a.handleAdminMessage([]byte(`{"verb":"seek","what":"replay","which":"video0","rule":{"positionMs":1000}}`)); err == nil {
+		t.Error("Failed to throw error for seek on replay that is not running")
+	}
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/spf13/cobra"
+)
+
+// The streams, destinations, stats, feeds and record commands manage a
+// running instance over its REST API, on its Unix socket if VW_SOCKET is
+// set, or else on http://localhost:VW_PORT. They exit with one of these
+// codes, so that scripts can tell what went wrong.
+const (
+	exitFailed      = 1 // the instance refused the command, e.g. there is no such rule
+	exitUsage       = 2 // bad arguments or flags
+	exitUnavailable = 3 // could not reach the instance, or it is not responding
+)
+
+// remoteError carries the exit code for an error
+type remoteError struct {
+	Code int
+	Err  error
+}
+
+func (e remoteError) Error() string {
+	return e.Err.Error()
+}
+
+var errBadOutput = errors.New("output must be table or json")
+
+// flags shared by the commands, see addRemoteFlags
+var remoteURL string
+var remoteSocket string
+var remoteOutput string
+var remoteTimeoutMs int
+
+// addRemoteFlags adds the flags for reaching the instance, with defaults
+// from the same environment variables as vw stream
+func addRemoteFlags(c *cobra.Command) {
+
+	opts := localSpecification()
+
+	c.PersistentFlags().StringVar(&remoteURL, "url", fmt.Sprintf("http://localhost:%d", opts.Port), "URL of the instance's REST API")
+	c.PersistentFlags().StringVar(&remoteSocket, "socket", opts.Socket, "Unix socket of the instance, used instead of --url if set")
+	c.PersistentFlags().StringVarP(&remoteOutput, "output", "o", "table", "output format: table or json")
+	c.PersistentFlags().IntVar(&remoteTimeoutMs, "timeout-ms", 5000, "how long to wait for the instance")
+}
+
+// localSpecification is how vw stream would be configured from the
+// environment, so that commands can find a local instance
+func localSpecification() Specification {
+
+	var opts Specification
+	if err := envconfig.Process("vw", &opts); err != nil {
+		opts.Port = 8888 // vw stream will complain about the rest
+	}
+
+	return opts
+}
+
+// remote is a running instance
+type remote struct {
+	base   string
+	client *http.Client
+}
+
+func newRemote(url, socket string, timeout time.Duration) *remote {
+
+	if socket == "" {
+		return &remote{base: strings.TrimSuffix(url, "/"),
+			client: &http.Client{Timeout: timeout}}
+	}
+
+	// the host is ignored, because every connection is to the socket
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &remote{base: "http://vw",
+		client: &http.Client{Transport: transport, Timeout: timeout}}
+}
+
+// call sends a request, with the rule as the body if there is one,
+// and returns the body of a successful reply
+func (r *remote) call(method, path string, rule interface{}) ([]byte, error) {
+
+	var body io.Reader
+
+	if rule != nil {
+		b, err := json.Marshal(rule)
+		if err != nil {
+			return nil, remoteError{exitUsage, err}
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, r.base+path, body)
+	if err != nil {
+		return nil, remoteError{exitUsage, err}
+	}
+
+	if rule != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, remoteError{exitUnavailable, err}
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, remoteError{exitUnavailable, err}
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+
+		var reply errorReply
+		if json.Unmarshal(data, &reply) != nil || reply.Error == "" {
+			reply.Error = resp.Status
+		}
+
+		code := exitFailed
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			code = exitUnavailable
+		}
+
+		return nil, remoteError{code, errors.New(reply.Error)}
+	}
+
+	return data, nil
+}
+
+// cli runs one command against an instance, writing the reply to out
+type cli struct {
+	remote *remote
+	out    io.Writer
+	json   bool // write the reply as JSON, instead of as a table
+}
+
+// show writes a reply, as it came if the output is JSON, or else by
+// decoding it into v then calling table to write the rows
+func (c *cli) show(data []byte, v interface{}, table func(w io.Writer)) error {
+
+	if c.json {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return remoteError{exitFailed, err}
+		}
+		indented.WriteString("\n")
+		_, err := indented.WriteTo(c.out)
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return remoteError{exitFailed, err}
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// showDeleted writes the reply to a delete
+func (c *cli) showDeleted(data []byte) error {
+	var deleted Deleted
+	return c.show(data, &deleted, func(w io.Writer) {
+		fmt.Fprintf(w, "deleted\t%s\n", deleted.Deleted)
+	})
+}
+
+// runRemote makes a cobra Run for a command, that exits with a code
+// from the error, if there is one
+func runRemote(run func(c *cli, args []string) error) func(*cobra.Command, []string) {
+
+	return func(cmd *cobra.Command, args []string) {
+
+		c := &cli{remote: newRemote(remoteURL, remoteSocket, time.Duration(remoteTimeoutMs)*time.Millisecond),
+			out: os.Stdout}
+
+		var err error
+
+		switch remoteOutput {
+		case "json":
+			c.json = true
+		case "table":
+		default:
+			err = remoteError{exitUsage, errBadOutput}
+		}
+
+		if err == nil {
+			err = run(c, args)
+		}
+
+		if err == nil {
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "Error:", err)
+
+		if e, ok := err.(remoteError); ok {
+			os.Exit(e.Code)
+		}
+
+		os.Exit(exitFailed)
+	}
+}
+
+// trimPath removes the leading / from a stream, as the instance does,
+// so that it can go in a path
+func trimPath(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/timdrysdale/vw/rwc"
+)
+
+var destinationsToken string
+
+func init() {
+	rootCmd.AddCommand(destinationsCmd)
+	destinationsCmd.AddCommand(destinationsListCmd, destinationsAddCmd, destinationsDeleteCmd, destinationsStatusCmd)
+	destinationsAddCmd.Flags().StringVar(&destinationsToken, "token", "", "token for the destination, if it needs one")
+	addRemoteFlags(destinationsCmd)
+}
+
+var destinationsCmd = &cobra.Command{
+	Use:   "destinations",
+	Short: "Manage the destinations of a running instance",
+}
+
+var destinationsListCmd = &cobra.Command{
+	Use:   "list [id]",
+	Short: "List every destination, or show one",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runRemote((*cli).destinationsList),
+}
+
+var destinationsAddCmd = &cobra.Command{
+	Use:   "add <id> <stream> <destination>",
+	Short: "Add or replace a destination",
+	Args:  cobra.ExactArgs(3),
+	Run:   runRemote((*cli).destinationsAdd),
+}
+
+var destinationsDeleteCmd = &cobra.Command{
+	Use:   "delete <id>|all",
+	Short: "Delete a destination, or every destination except those of channels",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRemote((*cli).destinationsDelete),
+}
+
+var destinationsStatusCmd = &cobra.Command{
+	Use:   "status [id]",
+	Short: "Show the connection state of every destination, or of one",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runRemote((*cli).destinationsStatus),
+}
+
+func (c *cli) destinationsList(args []string) error {
+
+	if len(args) == 1 {
+		data, err := c.remote.call("GET", "/api/destinations/"+args[0], nil)
+		if err != nil {
+			return err
+		}
+		var rule rwc.Rule
+		return c.show(data, &rule, func(w io.Writer) {
+			destinationsTable(w, map[string]rwc.Rule{rule.Id: rule})
+		})
+	}
+
+	data, err := c.remote.call("GET", "/api/destinations/all", nil)
+	if err != nil {
+		return err
+	}
+
+	var rules map[string]rwc.Rule
+
+	return c.show(data, &rules, func(w io.Writer) {
+		destinationsTable(w, rules)
+	})
+}
+
+func destinationsTable(w io.Writer, rules map[string]rwc.Rule) {
+
+	ids := []string{}
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(w, "ID\tSTREAM\tDESTINATION")
+	for _, id := range ids {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", id, rules[id].Stream, rules[id].Destination)
+	}
+}
+
+func (c *cli) destinationsAdd(args []string) error {
+
+	rule := rwc.Rule{Id: args[0], Stream: args[1], Destination: args[2], Token: destinationsToken}
+
+	data, err := c.remote.call("POST", "/api/destinations", rule)
+	if err != nil {
+		return err
+	}
+
+	return c.show(data, &rule, func(w io.Writer) {
+		destinationsTable(w, map[string]rwc.Rule{rule.Id: rule})
+	})
+}
+
+func (c *cli) destinationsDelete(args []string) error {
+
+	data, err := c.remote.call("DELETE", "/api/destinations/"+args[0], nil)
+	if err != nil {
+		return err
+	}
+
+	return c.showDeleted(data)
+}
+
+func (c *cli) destinationsStatus(args []string) error {
+
+	if len(args) == 1 {
+		data, err := c.remote.call("GET", "/api/destinations/"+args[0]+"/status", nil)
+		if err != nil {
+			return err
+		}
+		var status rwc.Status
+		return c.show(data, &status, func(w io.Writer) {
+			statusTable(w, map[string]rwc.Status{status.Id: status})
+		})
+	}
+
+	data, err := c.remote.call("GET", "/api/destinations/status", nil)
+	if err != nil {
+		return err
+	}
+
+	var statuses map[string]rwc.Status
+
+	return c.show(data, &statuses, func(w io.Writer) {
+		statusTable(w, statuses)
+	})
+}
+
+func statusTable(w io.Writer, statuses map[string]rwc.Status) {
+
+	ids := []string{}
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(w, "ID\tSTREAM\tCONNECTED\tSHAPED\tDROPPED\tDESTINATION")
+	for _, id := range ids {
+		s := statuses[id]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", id, s.Stream, yesNo(s.Connected), s.Shaped, s.Dropped, s.Destination)
+	}
+}
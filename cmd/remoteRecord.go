@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/timdrysdale/vw/recorder"
+)
+
+var recordRule recorder.Rule
+
+func init() {
+	rootCmd.AddCommand(recordCmd)
+	recordCmd.AddCommand(recordStartCmd, recordStopCmd)
+	recordStartCmd.Flags().StringVar(&recordRule.Template, "template", "", "file name template, default {feed}-{time}.ts")
+	recordStartCmd.Flags().IntVar(&recordRule.MaxDurationMs, "max-duration-ms", 0, "start a new file after this long, 0 for never")
+	recordStartCmd.Flags().Int64Var(&recordRule.MaxBytes, "max-bytes", 0, "start a new file after this many bytes, 0 for never")
+	recordStartCmd.Flags().Int64Var(&recordRule.RetainBytes, "retain-bytes", 0, "delete the oldest files beyond this total, 0 for no limit")
+	recordStartCmd.Flags().Int64Var(&recordRule.RetainAgeMs, "retain-age-ms", 0, "delete files older than this, 0 for no limit")
+	addRemoteFlags(recordCmd)
+}
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Start and stop recordings on a running instance",
+}
+
+var recordStartCmd = &cobra.Command{
+	Use:   "start <id> <feed or stream>",
+	Short: "Start recording a feed or stream",
+	Args:  cobra.ExactArgs(2),
+	Run:   runRemote((*cli).recordStart),
+}
+
+var recordStopCmd = &cobra.Command{
+	Use:   "stop <id>|all",
+	Short: "Stop a recording, or every recording; the current file is kept",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRemote((*cli).recordStop),
+}
+
+func (c *cli) recordStart(args []string) error {
+
+	rule := recordRule
+	rule.Id = args[0]
+	rule.Topic = args[1]
+
+	data, err := c.remote.call("POST", "/api/recordings", rule)
+	if err != nil {
+		return err
+	}
+
+	return c.show(data, &rule, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tTOPIC")
+		fmt.Fprintf(w, "%s\t%s\n", rule.Id, rule.Topic)
+	})
+}
+
+func (c *cli) recordStop(args []string) error {
+
+	data, err := c.remote.call("DELETE", "/api/recordings/"+args[0], nil)
+	if err != nil {
+		return err
+	}
+
+	return c.showDeleted(data)
+}
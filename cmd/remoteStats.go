@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/timdrysdale/vw/hub"
+)
+
+func init() {
+	rootCmd.AddCommand(statsCmd, feedsCmd)
+	addRemoteFlags(statsCmd)
+	addRemoteFlags(feedsCmd)
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the message statistics of a running instance",
+	Args:  cobra.NoArgs,
+	Run:   runRemote((*cli).stats),
+}
+
+var feedsCmd = &cobra.Command{
+	Use:   "feeds",
+	Short: "List the feeds of a running instance that are being sent, watched, or are in a stream",
+	Args:  cobra.NoArgs,
+	Run:   runRemote((*cli).feeds),
+}
+
+func (c *cli) stats(args []string) error {
+
+	data, err := c.remote.call("GET", "/api/stats", nil)
+	if err != nil {
+		return err
+	}
+
+	var report hub.HubReport
+
+	return c.show(data, &report, func(w io.Writer) {
+		fmt.Fprintf(w, "started\t%s\n", report.Started)
+		fmt.Fprintf(w, "last\t%s\n\n", report.Last)
+		fmt.Fprintln(w, "\tCOUNT\tMIN\tMEAN\tMAX\tSTDDEV")
+		for _, row := range []struct {
+			name  string
+			stats hub.WelfordStats
+		}{
+			{"audience", report.Audience},
+			{"bytes", report.Bytes},
+			{"latency", report.Latency},
+			{"dt", report.Dt},
+		} {
+			s := row.stats
+			fmt.Fprintf(w, "%s\t%d\t%g\t%g\t%g\t%g\n", row.name, s.Count, s.Min, s.Mean, s.Max, s.Stddev)
+		}
+	})
+}
+
+func (c *cli) feeds(args []string) error {
+
+	data, err := c.remote.call("GET", "/api/feeds/all", nil)
+	if err != nil {
+		return err
+	}
+
+	var feeds map[string]Feed
+
+	return c.show(data, &feeds, func(w io.Writer) {
+		names := []string{}
+		for name := range feeds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "FEED\tINGEST\tWATCHDOG\tSTREAMS")
+		for _, name := range names {
+			f := feeds[name]
+			watchdog := f.Watchdog
+			if watchdog == "" {
+				watchdog = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, f.Ingest, watchdog, strings.Join(f.Streams, ","))
+		}
+	})
+}
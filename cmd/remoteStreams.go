@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/timdrysdale/vw/agg"
+)
+
+var streamsMux bool
+
+func init() {
+	rootCmd.AddCommand(streamsCmd)
+	streamsCmd.AddCommand(streamsListCmd, streamsAddCmd, streamsDeleteCmd)
+	streamsAddCmd.Flags().BoolVar(&streamsMux, "mux", false, "mux the feeds into one MPEG-TS")
+	addRemoteFlags(streamsCmd)
+}
+
+var streamsCmd = &cobra.Command{
+	Use:   "streams",
+	Short: "Manage the streams of a running instance",
+}
+
+var streamsListCmd = &cobra.Command{
+	Use:   "list [stream]",
+	Short: "List every stream, or show one",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runRemote((*cli).streamsList),
+}
+
+var streamsAddCmd = &cobra.Command{
+	Use:   "add <stream> <feed>...",
+	Short: "Add or replace a stream",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   runRemote((*cli).streamsAdd),
+}
+
+var streamsDeleteCmd = &cobra.Command{
+	Use:   "delete <stream>|all",
+	Short: "Delete a stream, or every stream",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRemote((*cli).streamsDelete),
+}
+
+func (c *cli) streamsList(args []string) error {
+
+	if len(args) == 1 {
+		data, err := c.remote.call("GET", "/api/streams/"+trimPath(args[0]), nil)
+		if err != nil {
+			return err
+		}
+		var rule agg.Rule
+		return c.show(data, &rule, func(w io.Writer) {
+			fmt.Fprintln(w, "STREAM\tFEEDS\tMUX")
+			fmt.Fprintf(w, "%s\t%s\t%s\n", rule.Stream, strings.Join(rule.Feeds, ","), yesNo(rule.Mux))
+		})
+	}
+
+	data, err := c.remote.call("GET", "/api/streams/all", nil)
+	if err != nil {
+		return err
+	}
+
+	var rules map[string][]string
+
+	return c.show(data, &rules, func(w io.Writer) {
+		streams := []string{}
+		for stream := range rules {
+			streams = append(streams, stream)
+		}
+		sort.Strings(streams)
+		fmt.Fprintln(w, "STREAM\tFEEDS")
+		for _, stream := range streams {
+			fmt.Fprintf(w, "%s\t%s\n", stream, strings.Join(rules[stream], ","))
+		}
+	})
+}
+
+func (c *cli) streamsAdd(args []string) error {
+
+	data, err := c.remote.call("POST", "/api/streams", agg.Rule{Stream: args[0], Feeds: args[1:], Mux: streamsMux})
+	if err != nil {
+		return err
+	}
+
+	var rule agg.Rule
+
+	return c.show(data, &rule, func(w io.Writer) {
+		fmt.Fprintln(w, "STREAM\tFEEDS\tMUX")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rule.Stream, strings.Join(rule.Feeds, ","), yesNo(rule.Mux))
+	})
+}
+
+func (c *cli) streamsDelete(args []string) error {
+
+	data, err := c.remote.call("DELETE", "/api/streams/"+trimPath(args[0]), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.showDeleted(data)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteStreams(t *testing.T) {
+
+	a := testApp(false)
+	a.Hub.Rules["stream/front"] = []string{"video0", "audio0"}
+
+	server := httptest.NewServer(a.router())
+	defer server.Close()
+
+	var out bytes.Buffer
+	c := &cli{remote: newRemote(server.URL, "", time.Second), out: &out}
+
+	go func() {
+		rule := <-a.Hub.Add
+		if rule.Stream != "stream/back" || len(rule.Feeds) != 1 {
+			t.Errorf("Wrong rule %+v", rule)
+		}
+	}()
+
+	if err := c.streamsAdd([]string{"/stream/back", "video1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+
+	if err := c.streamsList(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "STREAM        FEEDS\nstream/front  video0,audio0\n"
+	if out.String() != expected {
+		t.Errorf("Wrong table; got/wanted\n%s\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	c.json = true
+
+	if err := c.streamsList([]string{"stream/front"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), `"feeds": [`) {
+		t.Errorf("Not indented JSON %s", out.String())
+	}
+
+	err := c.destinationsStatus([]string{"nothere"})
+	if e, ok := err.(remoteError); !ok || e.Code != exitFailed || e.Error() != errDestinationNotFound.Error() {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+func TestRemoteUnavailable(t *testing.T) {
+
+	c := &cli{remote: newRemote("http://localhost:1", "", time.Second), out: ioutil.Discard}
+
+	err := c.stats(nil)
+	if e, ok := err.(remoteError); !ok || e.Code != exitUnavailable {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+func TestRemoteSocket(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vw-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := testApp(false)
+
+	a.ingestStarted("video0")
+
+	path := filepath.Join(dir, "vw.sock")
+
+	srv, err := a.startSocketServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	var out bytes.Buffer
+	c := &cli{remote: newRemote("", path, time.Second), out: &out}
+
+	if err := c.feeds(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "FEED    INGEST  WATCHDOG  STREAMS\nvideo0  1       -         \n"
+	if out.String() != expected {
+		t.Errorf("Wrong table; got/wanted\n%q\n%q", out.String(), expected)
+	}
+}
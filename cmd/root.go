@@ -32,8 +32,9 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
+	// commands exit for themselves if they fail, so this is bad usage
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
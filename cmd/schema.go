@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The OpenAPI and JSON-schema documents are generated from the
+// operations in dispatch.go, and the Go types of their rules and
+// results, so they can't drift from what the APIs actually do.
+
+type schema map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawType = reflect.TypeOf(json.RawMessage{})
+
+var pathNames = regexp.MustCompile(`{([a-zA-Z]+)}`)
+
+// schemaOf describes how encoding/json writes a value of the type
+func schemaOf(v interface{}) schema {
+	return typeSchema(reflect.TypeOf(v))
+}
+
+func typeSchema(t reflect.Type) schema {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case rawType:
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		return schema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := schema{}
+		required := []string{}
+		structSchema(t, properties, &required)
+		s := schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	}
+
+	return schema{} // interface{}, which could be anything
+}
+
+// structSchema adds the fields of a struct, following embedded structs
+// the way encoding/json does. Fields without omitempty are required,
+// because they are always written. A doc tag, e.g. for the units,
+// becomes the description.
+func structSchema(t reflect.Type, properties schema, required *[]string) {
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			structSchema(f.Type, properties, required)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		s := typeSchema(f.Type)
+
+		omitempty := false
+		for _, option := range parts[1:] {
+			switch option {
+			case "omitempty":
+				omitempty = true
+			case "string":
+				s = schema{"type": "string"}
+			}
+		}
+
+		if doc := f.Tag.Get("doc"); doc != "" {
+			s["description"] = doc
+		}
+
+		properties[name] = s
+
+		if !omitempty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// id names an operation in the documents, e.g. listStreamAll
+func (op operation) id() string {
+
+	id := op.Verbs[0] + strings.Title(op.What)
+
+	if op.Which != "" && !isName(op.Which) {
+		id += strings.Title(op.Which)
+	}
+
+	return id
+}
+
+// hasBody is true if a REST request sends the rule as its body,
+// rather than in the path
+func (op operation) hasBody() bool {
+	return op.Rule != nil && !strings.Contains(op.Path, "{") && op.Method != "GET"
+}
+
+// openAPI describes the REST API
+func openAPI() schema {
+
+	paths := schema{}
+
+	errorResponse := schema{"description": "Error",
+		"content": schema{"application/json": schema{"schema": schemaOf(errorReply{})}}}
+
+	for _, op := range operations {
+
+		if op.Path == "" {
+			continue
+		}
+
+		item := schema{"operationId": op.id(),
+			"summary": op.Summary,
+			"responses": schema{
+				"200": schema{"description": "OK",
+					"content": schema{"application/json": schema{"schema": schemaOf(op.Result)}}},
+				"default": errorResponse,
+			},
+		}
+
+		parameters := []schema{}
+		for _, match := range pathNames.FindAllStringSubmatch(op.Path, -1) {
+			parameters = append(parameters, schema{"name": match[1], "in": "path", "required": true, "schema": schema{"type": "string"}})
+		}
+		if len(parameters) > 0 {
+			item["parameters"] = parameters
+		}
+
+		if op.hasBody() {
+			item["requestBody"] = schema{"required": true,
+				"content": schema{"application/json": schema{"schema": schemaOf(op.Rule)}}}
+		}
+
+		methods, ok := paths[op.Path].(schema)
+		if !ok {
+			methods = schema{}
+			paths[op.Path] = methods
+		}
+		methods[strings.ToLower(op.Method)] = item
+	}
+
+	return schema{"openapi": "3.0.3",
+		"info":  schema{"title": "vw", "version": "v0.1.0"},
+		"paths": paths,
+	}
+}
+
+// commandSchema describes the commands for the WS/JSON API, with the
+// result of each in the definitions, named after the operation
+func commandSchema() schema {
+
+	commands := []schema{}
+
+	definitions := schema{
+		"response":     schemaOf(Response{}),
+		"notification": schemaOf(Notification{}),
+		"error":        schemaOf(errorReply{}),
+	}
+
+	for _, op := range operations {
+
+		verbs := []interface{}{}
+		for _, verb := range op.Verbs {
+			verbs = append(verbs, verb)
+		}
+
+		properties := schema{
+			"verb":    schema{"enum": verbs},
+			"id":      schema{"type": []string{"string", "number"}},
+			"version": schema{"enum": []int{apiVersion}},
+		}
+
+		required := []string{"verb"}
+
+		if op.What != "" {
+			properties["what"] = schema{"enum": []string{op.What}}
+			required = append(required, "what")
+		}
+
+		switch {
+		case op.Which == "":
+		case isName(op.Which):
+			properties["which"] = schema{"type": "string", "description": strings.Trim(op.Which, "{}")}
+			required = append(required, "which")
+		default:
+			properties["which"] = schema{"enum": []string{op.Which}}
+			required = append(required, "which")
+		}
+
+		if op.Rule != nil {
+			properties["rule"] = schemaOf(op.Rule)
+			required = append(required, "rule")
+		}
+
+		commands = append(commands, schema{"title": op.id(),
+			"description": op.Summary,
+			"x-scope":     op.scope(), // least scope of a channel that can send it
+			"type":        "object",
+			"properties":  properties,
+			"required":    required,
+		})
+
+		definitions[op.id()] = schemaOf(op.Result)
+	}
+
+	return schema{"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":       "vw WS/JSON API command",
+		"oneOf":       commands,
+		"definitions": definitions,
+	}
+}
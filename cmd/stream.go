@@ -6,27 +6,53 @@ import (
 	"os/signal"
 	"runtime/debug"
 	"runtime/pprof"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/framing"
+	"github.com/timdrysdale/vw/hls"
+	"github.com/timdrysdale/vw/inspect"
+	"github.com/timdrysdale/vw/recorder"
+	"github.com/timdrysdale/vw/replay"
 	"github.com/timdrysdale/vw/rwc"
+	"github.com/timdrysdale/vw/serial"
+	"github.com/timdrysdale/vw/watchdog"
 
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 )
 
 type Specification struct {
-	Port               int    `default:"8888"`
-	LogLevel           string `split_words:"true" default:"TRACE"`
-	MuxBufferLength    int    `default:"10"`
-	ClientBufferLength int    `default:"5"`
-	ClientTimeoutMs    int    `default:"1000"`
-	HttpWaitMs         int    `default:"5000"`
-	HttpFlushMs        int    `default:"5"`
-	HttpTimeoutMs      int    `default:"1000"`
-	CpuProfile         string `default:""`
-	API                string `default:""`
+	Port                       int      `default:"8888"`
+	Socket                     string   `default:""`
+	LogLevel                   string   `split_words:"true" default:"TRACE"`
+	MuxBufferLength            int      `default:"10"`
+	ClientBufferLength         int      `default:"5"`
+	ClientTimeoutMs            int      `default:"1000"`
+	HttpWaitMs                 int      `default:"5000"`
+	HttpFlushMs                int      `default:"5"`
+	HttpTimeoutMs              int      `default:"1000"`
+	CpuProfile                 string   `default:""`
+	API                        string   `default:""`
+	APIToken                   string   `split_words:"true" default:""`
+	AllowedOrigins             []string `split_words:"true"`
+	AllowAnyOrigin             bool     `split_words:"true" default:"false"`
+	RestartCommand             string   `split_words:"true" default:""`
+	RequiredFeeds              []string `split_words:"true"`
+	RequiredFeedMs             int      `split_words:"true" default:"5000"`
+	RequiredDestinations       []string `split_words:"true"`
+	HealthTimeoutMs            int      `split_words:"true" default:"1000"`
+	RecordDir                  string   `split_words:"true" default:"recordings"`
+	HlsStreams                 []string `split_words:"true"`
+	DestinationRateBytesPerSec int64    `split_words:"true" default:"0"`
+	DestinationBurstBytes      int64    `split_words:"true" default:"0"`
+	ShutdownTimeoutMs          int      `split_words:"true" default:"10000"` //more than HttpWaitMs + DrainTimeoutMs
+	DrainTimeoutMs             int      `split_words:"true" default:"1000"`
+	ConfigFile                 string   `split_words:"true" default:""`
 }
 
 func init() {
@@ -48,8 +74,14 @@ var streamCmd = &cobra.Command{
 		}()
 
 		//Websocket has to be instantiated AFTER the Hub
-		app = App{Hub: agg.New(), Closed: make(chan struct{})}
+		app = App{Hub: agg.New(), Closed: make(chan struct{}), Stopping: make(chan struct{}), Events: events.New(), Started: time.Now()}
 		app.Websocket = rwc.New(app.Hub)
+		app.Watchdog = watchdog.New(app.Hub)
+		app.Hls = hls.New(app.Hub)
+		app.Inspector = inspect.New(app.Hub)
+		app.Hub.Events = app.Events
+		app.Websocket.Events = app.Events
+		app.Watchdog.Events = app.Events
 
 		// load configuration from environment variables VW_<var>
 		if err := envconfig.Process("vw", &app.Opts); err != nil {
@@ -86,34 +118,117 @@ var streamCmd = &cobra.Command{
 		//log configuration
 		log.WithField("s", app.Opts).Info("Specification")
 
-		// trap SIGINT
+		app.Watchdog.Restart = strings.Fields(app.Opts.RestartCommand)
+		app.Websocket.Limit = rwc.NewBucket(app.Opts.DestinationRateBytesPerSec, app.Opts.DestinationBurstBytes)
+		app.Websocket.DrainTimeout = time.Duration(app.Opts.DrainTimeoutMs) * time.Millisecond
+		app.Recorder = recorder.New(app.Hub, app.Opts.RecordDir)
+		app.Replayer = replay.New(app.Hub, app.Opts.RecordDir)
+		app.Replayer.Events = app.Events
+		app.Framer = framing.New()
+		app.Serial = serial.New(app.Hub)
+		app.Serial.Events = app.Events
+
+		// trap SIGINT and SIGTERM, and shut down in order; a second
+		// signal, or taking too long, exits immediately
 		channelSignal := make(chan os.Signal, 1)
-		signal.Notify(channelSignal, os.Interrupt)
+		signal.Notify(channelSignal, os.Interrupt, syscall.SIGTERM)
 		go func() {
-			for _ = range channelSignal {
-				close(app.Closed)
-				app.WaitGroup.Wait()
-				os.Exit(1)
+			sig := <-channelSignal
+			log.WithField("signal", sig.String()).Info("Shutting down")
+			go app.stop()
+
+			select {
+			case sig = <-channelSignal:
+				log.WithField("signal", sig.String()).Error("Shutdown interrupted")
+			case <-time.After(time.Duration(app.Opts.ShutdownTimeoutMs) * time.Millisecond):
+				log.Error("Timed out shutting down")
 			}
+			os.Exit(1)
 		}()
 
-		//TODO add waitgroup into agg/hub and rwc
+		app.run(app.Hub.RunWithStats)
 
-		go app.Hub.RunWithStats(app.Closed)
+		app.run(app.Websocket.Run)
 
-		go app.Websocket.Run(app.Closed)
+		app.run(app.Watchdog.Run)
 
-		go app.internalAPI("api")
+		app.run(app.Recorder.Run)
 
-		if app.Opts.API != "" {
-			app.Websocket.Add <- rwc.Rule{Stream: "api", Destination: app.Opts.API, Id: "apiRule"}
+		app.runIngest(app.Replayer.Run)
+
+		app.runIngest(app.Serial.Run)
+
+		app.run(app.Hls.Run)
+
+		app.run(app.Framer.Run)
+
+		app.run(app.Inspector.Run)
+
+		// required feeds need a watchdog to tell us if they are active
+		for _, feed := range app.Opts.RequiredFeeds {
+			app.Watchdog.Add <- watchdog.Rule{Feed: feed,
+				ThresholdMs: app.Opts.RequiredFeedMs,
+				Actions:     []string{watchdog.ActionLog, watchdog.ActionEvent}}
 		}
 
-		app.WaitGroup.Add(1)
+		for _, stream := range app.Opts.HlsStreams {
+			app.Hls.Add <- hls.Rule{Stream: stream}
+		}
+
+		// without VW_API, this still answers local clients on /ws/api
+		app.addChannel(Channel{Name: apiChannel, Destination: app.Opts.API, Token: app.Opts.APIToken, Scope: ScopeAdmin})
+
+		// rules from the config file, re-read on SIGHUP
+		if app.Opts.ConfigFile != "" {
+			if _, err := app.reloadConfig(); err != nil {
+				log.WithField("error", err).Fatal("Config file failed")
+			}
+			channelReload := make(chan os.Signal, 1)
+			signal.Notify(channelReload, syscall.SIGHUP)
+			go func() {
+				for range channelReload {
+					app.reloadConfig()
+				}
+			}()
+		}
+
+		app.ingestGroup.Add(1)
 		go app.startHttp()
 
+		app.Events.Publish(app.startedEvent())
+
 		// take it easy, pal
 		app.WaitGroup.Wait()
 
+		log.Info("Shut down")
+
 	},
 }
+
+// run starts a component that stops when app.Closed is closed, and which
+// we wait for before exiting
+func (app *App) run(component func(closed chan struct{})) {
+	app.WaitGroup.Add(1)
+	go func() {
+		defer app.WaitGroup.Done()
+		component(app.Closed)
+	}()
+}
+
+// stop shuts down ingest first, then the hubs and everything else, so
+// that nothing is still sending to the hubs when they stop
+func (app *App) stop() {
+	close(app.Stopping)
+	app.ingestGroup.Wait()
+	close(app.Closed)
+}
+
+// runIngest starts a component that sends to the hubs, which stops when
+// app.Stopping is closed, so that it is done before the hubs stop
+func (app *App) runIngest(component func(closed chan struct{})) {
+	app.ingestGroup.Add(1)
+	go func() {
+		defer app.ingestGroup.Done()
+		component(app.Stopping)
+	}()
+}
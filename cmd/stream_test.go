@@ -15,6 +15,7 @@ import (
 	"github.com/gorilla/mux"
 	crossbar "github.com/timdrysdale/crossbar/cmd"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
 	"github.com/timdrysdale/vw/rwc"
 )
 
@@ -441,3 +442,41 @@ func reportSizeAuth(w http.ResponseWriter, r *http.Request, msgSize chan int, au
 		msgSize <- len(message)
 	}
 }
+
+func TestShutdownOrder(t *testing.T) {
+
+	a := testApp(true)
+
+	sent := make(chan struct{})
+
+	// like handleTs, sending what it has when told to stop
+	a.runIngest(func(closed chan struct{}) {
+		<-closed
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-a.Closed:
+			t.Error("Hubs stopped before ingest")
+		case a.Hub.Broadcast <- hub.Message{Sender: hub.Client{Topic: "video0"}, Data: []byte("last"), Sent: time.Now()}:
+			close(sent)
+		}
+	})
+
+	stopped := make(chan struct{})
+
+	go func() {
+		a.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Did not stop")
+	}
+
+	select {
+	case <-sent:
+	default:
+		t.Error("Ingest did not get to send before the hubs stopped")
+	}
+}
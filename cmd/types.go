@@ -3,26 +3,55 @@ package cmd
 import (
 	"bytes"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/counter"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/framing"
+	"github.com/timdrysdale/vw/hls"
 	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/inspect"
+	"github.com/timdrysdale/vw/recorder"
+	"github.com/timdrysdale/vw/replay"
 	"github.com/timdrysdale/vw/rwc"
+	"github.com/timdrysdale/vw/serial"
+	"github.com/timdrysdale/vw/watchdog"
 )
 
 type App struct {
-	Closed    chan struct{}
-	Hub       *agg.Hub
-	Opts      Specification
-	Websocket *rwc.Hub
-	WaitGroup sync.WaitGroup
+	channels     map[string]*channel //control channels for the WS/JSON API, by name
+	channelMux   sync.Mutex          //guards channels
+	Closed       chan struct{}
+	Config       Config     //rules last applied from Opts.ConfigFile
+	configMux    sync.Mutex //one reload at a time, from SIGHUP or the APIs
+	Events       *events.Bus
+	Framer       *framing.Framer
+	Hls          *hls.Segmenter
+	Hub          *agg.Hub
+	ingest       map[string]int //connections sending to each feed
+	ingestMux    sync.Mutex     //guards ingest
+	ingestGroup  sync.WaitGroup //ingest still running, which must stop before the hubs do
+	Inspector    *inspect.Inspector
+	Opts         Specification
+	OriginDenied counter.Counter
+	Recorder     *recorder.Recorder
+	Replayer     *replay.Replayer
+	Serial       *serial.Serial
+	Started      time.Time
+	Stopping     chan struct{} //closed on shutdown before Closed, to stop ingest while the hubs still run
+	Watchdog     *watchdog.Watchdog
+	Websocket    *rwc.Hub
+	WaitGroup    sync.WaitGroup
 }
 
 type WsHandlerClient struct {
 	Messages   *hub.Client
 	Conn       *websocket.Conn
-	UserAgent  string //r.UserAgent()
-	RemoteAddr string //r.Header.Get("X-Forwarded-For")
+	Framing    *framing.Splitter //nil, or feed not framed, sends messages as they arrive
+	UserAgent  string            //r.UserAgent()
+	RemoteAddr string            //r.Header.Get("X-Forwarded-For")
 }
 
 type mutexBuffer struct {
@@ -7,10 +7,21 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/framing"
+	"github.com/timdrysdale/vw/hls"
+	"github.com/timdrysdale/vw/inspect"
+	"github.com/timdrysdale/vw/recorder"
+	"github.com/timdrysdale/vw/replay"
 	"github.com/timdrysdale/vw/rwc"
+	"github.com/timdrysdale/vw/serial"
+	"github.com/timdrysdale/vw/ts"
+	"github.com/timdrysdale/vw/watchdog"
 )
 
 var unix = "foo\r\n"
@@ -38,12 +49,53 @@ func writeDataFile(size int, name string) ([]byte, error) {
 
 }
 
+// replayFiles gives the app's replayer a directory of its own, holding
+// a one packet MPEG-TS file for each name; remove it when done
+func replayFiles(t *testing.T, a *App, names ...string) string {
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		name = filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, ts.Encode(ts.Header{PID: 0x100}, nil), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a.Replayer.Dir = dir
+
+	return dir
+}
+
 func testApp(running bool) *App {
-	a := &App{Hub: agg.New(), Closed: make(chan struct{})}
+	a := &App{Hub: agg.New(), Closed: make(chan struct{}), Stopping: make(chan struct{}), Events: events.New(), Started: time.Now()}
 	a.Websocket = rwc.New(a.Hub)
+	a.Watchdog = watchdog.New(a.Hub)
+	a.Recorder = recorder.New(a.Hub, os.TempDir())
+	a.Replayer = replay.New(a.Hub, os.TempDir())
+	a.Serial = serial.New(a.Hub)
+	a.Hls = hls.New(a.Hub)
+	a.Framer = framing.New()
+	a.Inspector = inspect.New(a.Hub)
+	a.Replayer.Events = a.Events
+	a.Hub.Events = a.Events
+	a.Websocket.Events = a.Events
+	a.Watchdog.Events = a.Events
+	a.Opts.HealthTimeoutMs = 100
 	if running {
 		go a.Hub.Run(a.Closed)
 		go a.Websocket.Run(a.Closed)
+		go a.Watchdog.Run(a.Closed)
+		go a.Recorder.Run(a.Closed)
+		go a.Replayer.Run(a.Closed)
+		go a.Serial.Run(a.Closed)
+		go a.Hls.Run(a.Closed)
+		go a.Framer.Run(a.Closed)
+		go a.Inspector.Run(a.Closed)
 	}
 	return a
 }
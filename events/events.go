@@ -0,0 +1,60 @@
+package events
+
+import (
+	"time"
+)
+
+func New() *Bus {
+	return &Bus{subscribers: make(map[chan Event]bool)}
+}
+
+// Publish sends the event to every subscriber without blocking;
+// subscribers that are not keeping up miss the event.
+func (b *Bus) Publish(e Event) {
+
+	if b == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			//ignore
+		}
+	}
+}
+
+// Subscribe returns a channel that receives events until Unsubscribe is called.
+func (b *Bus) Subscribe(size int) chan Event {
+
+	ch := make(chan Event, size)
+
+	if b == nil {
+		return ch
+	}
+
+	b.mux.Lock()
+	b.subscribers[ch] = true
+	b.mux.Unlock()
+
+	return ch
+}
+
+func (b *Bus) Unsubscribe(ch chan Event) {
+
+	if b == nil {
+		return
+	}
+
+	b.mux.Lock()
+	delete(b.subscribers, ch)
+	b.mux.Unlock()
+}
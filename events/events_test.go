@@ -0,0 +1,82 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+
+	b := New()
+
+	ch0 := b.Subscribe(2)
+	ch1 := b.Subscribe(2)
+
+	b.Publish(Event{Kind: StreamAdded, Topic: "stream/large"})
+
+	for i, ch := range []chan Event{ch0, ch1} {
+		select {
+		case e := <-ch:
+			if e.Kind != StreamAdded || e.Topic != "stream/large" {
+				t.Errorf("subscriber %d got wrong event %v", i, e)
+			}
+			if time.Since(e.Time) > time.Second {
+				t.Errorf("subscriber %d got event without time set", i)
+			}
+		case <-time.After(10 * time.Millisecond):
+			t.Errorf("subscriber %d timed out", i)
+		}
+	}
+
+	b.Unsubscribe(ch1)
+
+	b.Publish(Event{Kind: StreamDeleted})
+
+	select {
+	case <-ch0:
+	case <-time.After(10 * time.Millisecond):
+		t.Error("subscriber 0 timed out")
+	}
+
+	select {
+	case e := <-ch1:
+		t.Errorf("unsubscribed channel got event %v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+
+	b := New()
+
+	ch := b.Subscribe(1)
+
+	done := make(chan struct{})
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.Publish(Event{Kind: FeedStarted})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Publish blocked on slow subscriber")
+	}
+
+	if len(ch) != 1 {
+		t.Errorf("wrong number of buffered events got/wanted %d/%d", len(ch), 1)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+
+	var b *Bus
+
+	b.Publish(Event{Kind: ProcessStarted})
+
+	ch := b.Subscribe(1)
+	b.Unsubscribe(ch)
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of event that we report
+const (
+	ClientRegistered        = "client/registered"
+	ClientUnregistered      = "client/unregistered"
+	FeedStarted             = "feed/started"
+	FeedSilent              = "feed/silent"
+	StreamAdded             = "stream/added"
+	StreamDeleted           = "stream/deleted"
+	DestinationConnected    = "destination/connected"
+	DestinationDisconnected = "destination/disconnected"
+	DestinationAuthFailed   = "destination/authfailed"
+	DestinationAdded        = "destination/added"
+	DestinationDeleted      = "destination/deleted"
+	ProcessStarted          = "process/started"
+	ProcessRestarted        = "process/restarted"
+	ConfigReloaded          = "config/reloaded"
+	ConfigFailed            = "config/failed"
+)
+
+// Bus distributes events to any number of subscribers.
+// A nil *Bus is valid and discards everything published to it,
+// so that components can be used without event reporting.
+type Bus struct {
+	mux         sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+type Event struct {
+	Kind   string    `json:"kind"`
+	Topic  string    `json:"topic,omitempty"`
+	Id     string    `json:"id,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
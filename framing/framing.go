@@ -0,0 +1,292 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/hub"
+)
+
+const DefaultMaxBytes = 65536
+
+var errNoFeed = errors.New("Framing needs a feed")
+var errBadMode = errors.New("Mode must be line, delimiter or length")
+var errNoDelimiter = errors.New("Delimiter mode needs a delimiter")
+var errBadLengthBytes = errors.New("Length must be 1, 2 or 4 bytes")
+var errBadMaxBytes = errors.New("Maximum bytes cannot be negative")
+
+func New() *Framer {
+
+	f := &Framer{
+		Add:    make(chan Rule),
+		Delete: make(chan string), //Feed string
+		feeds:  make(map[string]*feed),
+	}
+
+	return f
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Feed == "" || rule.Feed == "deleteAll" {
+		return errNoFeed
+	}
+
+	switch rule.Mode {
+	case ModeLine:
+	case ModeDelimiter:
+		if rule.Delimiter == "" {
+			return errNoDelimiter
+		}
+	case ModeLength:
+		switch rule.LengthBytes {
+		case 0, 1, 2, 4:
+		default:
+			return errBadLengthBytes
+		}
+	default:
+		return errBadMode
+	}
+
+	if rule.MaxBytes < 0 {
+		return errBadMaxBytes
+	}
+
+	return nil
+}
+
+// withDefaults fills in what the rule left out
+func withDefaults(rule Rule) Rule {
+
+	if rule.Mode == ModeLength && rule.LengthBytes == 0 {
+		rule.LengthBytes = 2
+	}
+	if rule.MaxBytes == 0 {
+		rule.MaxBytes = DefaultMaxBytes
+	}
+
+	return rule
+}
+
+func (f *Framer) Run(closed chan struct{}) {
+
+	for {
+		select {
+		case <-closed:
+			return
+		case rule := <-f.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad framing rule")
+				break
+			}
+
+			rule = withDefaults(rule)
+
+			f.mux.Lock()
+			f.feeds[rule.Feed] = &feed{rule: rule}
+			f.mux.Unlock()
+
+			log.WithField("rule", rule).Info("Framing feed")
+
+		case name := <-f.Delete:
+
+			f.mux.Lock()
+			if name == "deleteAll" {
+				f.feeds = make(map[string]*feed)
+			} else {
+				delete(f.feeds, name)
+			}
+			f.mux.Unlock()
+		}
+	}
+}
+
+// Report returns the status of every framed feed
+func (f *Framer) Report() map[string]Status {
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for name, fd := range f.feeds {
+		report[name] = Status{Rule: fd.rule,
+			Records:   fd.records,
+			Malformed: fd.malformed}
+	}
+
+	return report
+}
+
+// Splitter returns a splitter for one connection to a feed.
+// A nil *Framer gives a nil *Splitter, which frames nothing.
+func (f *Framer) Splitter(feed string) *Splitter {
+
+	if f == nil {
+		return nil
+	}
+
+	return &Splitter{framer: f, feed: feed}
+}
+
+// Write returns the whole records completed by the data, or false if
+// the feed is not framed, in which case the data should be sent as it is
+func (s *Splitter) Write(data []byte) ([][]byte, bool) {
+
+	if s == nil {
+		return nil, false
+	}
+
+	s.framer.mux.Lock()
+	fd, ok := s.framer.feeds[s.feed]
+	var rule Rule
+	if ok {
+		rule = fd.rule
+	}
+	s.framer.mux.Unlock()
+
+	if !ok || rule != s.rule {
+		// start afresh with the new rule, if there is one
+		s.rule = rule
+		s.partial = nil
+		s.skip = 0
+		s.skipTo = false
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	var records [][]byte
+	var malformed int64
+
+	emit := func(record []byte) {
+		switch {
+		case len(record) == 0:
+		case len(record) > rule.MaxBytes, rule.JSON && !json.Valid(record):
+			malformed++
+		default:
+			records = append(records, append([]byte{}, record...))
+		}
+	}
+
+	data = append(s.partial, data...)
+
+	if rule.Mode == ModeLength {
+		data = s.length(data, emit, &malformed)
+	} else {
+		data = s.delimited(data, emit, &malformed)
+	}
+
+	s.partial = append([]byte{}, data...)
+
+	s.framer.mux.Lock()
+	fd.records += int64(len(records))
+	fd.malformed += malformed
+	s.framer.mux.Unlock()
+
+	return records, true
+}
+
+// Kind of message to send the records as, which is text unless
+// they are length-framed and not JSON
+func (s *Splitter) Kind() hub.Kind {
+
+	if s.rule.Mode == ModeLength && !s.rule.JSON {
+		return hub.Binary
+	}
+
+	return hub.Text
+}
+
+// delimited emits every record that has ended, and returns what is left
+func (s *Splitter) delimited(data []byte, emit func([]byte), malformed *int64) []byte {
+
+	delimiter := []byte("\n")
+	if s.rule.Mode == ModeDelimiter {
+		delimiter = []byte(s.rule.Delimiter)
+	}
+
+	for {
+		i := bytes.Index(data, delimiter)
+		if i < 0 {
+			break
+		}
+
+		record := data[:i]
+		data = data[i+len(delimiter):]
+
+		if s.skipTo {
+			// the end of a record that was already too long
+			s.skipTo = false
+			continue
+		}
+
+		if s.rule.Mode == ModeLine {
+			record = bytes.TrimSuffix(record, []byte("\r"))
+		}
+
+		emit(record)
+	}
+
+	// don't wait any longer for a record that is already too long
+	if len(data) > s.rule.MaxBytes {
+		if !s.skipTo {
+			*malformed++
+		}
+		s.skipTo = true
+		data = nil
+	}
+
+	return data
+}
+
+// length emits every record that has arrived in full, and returns what is left
+func (s *Splitter) length(data []byte, emit func([]byte), malformed *int64) []byte {
+
+	size := s.rule.LengthBytes
+
+	for {
+		if s.skip > 0 {
+			n := s.skip
+			if n > len(data) {
+				n = len(data)
+			}
+			data = data[n:]
+			s.skip -= n
+			if s.skip > 0 {
+				break
+			}
+		}
+
+		if len(data) < size {
+			break
+		}
+
+		n := 0
+		for _, b := range data[:size] {
+			n = n<<8 | int(b)
+		}
+
+		if n > s.rule.MaxBytes {
+			// skip it rather than wait for it, to stay in step
+			*malformed++
+			s.skip = n
+			data = data[size:]
+			continue
+		}
+
+		if len(data) < size+n {
+			break
+		}
+
+		emit(data[size : size+n])
+		data = data[size+n:]
+	}
+
+	return data
+}
@@ -0,0 +1,128 @@
+package framing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/hub"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+func startFramer(t *testing.T, rules ...Rule) (*Framer, chan struct{}) {
+
+	closed := make(chan struct{})
+
+	f := New()
+	go f.Run(closed)
+
+	for _, rule := range rules {
+		f.Add <- rule
+	}
+
+	// wait for the last rule to be stored
+	for i := 0; i < 100; i++ {
+		if len(f.Report()) == len(rules) {
+			return f, closed
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("Rules not added")
+	return nil, nil
+}
+
+// write sends each chunk, and joins the records with |
+func write(s *Splitter, chunks ...string) string {
+
+	got := []string{}
+
+	for _, chunk := range chunks {
+		records, _ := s.Write([]byte(chunk))
+		for _, record := range records {
+			got = append(got, string(record))
+		}
+	}
+
+	return strings.Join(got, "|")
+}
+
+func TestCheck(t *testing.T) {
+
+	if err := Check(Rule{Feed: "pendulum", Mode: ModeLine, JSON: true}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bad := map[error]Rule{
+		errNoFeed:         {Mode: ModeLine},
+		errBadMode:        {Feed: "pendulum", Mode: "csv"},
+		errNoDelimiter:    {Feed: "pendulum", Mode: ModeDelimiter},
+		errBadLengthBytes: {Feed: "pendulum", Mode: ModeLength, LengthBytes: 3},
+		errBadMaxBytes:    {Feed: "pendulum", Mode: ModeLine, MaxBytes: -1},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error for %+v; got %v wanted %v", rule, err, expected)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+
+	f, closed := startFramer(t,
+		Rule{Feed: "line", Mode: ModeLine, MaxBytes: 8},
+		Rule{Feed: "delimiter", Mode: ModeDelimiter, Delimiter: "}{"},
+		Rule{Feed: "json", Mode: ModeLine, JSON: true})
+	defer close(closed)
+
+	if got := write(f.Splitter("line"), "ab", "c\r\nd\n\nef", "\n", "0123456789", "ab\ng\n"); got != "abc|d|ef|g" {
+		t.Errorf("Wrong line records %q", got)
+	}
+
+	if got := write(f.Splitter("delimiter"), "a}", "{b}{"); got != "a|b" {
+		t.Errorf("Wrong delimited records %q", got)
+	}
+
+	if got := write(f.Splitter("json"), `{"x":1}`+"\n"+`{"x":`, "\n", `[2]`+"\n"); got != `{"x":1}|[2]` {
+		t.Errorf("Wrong JSON records %q", got)
+	}
+
+	if records, framed := f.Splitter("video0").Write([]byte("a\n")); framed || records != nil {
+		t.Error("Unframed feed was framed")
+	}
+
+	report := f.Report()
+
+	if report["line"].Records != 4 || report["line"].Malformed != 1 {
+		t.Errorf("Wrong line counts %+v", report["line"])
+	}
+
+	if report["json"].Records != 2 || report["json"].Malformed != 1 {
+		t.Errorf("Wrong JSON counts %+v", report["json"])
+	}
+}
+
+func TestSplitLength(t *testing.T) {
+
+	f, closed := startFramer(t, Rule{Feed: "length", Mode: ModeLength, MaxBytes: 4})
+	defer close(closed)
+
+	s := f.Splitter("length")
+
+	if got := write(s, "\x00\x02a", "b\x00", "\x0512345\x00\x01c"); got != "ab|c" {
+		t.Errorf("Wrong length records %q", got)
+	}
+
+	if s.Kind() != hub.Binary {
+		t.Error("Length records are not text unless they are JSON")
+	}
+
+	if status := f.Report()["length"]; status.Rule.LengthBytes != 2 || status.Records != 2 || status.Malformed != 1 {
+		t.Errorf("Wrong status %+v", status)
+	}
+}
@@ -0,0 +1,55 @@
+package framing
+
+import (
+	"sync"
+)
+
+// Modes of framing
+const (
+	ModeLine      = "line"      // records end in \n, and a \r before it is dropped
+	ModeDelimiter = "delimiter" // records end in the delimiter
+	ModeLength    = "length"    // records start with their length, big-endian
+)
+
+type Framer struct {
+	Add    chan Rule
+	Delete chan string //Feed string
+	mux    sync.Mutex
+	feeds  map[string]*feed //map Feed string to feed
+}
+
+// Rule frames the data arriving at a feed into records, so that
+// each message on the hub is one whole record
+type Rule struct {
+	Feed        string `json:"feed"`
+	Mode        string `json:"mode"`                  // line, delimiter or length
+	Delimiter   string `json:"delimiter,omitempty"`   // for delimiter mode
+	LengthBytes int    `json:"lengthBytes,omitempty"` // size of the length, for length mode: 1, 2 (default) or 4
+	JSON        bool   `json:"json,omitempty"`        // drop records that are not valid JSON
+	MaxBytes    int    `json:"maxBytes,omitempty"`    // drop records longer than this, default 65536
+}
+
+// feed holds the rule and counts for one feed
+type feed struct {
+	rule      Rule
+	records   int64
+	malformed int64
+}
+
+// Splitter frames the data from one connection to a feed, using
+// whatever rule the feed has when the data arrives
+type Splitter struct {
+	framer  *Framer
+	feed    string
+	rule    Rule
+	partial []byte
+	skip    int  // bytes still to drop from a record that was too long
+	skipTo  bool // drop everything up to the next delimiter
+}
+
+// Status that we report externally
+type Status struct {
+	Rule      Rule  `json:"rule"`
+	Records   int64 `json:"records"`
+	Malformed int64 `json:"malformed"` // dropped for being too long, or not JSON
+}
@@ -5,9 +5,6 @@ go 1.12
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
 	github.com/eclesh/welford v0.0.0-20150116075914-eec62615b1f0
-	github.com/gobwas/httphead v0.0.0-20180130184737-2c6c146eadee // indirect
-	github.com/gobwas/pool v0.2.0 // indirect
-	github.com/gobwas/ws v1.0.2
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/websocket v1.4.1
@@ -27,5 +24,5 @@ require (
 	github.com/timdrysdale/hub v0.0.0-20191012173646-ac51456fe28f
 	github.com/timdrysdale/reconws v0.0.0-20191012131359-34f25fee9e0e
 	github.com/timdrysdale/rwc v0.0.0-20191011123131-00e6abe5e8d0
-	golang.org/x/sys v0.0.0-20191112214154-59a1497f0cea // indirect
+	golang.org/x/sys v0.0.0-20191112214154-59a1497f0cea
 )
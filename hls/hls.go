@@ -0,0 +1,409 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+const (
+	DefaultTargetMs = 2000
+	DefaultLength   = 6
+
+	// cut anyway if there is no keyframe for this many target durations
+	maxTargets = 3
+)
+
+var errNotStream = errors.New("HLS needs a stream/ topic")
+var errNotFound = errors.New("HLS stream not found")
+var errNoSegment = errors.New("Segment not found")
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub) *Segmenter {
+
+	s := &Segmenter{
+		Messages: messages,
+		Add:      make(chan Rule),
+		Delete:   make(chan string), //Stream string
+		outputs:  make(map[string]*Output),
+	}
+
+	return s
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if !strings.HasPrefix(rule.Stream, "stream/") || len(rule.Stream) <= len("stream/") {
+		return errNotStream
+	}
+
+	return nil
+}
+
+// Path is where the playlist for a stream is served from, e.g.
+// stream/front/large is served at /hls/front/large/index.m3u8
+func Path(stream string) string {
+	return "/hls/" + strings.TrimPrefix(stream, "stream/") + "/index.m3u8"
+}
+
+func (s *Segmenter) Run(closed chan struct{}) {
+
+	defer s.stopAll()
+
+	for {
+		select {
+		case <-closed:
+			s.closing = true
+			return
+		case rule := <-s.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad HLS rule")
+				break
+			}
+
+			s.stop(rule.Stream)
+
+			if rule.TargetMs <= 0 {
+				rule.TargetMs = DefaultTargetMs
+			}
+
+			if rule.Length <= 0 {
+				rule.Length = DefaultLength
+			}
+
+			messageClient := &hub.Client{Hub: s.Messages.Hub,
+				Name:  "hls",
+				Topic: rule.Stream,
+				Send:  make(chan hub.Message, 64),
+				Stats: hub.NewClientStats()}
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			output := &Output{Rule: rule,
+				Messages: messageClient,
+				Context:  ctx,
+				Cancel:   cancel,
+				Stopped:  make(chan struct{}),
+				pmt:      make(map[uint16][]byte),
+				pmtPIDs:  make(map[uint16]bool)}
+
+			s.mux.Lock()
+			s.outputs[rule.Stream] = output
+			s.mux.Unlock()
+
+			s.Messages.Register <- messageClient
+
+			go output.Segment()
+
+			log.WithField("rule", rule).Info("Started HLS")
+
+		case stream := <-s.Delete:
+
+			if stream == "deleteAll" {
+				s.stopAll()
+			} else {
+				s.stop(stream)
+			}
+		}
+	}
+}
+
+// Report returns the status of every output
+func (s *Segmenter) Report() map[string]Status {
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for stream, output := range s.outputs {
+		report[stream] = output.Status()
+	}
+
+	return report
+}
+
+// Playlist returns the live playlist for a stream
+func (s *Segmenter) Playlist(stream string) ([]byte, error) {
+
+	output, err := s.output(stream)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Playlist(), nil
+}
+
+// Segment returns a segment of a stream, if it is still held
+func (s *Segmenter) Segment(stream string, sequence int) ([]byte, error) {
+
+	output, err := s.output(stream)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output.mux.Lock()
+	defer output.mux.Unlock()
+
+	for _, segment := range output.segments {
+		if segment.Sequence == sequence {
+			return segment.Data, nil
+		}
+	}
+
+	return nil, errNoSegment
+}
+
+func (s *Segmenter) output(stream string) (*Output, error) {
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	output, ok := s.outputs[stream]
+
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return output, nil
+}
+
+func (s *Segmenter) stop(stream string) {
+
+	s.mux.Lock()
+	output, ok := s.outputs[stream]
+	delete(s.outputs, stream)
+	s.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !s.closing {
+		s.Messages.Unregister <- output.Messages
+	}
+	output.Cancel()
+	<-output.Stopped
+
+	log.WithField("stream", stream).Info("Stopped HLS")
+}
+
+func (s *Segmenter) stopAll() {
+
+	s.mux.Lock()
+	streams := []string{}
+	for stream := range s.outputs {
+		streams = append(streams, stream)
+	}
+	s.mux.Unlock()
+
+	for _, stream := range streams {
+		s.stop(stream)
+	}
+}
+
+func (o *Output) Status() Status {
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	return Status{Rule: o.Rule,
+		Segments: len(o.segments),
+		Sequence: o.next,
+		Playlist: Path(o.Rule.Stream)}
+}
+
+// Playlist renders the segments currently held as a live playlist
+func (o *Output) Playlist() []byte {
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	target := time.Duration(o.Rule.TargetMs) * time.Millisecond
+	for _, segment := range o.segments {
+		if segment.Duration > target {
+			target = segment.Duration
+		}
+	}
+
+	first := o.next
+	if len(o.segments) > 0 {
+		first = o.segments[0].Sequence
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+
+	for _, segment := range o.segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts\n", segment.Duration.Seconds(), segment.Sequence)
+	}
+
+	return b.Bytes()
+}
+
+// Segment cuts messages into segments until stopped
+func (o *Output) Segment() {
+
+	defer close(o.Stopped)
+
+	for {
+		select {
+		case <-o.Context.Done():
+			return
+		case msg, ok := <-o.Messages.Send:
+			if !ok {
+				return
+			}
+			o.write(msg.Data)
+		}
+	}
+}
+
+// write splits data into packets, carrying over any part packet
+func (o *Output) write(data []byte) {
+
+	buf := append(o.partial, data...)
+
+	for len(buf) >= ts.PacketSize {
+
+		if buf[0] != ts.SyncByte {
+			// lost sync, so skip to the next sync byte
+			i := bytes.IndexByte(buf, ts.SyncByte)
+			if i < 0 {
+				buf = buf[:0]
+				break
+			}
+			buf = buf[i:]
+			continue
+		}
+
+		o.packet(buf[:ts.PacketSize])
+		buf = buf[ts.PacketSize:]
+	}
+
+	o.partial = append([]byte{}, buf...)
+}
+
+// packet adds one packet to the current segment, cutting first if a
+// keyframe arrives after the target duration
+func (o *Output) packet(p []byte) {
+
+	// keep the tables so every segment can start with them
+	if programs, ok := ts.PAT(p); ok {
+		o.pat = append([]byte{}, p...)
+		o.pmtPIDs = make(map[uint16]bool)
+		for _, pid := range programs {
+			o.pmtPIDs[pid] = true
+		}
+	} else if o.pmtPIDs[ts.PID(p)] && ts.PayloadUnitStart(p) {
+		o.pmt[ts.PID(p)] = append([]byte{}, p...)
+	}
+
+	o.tick(p)
+
+	if o.current != nil {
+		elapsed := o.elapsed()
+		target := time.Duration(o.Rule.TargetMs) * time.Millisecond
+		if (ts.RandomAccess(p) && elapsed >= target) || elapsed >= maxTargets*target {
+			o.cut(elapsed)
+		}
+	}
+
+	if o.current == nil {
+		if !ts.RandomAccess(p) && o.next == 0 {
+			// wait for a keyframe so the first segment is playable
+			return
+		}
+		o.open()
+	}
+
+	o.current = append(o.current, p...)
+}
+
+// tick updates the clock from the PCR, or the PTS if there is no PCR
+func (o *Output) tick(p []byte) {
+
+	clock, ok := ts.PCR(p)
+
+	if ok && !o.usingPCR {
+		o.usingPCR = true
+		o.timed = false
+	}
+
+	if !ok && !o.usingPCR {
+		var pts uint64
+		if pts, ok = ts.PTS(p); ok {
+			clock = pts * (ts.PCRHz / ts.PTSHz)
+		}
+	}
+
+	if !ok {
+		return
+	}
+
+	if !o.timed || clock < o.start {
+		// start of timing, or a discontinuity
+		o.start = clock
+		o.timed = true
+	}
+
+	o.clock = clock
+}
+
+// elapsed is the duration of the current segment so far
+func (o *Output) elapsed() time.Duration {
+
+	if o.timed {
+		return time.Duration((o.clock - o.start) * 1000 / (ts.PCRHz / 1000000))
+	}
+
+	return time.Since(o.opened)
+}
+
+func (o *Output) open() {
+
+	o.current = []byte{}
+
+	if o.pat != nil {
+		o.current = append(o.current, o.pat...)
+		for _, pmt := range o.pmt {
+			o.current = append(o.current, pmt...)
+		}
+	}
+
+	o.start = o.clock
+	o.opened = time.Now()
+}
+
+func (o *Output) cut(duration time.Duration) {
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	o.segments = append(o.segments, Segment{Sequence: o.next, Duration: duration, Data: o.current})
+
+	if len(o.segments) > o.Rule.Length {
+		o.segments = o.segments[len(o.segments)-o.Rule.Length:]
+	}
+
+	o.next++
+	o.current = nil
+}
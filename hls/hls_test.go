@@ -0,0 +1,135 @@
+package hls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+// frames makes frames 40ms apart, with a keyframe every second, and a
+// PAT/PMT before each keyframe, like ffmpeg does
+func frames(start, n int) []byte {
+
+	data := []byte{}
+
+	for i := start; i < start+n; i++ {
+		key := i%25 == 0
+		if key {
+			data = append(data, ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))...)
+			data = append(data, ts.Encode(ts.Header{PID: 0x1000, PUSI: true}, []byte{0, 0x02})...)
+		}
+		pcr := uint64(i) * 40 * ts.PCRHz / 1000
+		data = append(data, ts.Encode(ts.Header{PID: 0x100, PUSI: true, RandomAccess: key, HasPCR: true, PCR: pcr}, []byte{byte(i)})...)
+		data = append(data, ts.Encode(ts.Header{PID: 0x100}, []byte{byte(i)})...)
+	}
+
+	return data
+}
+
+func TestCheck(t *testing.T) {
+
+	if err := Check(Rule{Stream: "stream/front/large"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	for _, stream := range []string{"", "video0", "stream/"} {
+		if err := Check(Rule{Stream: stream}); err != errNotStream {
+			t.Errorf("Wrong error for %q got/wanted %v/%v", stream, err, errNotStream)
+		}
+	}
+
+	if Path("stream/front/large") != "/hls/front/large/index.m3u8" {
+		t.Errorf("Wrong path %s", Path("stream/front/large"))
+	}
+}
+
+func TestSegments(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	s := New(mh)
+	go s.Run(closed)
+
+	s.Add <- Rule{Stream: "stream/large", Length: 3}
+
+	mh.Add <- agg.Rule{Stream: "stream/large", Feeds: []string{"video0"}}
+
+	time.Sleep(2 * time.Millisecond)
+
+	c := &hub.Client{Hub: mh.Hub, Name: "ffmpeg", Topic: "video0", Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+
+	// start mid-GOP, and split packets across messages
+	data := frames(10, 300)
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		mh.Broadcast <- hub.Message{Sender: *c, Data: data[:n], Type: 2, Sent: time.Now()}
+		data = data[n:]
+		time.Sleep(100 * time.Microsecond) // the hub drops messages if we go too fast
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	playlist, err := s.Playlist("stream/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first keyframe at frame 25, so segments start at 25, 75, 125 ...
+	// and the last complete one is the fifth, from 225 to 275
+	expected := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXTINF:2.000,\n2.ts\n#EXTINF:2.000,\n3.ts\n#EXTINF:2.000,\n4.ts\n"
+
+	if string(playlist) != expected {
+		t.Errorf("Wrong playlist\ngot:\n%s\nwanted:\n%s", playlist, expected)
+	}
+
+	segment, err := s.Segment("stream/large", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(segment)%ts.PacketSize != 0 {
+		t.Error("Segment is not whole packets")
+	}
+
+	if _, ok := ts.PAT(segment); !ok {
+		t.Error("Segment does not start with a PAT")
+	}
+
+	if !bytes.Contains(segment, []byte{byte(225)}) || bytes.Contains(segment[:ts.PacketSize*3], []byte{byte(224)}) {
+		t.Error("Segment does not start at keyframe")
+	}
+
+	if _, err := s.Segment("stream/large", 1); err != errNoSegment {
+		t.Errorf("Wrong error for expired segment got/wanted %v/%v", err, errNoSegment)
+	}
+
+	if !strings.HasSuffix(s.Report()["stream/large"].Playlist, "/hls/large/index.m3u8") {
+		t.Errorf("Wrong status %v", s.Report())
+	}
+
+	s.Delete <- "stream/large"
+
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err := s.Playlist("stream/large"); err != errNotFound {
+		t.Errorf("Wrong error got/wanted %v/%v", err, errNotFound)
+	}
+}
@@ -0,0 +1,63 @@
+package hls
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+)
+
+type Segmenter struct {
+	Messages *agg.Hub
+	Add      chan Rule
+	Delete   chan string //Stream string
+	closing  bool        // messages hub has stopped, so don't unregister
+	mux      sync.Mutex
+	outputs  map[string]*Output //map Stream string to Output
+}
+
+type Rule struct {
+	Stream   string `json:"stream"`
+	TargetMs int    `json:"targetMs,omitempty"` // aim for segments this long, default 2000
+	Length   int    `json:"length,omitempty"`   // segments in the playlist, default 6
+}
+
+// Output segments one stream, keeping the most recent segments in memory
+type Output struct {
+	Rule     Rule
+	Messages *hub.Client
+	Context  context.Context
+	Cancel   context.CancelFunc
+	Stopped  chan struct{}
+	mux      sync.Mutex
+	segments []Segment
+	next     int // sequence number of the segment being built
+
+	// only used by the segmenting goroutine
+	partial  []byte // bytes left over from the last message
+	current  []byte
+	pat      []byte
+	pmt      map[uint16][]byte
+	pmtPIDs  map[uint16]bool
+	start    uint64
+	clock    uint64
+	timed    bool
+	usingPCR bool
+	opened   time.Time
+}
+
+type Segment struct {
+	Sequence int
+	Duration time.Duration
+	Data     []byte
+}
+
+// Status that we report externally
+type Status struct {
+	Rule     Rule   `json:"rule"`
+	Segments int    `json:"segments"`
+	Sequence int    `json:"sequence"`
+	Playlist string `json:"playlist"`
+}
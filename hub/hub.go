@@ -11,11 +11,14 @@ func New() *Hub {
 		Broadcast:  make(chan Message),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
+		Ping:       make(chan struct{}),
+		Reports:    make(chan chan HubReport),
 		Clients:    make(map[string]map[*Client]bool),
-		Stats: HubStats{Audience: welford.New(),
-			Bytes:   welford.New(),
-			Latency: welford.New(),
-			Dt:      welford.New()},
+		Stats: HubStats{Started: time.Now(),
+			Audience: welford.New(),
+			Bytes:    welford.New(),
+			Latency:  welford.New(),
+			Dt:       welford.New()},
 	}
 }
 
@@ -34,6 +37,9 @@ func (h *Hub) Run(closed chan struct{}) {
 		select {
 		case <-closed:
 			return
+		case <-h.Ping:
+		case reply := <-h.Reports:
+			reply <- h.report()
 		case client := <-h.Register:
 			if _, ok := h.Clients[client.Topic]; !ok {
 				h.Clients[client.Topic] = make(map[*Client]bool)
@@ -64,6 +70,9 @@ func (h *Hub) RunWithStats(closed chan struct{}) {
 		select {
 		case <-closed:
 			return
+		case <-h.Ping:
+		case reply := <-h.Reports:
+			reply <- h.report()
 		case client := <-h.Register:
 			if _, ok := h.Clients[client.Topic]; !ok {
 				h.Clients[client.Topic] = make(map[*Client]bool)
@@ -726,3 +726,64 @@ func testDelayedReceive(t *testing.T) {
 	}
 
 }
+
+func TestPing(t *testing.T) {
+
+	h := New()
+	closed := make(chan struct{})
+
+	go h.Run(closed)
+
+	select {
+	case h.Ping <- struct{}{}:
+	case <-time.After(10 * time.Millisecond):
+		t.Error("Running hub did not respond to ping")
+	}
+
+	close(closed)
+	time.Sleep(time.Millisecond)
+
+	select {
+	case h.Ping <- struct{}{}:
+		t.Error("Stopped hub responded to ping")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestReport(t *testing.T) {
+
+	h := New()
+	closed := make(chan struct{})
+
+	go h.RunWithStats(closed)
+
+	report, ok := h.Report(10 * time.Millisecond)
+	if !ok {
+		t.Fatal("Running hub did not report")
+	}
+
+	if report.Last != "never" || report.Bytes.Count != 0 {
+		t.Errorf("Wrong report before any messages %+v", report)
+	}
+
+	c := &Client{Hub: h, Name: "a", Topic: "video0", Send: make(chan Message, 2), Stats: NewClientStats()}
+	h.Register <- c
+
+	h.Broadcast <- Message{Sender: Client{Name: "b", Topic: "video0"}, Data: []byte("hello"), Sent: time.Now()}
+
+	report, ok = h.Report(10 * time.Millisecond)
+	if !ok {
+		t.Fatal("Running hub did not report")
+	}
+
+	if report.Bytes.Count != 1 || report.Bytes.Mean != 5 || report.Audience.Mean != 1 {
+		t.Errorf("Wrong report after one message %+v", report)
+	}
+
+	close(closed)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := h.Report(10 * time.Millisecond); ok {
+		t.Error("Stopped hub reported")
+	}
+}
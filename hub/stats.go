@@ -1,5 +1,11 @@
 package hub
 
+import (
+	"time"
+
+	"github.com/eclesh/welford"
+)
+
 func MpsFromNs(ns float64) float64 {
 	return 1 / (ns * 1e-9)
 }
@@ -96,3 +102,50 @@ func (c *Client) report() Report {
 	}
 }
 */
+
+// Report returns the hub's stats, or false if the hub doesn't respond
+// within the timeout. Only RunWithStats collects stats; Run reports
+// zero counts.
+func (h *Hub) Report(timeout time.Duration) (HubReport, bool) {
+
+	reply := make(chan HubReport, 1)
+
+	select {
+	case h.Reports <- reply:
+		return <-reply, true
+	case <-time.After(timeout):
+		return HubReport{}, false
+	}
+}
+
+// report is only called from the hub's loop, so it can read Stats
+func (h *Hub) report() HubReport {
+
+	last := "never"
+	if !h.Stats.Last.IsZero() {
+		last = h.Stats.Last.Format(time.RFC3339Nano)
+	}
+
+	return HubReport{Started: h.Stats.Started.Format(time.RFC3339Nano),
+		Last:     last,
+		Audience: welfordStats(h.Stats.Audience),
+		Bytes:    welfordStats(h.Stats.Bytes),
+		Latency:  welfordStats(h.Stats.Latency),
+		Dt:       welfordStats(h.Stats.Dt),
+	}
+}
+
+func welfordStats(s *welford.Stats) WelfordStats {
+
+	if s == nil || s.Count() == 0 {
+		return WelfordStats{}
+	}
+
+	return WelfordStats{Count: s.Count(),
+		Min:      s.Min(),
+		Max:      s.Max(),
+		Mean:     s.Mean(),
+		Stddev:   s.Stddev(),
+		Variance: s.Variance(),
+	}
+}
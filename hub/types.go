@@ -22,6 +22,13 @@ type Hub struct {
 	// Unregister requests from clients.
 	Unregister chan *Client
 
+	// Ping is received whenever the hub is responsive
+	Ping chan struct{}
+
+	// Reports asks the hub to send a report of its Stats on the
+	// channel provided, which must be buffered
+	Reports chan chan HubReport
+
 	Stats HubStats
 }
 
@@ -58,7 +65,26 @@ type Message struct {
 	Data   []byte //text data are converted to/from bytes as needed
 	Sender Client
 	Sent   time.Time //when sent
-	Type   int
+	Type   Kind
+}
+
+// Kind of message, kept from sender to receiver. The values are the
+// websocket opcodes (RFC 6455), so a websocket can send a message with
+// int(msg.Type), and label what it receives with Kind(mt), whichever
+// websocket library it uses
+type Kind int
+
+const (
+	Text   Kind = 1 // e.g. JSON, or lines from a serial device
+	Binary Kind = 2 // e.g. MPEG-TS
+	Close  Kind = 8
+	Ping   Kind = 9
+	Pong   Kind = 10
+)
+
+// IsControl is true for messages about the connection, rather than data
+func (k Kind) IsControl() bool {
+	return k == Close || k == Ping || k == Pong
 }
 
 // Client is a middleperson between the hub and whatever is sending/receiving messages on it
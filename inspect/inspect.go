@@ -0,0 +1,375 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+const nullPID = 0x1fff
+
+var errNoTopic = errors.New("Analysis needs a topic")
+var errNotFound = errors.New("Not analysing that topic")
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub) *Inspector {
+
+	i := &Inspector{
+		Messages: messages,
+		Add:      make(chan Rule),
+		Delete:   make(chan string), //Topic string
+		probes:   make(map[string]*Probe),
+	}
+
+	return i
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Topic == "" || rule.Topic == "deleteAll" {
+		return errNoTopic
+	}
+
+	return nil
+}
+
+func (i *Inspector) Run(closed chan struct{}) {
+
+	defer i.stopAll()
+
+	for {
+		select {
+		case <-closed:
+			i.closing = true
+			return
+		case rule := <-i.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad analysis rule")
+				break
+			}
+
+			i.stop(rule.Topic)
+
+			messageClient := &hub.Client{Hub: i.Messages.Hub,
+				Name:  "inspect",
+				Topic: rule.Topic,
+				Send:  make(chan hub.Message, 64),
+				Stats: hub.NewClientStats()}
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			probe := NewProbe(rule)
+			probe.Messages = messageClient
+			probe.Context = ctx
+			probe.Cancel = cancel
+
+			i.mux.Lock()
+			i.probes[rule.Topic] = probe
+			i.mux.Unlock()
+
+			i.Messages.Register <- messageClient
+
+			go probe.Analyse()
+
+			log.WithField("rule", rule).Info("Started analysis")
+
+		case topic := <-i.Delete:
+
+			if topic == "deleteAll" {
+				i.stopAll()
+			} else {
+				i.stop(topic)
+			}
+		}
+	}
+}
+
+// Report returns the analysis of every topic
+func (i *Inspector) Report() map[string]Analysis {
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	report := make(map[string]Analysis)
+
+	for topic, probe := range i.probes {
+		report[topic] = probe.Analysis()
+	}
+
+	return report
+}
+
+// Analysis returns the analysis of one topic
+func (i *Inspector) Analysis(topic string) (Analysis, error) {
+
+	i.mux.Lock()
+	probe, ok := i.probes[topic]
+	i.mux.Unlock()
+
+	if !ok {
+		return Analysis{}, errNotFound
+	}
+
+	return probe.Analysis(), nil
+}
+
+func (i *Inspector) stop(topic string) {
+
+	i.mux.Lock()
+	probe, ok := i.probes[topic]
+	delete(i.probes, topic)
+	i.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !i.closing {
+		i.Messages.Unregister <- probe.Messages
+	}
+	probe.Cancel()
+	<-probe.Stopped
+
+	log.WithField("topic", topic).Info("Stopped analysis")
+}
+
+func (i *Inspector) stopAll() {
+
+	i.mux.Lock()
+	topics := []string{}
+	for topic := range i.probes {
+		topics = append(topics, topic)
+	}
+	i.mux.Unlock()
+
+	for _, topic := range topics {
+		i.stop(topic)
+	}
+}
+
+func NewProbe(rule Rule) *Probe {
+	return &Probe{Rule: rule,
+		Stopped: make(chan struct{}),
+		pids:    make(map[uint16]*pid),
+		pmtPIDs: make(map[uint16]bool),
+		types:   make(map[uint16]uint8),
+		pcrPID:  make(map[uint16]bool),
+	}
+}
+
+// Analyse messages until cancelled
+func (p *Probe) Analyse() {
+
+	defer close(p.Stopped)
+
+	for {
+		select {
+		case <-p.Context.Done():
+			return
+		case msg, ok := <-p.Messages.Send:
+			if !ok {
+				return
+			}
+			p.Write(msg.Data, time.Now())
+		}
+	}
+}
+
+// Write analyses data that arrived at the given time
+func (p *Probe) Write(data []byte, now time.Time) {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.started.IsZero() {
+		p.started = now
+	}
+	p.last = now
+
+	if len(p.partial) > 0 {
+		data = append(p.partial, data...)
+		p.partial = nil
+	}
+
+	for len(data) > 0 {
+
+		if data[0] != ts.SyncByte {
+			i := bytes.IndexByte(data, ts.SyncByte)
+			if i < 0 {
+				p.skipped += int64(len(data))
+				return
+			}
+			p.skipped += int64(i)
+			data = data[i:]
+		}
+
+		if len(data) < ts.PacketSize {
+			break
+		}
+
+		p.packet(data[:ts.PacketSize], now)
+		data = data[ts.PacketSize:]
+	}
+
+	if len(data) > 0 {
+		p.partial = append([]byte{}, data...)
+	}
+}
+
+func (p *Probe) packet(packet []byte, now time.Time) {
+
+	p.packets++
+
+	number := ts.PID(packet)
+
+	s, ok := p.pids[number]
+	if !ok {
+		s = &pid{}
+		p.pids[number] = s
+	}
+
+	s.packets++
+	s.bytes += ts.PacketSize
+
+	if number == nullPID {
+		return
+	}
+
+	// the counter only goes up on packets with a payload, and may
+	// repeat once; a discontinuity allows anything
+	if ts.HasPayload(packet) {
+		cc := ts.ContinuityCounter(packet)
+		if s.seen && !ts.Discontinuity(packet) && cc != s.cc && cc != (s.cc+1)&0x0f {
+			s.ccErrors++
+		}
+		s.cc = cc
+		s.seen = true
+	}
+
+	if pcr, ok := ts.PCR(packet); ok {
+		if s.pcrs > 0 && !ts.Discontinuity(packet) && pcr > s.pcr {
+			gap := now.Sub(s.pcrAt)
+			jitter := gap - time.Duration((pcr-s.pcr)*1000/(ts.PCRHz/1000000))
+			if jitter < 0 {
+				jitter = -jitter
+			}
+			if gap > s.maxGap {
+				s.maxGap = gap
+			}
+			if jitter > s.maxJitter {
+				s.maxJitter = jitter
+			}
+			s.sumJitter += jitter
+		}
+		s.pcrs++
+		s.pcr = pcr
+		s.pcrAt = now
+	}
+
+	if number == 0 {
+		if programs, ok := ts.PAT(packet); ok {
+			for _, pmt := range programs {
+				p.pmtPIDs[pmt] = true
+			}
+		}
+		return
+	}
+
+	if p.pmtPIDs[number] {
+		if _, program, ok := ts.PMT(packet); ok {
+			p.pcrPID[program.PCRPID] = true
+			for _, es := range program.Streams {
+				p.types[es.PID] = es.Type
+			}
+		}
+	}
+}
+
+// Analysis reports what has been seen so far
+func (p *Probe) Analysis() Analysis {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	a := Analysis{Topic: p.Rule.Topic,
+		Since:   p.started,
+		Packets: p.packets,
+		Skipped: p.skipped,
+		PIDs:    []PIDAnalysis{},
+	}
+
+	elapsed := p.last.Sub(p.started).Seconds()
+
+	rate := func(bytes int64) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(bytes) * 8 / elapsed
+	}
+
+	a.BitrateBps = rate(p.packets * ts.PacketSize)
+
+	for number, s := range p.pids {
+
+		pa := PIDAnalysis{PID: number,
+			Kind:       p.kind(number),
+			StreamType: p.types[number],
+			Packets:    s.packets,
+			BitrateBps: rate(s.bytes),
+			CCErrors:   s.ccErrors,
+		}
+
+		if s.pcrs > 0 {
+			pa.PCR = &PCR{Count: s.pcrs,
+				MaxGapMs:    milliseconds(s.maxGap),
+				MaxJitterMs: milliseconds(s.maxJitter),
+			}
+			if s.pcrs > 1 {
+				pa.PCR.MeanJitterMs = milliseconds(s.sumJitter) / float64(s.pcrs-1)
+			}
+		}
+
+		a.PIDs = append(a.PIDs, pa)
+	}
+
+	sort.Slice(a.PIDs, func(i, j int) bool { return a.PIDs[i].PID < a.PIDs[j].PID })
+
+	return a
+}
+
+func (p *Probe) kind(number uint16) string {
+
+	switch {
+	case number == 0:
+		return "pat"
+	case number == nullPID:
+		return "null"
+	case p.pmtPIDs[number]:
+		return "pmt"
+	}
+
+	streamType, ok := p.types[number]
+
+	if !ok {
+		if p.pcrPID[number] {
+			return "pcr"
+		}
+		return "unknown"
+	}
+
+	return ts.Kind(streamType)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,175 @@
+package inspect
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+// frame makes one 40ms frame of video on PID 0x100, with a PCR, and
+// a frame of audio on PID 0x101, preceded by a PAT and PMT
+func frame(i int, cc uint8) []byte {
+
+	data := ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))
+
+	program := ts.Program{PCRPID: 0x100, Streams: []ts.ES{{Type: 0x1b, PID: 0x100}, {Type: 0x0f, PID: 0x101}}}
+	data = append(data, ts.Encode(ts.Header{PID: 0x1000, PUSI: true}, ts.PMTPayload(1, program, 0))...)
+
+	pcr := uint64(i) * 40 * ts.PCRHz / 1000
+	data = append(data, ts.Encode(ts.Header{PID: 0x100, PUSI: true, CC: cc, HasPCR: true, PCR: pcr}, []byte{byte(i)})...)
+	data = append(data, ts.Encode(ts.Header{PID: 0x101, PUSI: true, CC: cc}, []byte{byte(i)})...)
+
+	return data
+}
+
+func TestProbe(t *testing.T) {
+
+	p := NewProbe(Rule{Topic: "video0"})
+
+	start := time.Now()
+
+	for i := 0; i < 10; i++ {
+		cc := uint8(i)
+		if i == 5 {
+			cc = 9 // skip some packets on both PIDs
+		}
+		if i > 5 {
+			cc = uint8(i) + 4
+		}
+		arrival := start.Add(time.Duration(i) * 40 * time.Millisecond)
+		if i == 3 {
+			arrival = arrival.Add(10 * time.Millisecond) // late
+		}
+		data := frame(i, cc)
+		// split mid-packet
+		p.Write(data[:100], arrival)
+		p.Write(data[100:], arrival)
+	}
+
+	p.Write([]byte("not TS"), start.Add(400*time.Millisecond))
+
+	a := p.Analysis()
+
+	if a.Packets != 40 {
+		t.Errorf("Wrong packets; got/wanted %d/%d", a.Packets, 40)
+	}
+
+	if a.Skipped != 6 {
+		t.Errorf("Wrong skipped bytes; got/wanted %d/%d", a.Skipped, 6)
+	}
+
+	// 40 packets over 400ms
+	if want := float64(40*ts.PacketSize*8) / 0.4; a.BitrateBps < 0.99*want || a.BitrateBps > 1.01*want {
+		t.Errorf("Wrong bitrate; got/wanted %f/%f", a.BitrateBps, want)
+	}
+
+	want := map[uint16]string{0: "pat", 0x1000: "pmt", 0x100: "video", 0x101: "audio"}
+
+	if len(a.PIDs) != len(want) {
+		t.Fatalf("Wrong number of PIDs; got/wanted %d/%d", len(a.PIDs), len(want))
+	}
+
+	for _, pa := range a.PIDs {
+
+		if pa.Kind != want[pa.PID] {
+			t.Errorf("Wrong kind for PID %x; got/wanted %s/%s", pa.PID, pa.Kind, want[pa.PID])
+		}
+
+		if pa.Packets != 10 {
+			t.Errorf("Wrong packets for PID %x; got/wanted %d/%d", pa.PID, pa.Packets, 10)
+		}
+
+		ccErrors := int64(0)
+		if pa.PID == 0x100 || pa.PID == 0x101 {
+			ccErrors = 1
+		}
+
+		if pa.CCErrors != ccErrors {
+			t.Errorf("Wrong CC errors for PID %x; got/wanted %d/%d", pa.PID, pa.CCErrors, ccErrors)
+		}
+
+		if pa.PID != 0x100 {
+			if pa.PCR != nil {
+				t.Errorf("Unexpected PCR on PID %x", pa.PID)
+			}
+			continue
+		}
+
+		if pa.PCR == nil {
+			t.Fatal("No PCR analysis")
+		}
+
+		if pa.PCR.Count != 10 {
+			t.Errorf("Wrong PCR count; got/wanted %d/%d", pa.PCR.Count, 10)
+		}
+
+		if pa.PCR.MaxGapMs != 50 {
+			t.Errorf("Wrong PCR max gap; got/wanted %f/%f", pa.PCR.MaxGapMs, 50.0)
+		}
+
+		if pa.PCR.MaxJitterMs != 10 {
+			t.Errorf("Wrong PCR max jitter; got/wanted %f/%f", pa.PCR.MaxJitterMs, 10.0)
+		}
+
+		// late then early by 10ms, over 9 intervals
+		if want := 20.0 / 9; pa.PCR.MeanJitterMs < want-0.001 || pa.PCR.MeanJitterMs > want+0.001 {
+			t.Errorf("Wrong PCR mean jitter; got/wanted %f/%f", pa.PCR.MeanJitterMs, want)
+		}
+	}
+}
+
+func TestInspector(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	i := New(mh)
+	go i.Run(closed)
+
+	if err := Check(Rule{}); err == nil {
+		t.Error("Accepted rule without a topic")
+	}
+
+	i.Add <- Rule{Topic: "video0"}
+
+	time.Sleep(time.Millisecond)
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: "video0", Send: make(chan hub.Message)}
+
+	for n := 0; n < 3; n++ {
+		mh.Broadcast <- hub.Message{Data: frame(n, uint8(n)), Sender: *c, Sent: time.Now()}
+		time.Sleep(time.Millisecond)
+	}
+
+	a, err := i.Analysis("video0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Packets != 12 {
+		t.Errorf("Wrong packets; got/wanted %d/%d", a.Packets, 12)
+	}
+
+	if _, ok := i.Report()["video0"]; !ok {
+		t.Error("Missing from report")
+	}
+
+	i.Delete <- "video0"
+
+	time.Sleep(time.Millisecond)
+
+	if _, err := i.Analysis("video0"); err != errNotFound {
+		t.Errorf("Wrong error after delete; got/wanted %v/%v", err, errNotFound)
+	}
+}
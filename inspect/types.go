@@ -0,0 +1,85 @@
+package inspect
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+)
+
+type Inspector struct {
+	Messages *agg.Hub
+	Add      chan Rule
+	Delete   chan string //Topic string
+	closing  bool        // messages hub has stopped, so don't unregister
+	mux      sync.Mutex
+	probes   map[string]*Probe //map Topic string to Probe
+}
+
+type Rule struct {
+	Topic string `json:"topic"` // a feed, or a stream/ topic
+}
+
+// Probe analyses the MPEG-TS on one topic
+type Probe struct {
+	Rule     Rule
+	Messages *hub.Client
+	Context  context.Context
+	Cancel   context.CancelFunc
+	Stopped  chan struct{}
+	mux      sync.Mutex
+	started  time.Time
+	last     time.Time
+	packets  int64
+	skipped  int64 // bytes that weren't MPEG-TS
+	pids     map[uint16]*pid
+	pmtPIDs  map[uint16]bool
+	types    map[uint16]uint8 // stream type of each PID listed in a PMT
+	pcrPID   map[uint16]bool
+	partial  []byte // bytes left over from the last message
+}
+
+type pid struct {
+	packets   int64
+	bytes     int64
+	ccErrors  int64
+	cc        uint8
+	seen      bool
+	pcrs      int64
+	pcr       uint64    // last PCR
+	pcrAt     time.Time // when the last PCR arrived
+	maxGap    time.Duration
+	maxJitter time.Duration
+	sumJitter time.Duration
+}
+
+// Analysis is what we report for a topic
+type Analysis struct {
+	Topic      string        `json:"topic"`
+	Since      time.Time     `json:"since"`
+	Packets    int64         `json:"packets"`
+	Skipped    int64         `json:"skippedBytes"`
+	BitrateBps float64       `json:"bitrateBps" doc:"bits per second"`
+	PIDs       []PIDAnalysis `json:"pids"`
+}
+
+type PIDAnalysis struct {
+	PID        uint16  `json:"pid"`
+	Kind       string  `json:"kind"` // pat, pmt, video, audio, data, pcr, null or unknown
+	StreamType uint8   `json:"streamType,omitempty"`
+	Packets    int64   `json:"packets"`
+	BitrateBps float64 `json:"bitrateBps" doc:"bits per second"`
+	CCErrors   int64   `json:"ccErrors"`
+	PCR        *PCR    `json:"pcr,omitempty"`
+}
+
+// PCR timing, where jitter is how far the time between PCRs arriving
+// differs from the time between their values
+type PCR struct {
+	Count        int64   `json:"count"`
+	MaxGapMs     float64 `json:"maxGapMs"`
+	MaxJitterMs  float64 `json:"maxJitterMs"`
+	MeanJitterMs float64 `json:"meanJitterMs"`
+}
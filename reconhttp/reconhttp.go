@@ -0,0 +1,248 @@
+/*
+   reconhttp is a streaming HTTP POST client that automatically reconnects
+   Copyright (C) 2019 Timothy Drysdale <timothy.d.drysdale@gmail.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as
+   published by the Free Software Foundation, either version 3 of the
+   License, or (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package reconhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/chanstats"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/reconws"
+)
+
+// posts (retrying/reconnecting if necessary) to an http server at url,
+// as one long-lived chunked request per connection
+
+type ReconHttp struct {
+	Client      *http.Client
+	ContentType string
+	Events      *events.Bus //optional, nil is ok
+	Id          string      //identifies us in events
+	Out         chan reconws.WsMessage
+	Retry       reconws.RetryConfig
+	Stats       *chanstats.ChanStats
+	mux         sync.Mutex
+	connected   bool
+}
+
+func New() *ReconHttp {
+	r := &ReconHttp{
+		Client:      &http.Client{},
+		ContentType: "video/mp2t",
+		Out:         make(chan reconws.WsMessage),
+		Retry: reconws.RetryConfig{Factor: 2,
+			Min:     1 * time.Second,
+			Max:     10 * time.Second,
+			Timeout: 1 * time.Second,
+			Jitter:  false},
+		Stats: chanstats.New(),
+	}
+	return r
+}
+
+// IsConnected reports whether there is currently a request in progress
+// that the server is reading from
+func (r *ReconHttp) IsConnected() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.connected
+}
+
+func (r *ReconHttp) setConnected(connected bool) {
+	r.mux.Lock()
+	r.connected = connected
+	r.mux.Unlock()
+}
+
+// run this in a separate goroutine, and cancel the context to stop
+func (r *ReconHttp) Reconnect(ctx context.Context, url string) {
+	r.ReconnectAuth(ctx, url, "")
+}
+
+// run this in a separate goroutine, and cancel the context to stop;
+// the token is sent as a bearer token in the Authorization header
+func (r *ReconHttp) ReconnectAuth(ctx context.Context, url string, token string) {
+
+	boff := &backoff.Backoff{
+		Min:    r.Retry.Min,
+		Max:    r.Retry.Max,
+		Factor: r.Retry.Factor,
+		Jitter: r.Retry.Jitter,
+	}
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+
+			postCtx, cancel := context.WithCancel(ctx)
+			err := r.Post(postCtx, url, token)
+			cancel()
+
+			log.WithField("error", err).Debug("Post finished")
+			if err == nil {
+				boff.Reset()
+			} else {
+				select {
+				case <-time.After(boff.Duration()):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// Post to the server once, streaming messages from Out as the body
+// until the context is cancelled or the server ends the request.
+// A nil error means the server ended the request normally.
+func (r *ReconHttp) Post(ctx context.Context, urlStr string, token string) error {
+
+	if urlStr == "" {
+		log.Error("Can't post to an empty Url")
+		return errors.New("Can't post to an empty Url")
+	}
+
+	u, err := url.Parse(urlStr)
+
+	if err != nil {
+		log.Error("Url:", err)
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		log.Error("Url needs to start with http or https")
+		return errors.New("Url needs to start with http or https")
+	}
+
+	pr, pw := io.Pipe()
+
+	req, err := http.NewRequest("POST", urlStr, pr)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = -1 // chunked
+	req.Header.Set("Content-Type", r.ContentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	log.WithField("To", u).Debug("Connecting")
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		resp, err := r.Client.Do(req)
+		done <- result{resp, err}
+	}()
+
+	defer func() {
+		r.setConnected(false)
+		pw.Close()
+	}()
+
+	for {
+		select {
+		case res := <-done:
+			return r.finish(res.resp, res.err, urlStr)
+
+		case msg := <-r.Out:
+
+			if _, err := pw.Write(msg.Data); err != nil {
+				log.WithField("error", err).Error("Writing")
+				pw.CloseWithError(err)
+				res := <-done
+				if res.err == nil {
+					return r.finish(res.resp, res.err, urlStr)
+				}
+				return err
+			}
+
+			if !r.IsConnected() {
+				// the server is reading our body, so we're connected
+				r.Stats.ConnectedAt = time.Now()
+				log.WithField("To", u).Info("Connected")
+				r.setConnected(true)
+				r.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: r.Id, Detail: urlStr})
+				defer r.Events.Publish(events.Event{Kind: events.DestinationDisconnected, Id: r.Id, Detail: urlStr})
+			}
+
+			//update stats
+			r.Stats.Tx.Bytes.Add(float64(len(msg.Data)))
+			r.Stats.Tx.Dt.Add(time.Since(r.Stats.Tx.Last).Seconds())
+			r.Stats.Tx.Last = time.Now()
+
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+			<-done
+			log.Info("Closed")
+			return nil
+		}
+	}
+}
+
+// finish interprets the server's response to a post
+func (r *ReconHttp) finish(resp *http.Response, err error, urlStr string) error {
+
+	if err != nil {
+		log.WithField("error", err).Error("Posting")
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		reason := string(body)
+		if reason == "" {
+			reason = resp.Status
+		}
+		r.Events.Publish(events.Event{Kind: events.DestinationAuthFailed, Id: r.Id, Detail: reason})
+		return errors.New(reason)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("Server replied %s", resp.Status)
+	}
+
+	log.WithFields(log.Fields{"To": urlStr, "status": resp.Status}).Info("Server ended post")
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package reconhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/reconws"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+// sink records each chunk of the request body that it reads
+func sink(received chan []byte, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			// don't wait for the body, which never ends
+			w.Header().Set("Connection", "close")
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+
+		if len(r.TransferEncoding) != 1 || r.TransferEncoding[0] != "chunked" {
+			http.Error(w, "not chunked", http.StatusBadRequest)
+			return
+		}
+
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				received <- append([]byte{}, buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+
+	received := make(chan []byte, 10)
+
+	s := httptest.NewServer(sink(received, ""))
+	defer s.Close()
+
+	r := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.Reconnect(ctx, s.URL+"/ts/video0")
+
+	for _, payload := range [][]byte{[]byte("hello"), []byte("world")} {
+
+		select {
+		case r.Out <- reconws.WsMessage{Data: payload, Type: 2}:
+		case <-time.After(time.Second):
+			t.Fatal("timed out sending")
+		}
+
+		select {
+		case got := <-received:
+			if !bytes.Equal(got, payload) {
+				t.Errorf("Wrong data got/wanted %s/%s", got, payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out receiving")
+		}
+	}
+
+	if !r.IsConnected() {
+		t.Error("Not connected")
+	}
+
+	cancel()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if r.IsConnected() {
+		t.Error("Still connected after cancel")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+
+	requests := make(chan struct{}, 10)
+
+	// end each request after the first chunk
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1024)
+		r.Body.Read(buf)
+		w.Header().Set("Connection", "close")
+		requests <- struct{}{}
+	}))
+	defer s.Close()
+
+	r := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.Reconnect(ctx, s.URL)
+
+	timeout := time.After(time.Second)
+
+	for count := 0; count < 3; {
+		select {
+		case r.Out <- reconws.WsMessage{Data: []byte("hello"), Type: 2}:
+		case <-requests:
+			count++
+		case <-timeout:
+			t.Fatalf("timed out after %d requests", count)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+
+	received := make(chan []byte, 10)
+
+	s := httptest.NewServer(sink(received, "some.test.token"))
+	defer s.Close()
+
+	bus := events.New()
+	sub := bus.Subscribe(10)
+
+	r := New()
+	r.Events = bus
+	r.Id = "d0"
+	r.Retry.Min = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.ReconnectAuth(ctx, s.URL, "not.the.right.token")
+
+	select {
+	case e := <-sub:
+		if e.Kind != events.DestinationAuthFailed || e.Id != "d0" || e.Detail != "bad token\n" {
+			t.Errorf("Wrong event %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("No auth failed event")
+	}
+
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.ReconnectAuth(ctx, s.URL, "some.test.token")
+
+	select {
+	case r.Out <- reconws.WsMessage{Data: []byte("hello"), Type: 2}:
+	case <-time.After(time.Second):
+		t.Fatal("timed out sending")
+	}
+
+	select {
+	case got := <-received:
+		if string(got) != "hello" {
+			t.Errorf("Wrong data %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("timed out receiving")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+   reconnet is a raw tcp or udp client that automatically reconnects
+   Copyright (C) 2019 Timothy Drysdale <timothy.d.drysdale@gmail.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as
+   published by the Free Software Foundation, either version 3 of the
+   License, or (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package reconnet
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/chanstats"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/reconws"
+)
+
+// MPEG-TS over UDP is conventionally sent seven packets at a time
+const DefaultMaxDatagram = 7 * 188
+
+// connects (retrying/reconnecting if necessary) to a tcp or udp server at
+// url, e.g. tcp://localhost:9000 or udp://239.0.0.1:1234?ttl=4
+
+type ReconNet struct {
+	Events      *events.Bus //optional, nil is ok
+	Id          string      //identifies us in events
+	In          chan reconws.WsMessage
+	MaxDatagram int //udp messages are split into datagrams no bigger than this
+	Out         chan reconws.WsMessage
+	Retry       reconws.RetryConfig
+	Stats       *chanstats.ChanStats
+	mux         sync.Mutex
+	connected   bool
+}
+
+func New() *ReconNet {
+	r := &ReconNet{
+		In:          make(chan reconws.WsMessage),
+		MaxDatagram: DefaultMaxDatagram,
+		Out:         make(chan reconws.WsMessage),
+		Retry: reconws.RetryConfig{Factor: 2,
+			Min:     1 * time.Second,
+			Max:     10 * time.Second,
+			Timeout: 1 * time.Second,
+			Jitter:  false},
+		Stats: chanstats.New(),
+	}
+	return r
+}
+
+// IsConnected reports whether there is currently a connection to the server
+func (r *ReconNet) IsConnected() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.connected
+}
+
+func (r *ReconNet) setConnected(connected bool) {
+	r.mux.Lock()
+	r.connected = connected
+	r.mux.Unlock()
+}
+
+// run this in a separate goroutine, and cancel the context to stop
+func (r *ReconNet) Reconnect(ctx context.Context, url string) {
+	r.ReconnectAuth(ctx, url, "")
+}
+
+// run this in a separate goroutine, and cancel the context to stop;
+// raw connections have no handshake, so the token is just
+// written as the first line after connecting
+func (r *ReconNet) ReconnectAuth(ctx context.Context, url string, token string) {
+
+	boff := &backoff.Backoff{
+		Min:    r.Retry.Min,
+		Max:    r.Retry.Max,
+		Factor: r.Retry.Factor,
+		Jitter: r.Retry.Jitter,
+	}
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+
+			dialCtx, cancel := context.WithCancel(ctx)
+			err := r.Dial(dialCtx, url, token)
+			cancel()
+
+			log.WithField("error", err).Debug("Dial finished")
+			if err == nil {
+				boff.Reset()
+			} else {
+				select {
+				case <-time.After(boff.Duration()):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// Dial the server once.
+// If dial fails then return immediately
+// If dial succeeds then handle message traffic until
+// the context is cancelled or the connection fails
+func (r *ReconNet) Dial(ctx context.Context, urlStr string, token string) error {
+
+	if urlStr == "" {
+		log.Error("Can't dial an empty Url")
+		return errors.New("Can't dial an empty Url")
+	}
+
+	u, err := url.Parse(urlStr)
+
+	if err != nil {
+		log.Error("Url:", err)
+		return err
+	}
+
+	if u.Scheme != "tcp" && u.Scheme != "udp" {
+		log.Error("Url needs to start with tcp or udp")
+		return errors.New("Url needs to start with tcp or udp")
+	}
+
+	log.WithField("To", u).Debug("Connecting")
+
+	var d net.Dialer
+
+	c, err := d.DialContext(ctx, u.Scheme, u.Host)
+
+	if err != nil {
+		log.WithField("error", err).Error("Dialing")
+		return err
+	}
+
+	defer c.Close()
+
+	if ttl := u.Query().Get("ttl"); ttl != "" {
+		hops, err := strconv.Atoi(ttl)
+		if err == nil {
+			err = setMulticastTTL(c, hops)
+		}
+		if err != nil {
+			log.WithField("error", err).Error("Setting multicast TTL")
+			return err
+		}
+	}
+
+	if token != "" {
+		if _, err := c.Write([]byte(token + "\n")); err != nil {
+			log.WithField("error", err).Error("Writing token")
+			return err
+		}
+	}
+
+	r.Stats.ConnectedAt = time.Now()
+
+	log.WithField("To", u).Info("Connected")
+
+	r.setConnected(true)
+	defer r.setConnected(false)
+
+	r.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: r.Id, Detail: urlStr})
+	defer r.Events.Publish(events.Event{Kind: events.DestinationDisconnected, Id: r.Id, Detail: urlStr})
+
+	readClosed := make(chan struct{})
+
+	if u.Scheme == "tcp" {
+		// forward replies, e.g. from a bidirectional data feed
+		go r.read(ctx, c, readClosed)
+	}
+
+	for {
+		select {
+		case <-readClosed:
+			return nil // nil error resets the backoff
+		case msg := <-r.Out:
+
+			if err := r.write(c, u.Scheme, msg.Data); err != nil {
+				log.WithField("error", err).Error("Writing")
+				return err
+			}
+			//update stats
+			r.Stats.Tx.Bytes.Add(float64(len(msg.Data)))
+			r.Stats.Tx.Dt.Add(time.Since(r.Stats.Tx.Last).Seconds())
+			r.Stats.Tx.Last = time.Now()
+
+		case <-ctx.Done():
+			log.Info("Closed")
+			return nil
+		}
+	}
+}
+
+// write sends data, splitting it into datagrams for udp
+func (r *ReconNet) write(c net.Conn, scheme string, data []byte) error {
+
+	if scheme == "tcp" || r.MaxDatagram <= 0 {
+		_, err := c.Write(data)
+		return err
+	}
+
+	for len(data) > 0 {
+		n := r.MaxDatagram
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := c.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// read forwards whatever the server sends, until the connection fails
+func (r *ReconNet) read(ctx context.Context, c net.Conn, readClosed chan struct{}) {
+
+	defer close(readClosed)
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := c.Read(buf)
+
+		if n > 0 {
+			data := append([]byte{}, buf[:n]...)
+			select {
+			case r.In <- reconws.WsMessage{Data: data, Type: websocket.BinaryMessage}:
+			case <-ctx.Done():
+				return
+			}
+			//update stats
+			r.Stats.Rx.Bytes.Add(float64(n))
+			r.Stats.Rx.Dt.Add(time.Since(r.Stats.Rx.Last).Seconds())
+			r.Stats.Rx.Last = time.Now()
+		}
+
+		if err != nil {
+			log.WithField("info", err).Info("Reading")
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package reconnet
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/reconws"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+func TestTcp(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan []byte, 10)
+
+	// shout back whatever we receive
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- append([]byte{}, buf[:n]...)
+			c.Write(bytes.ToUpper(buf[:n]))
+		}
+	}()
+
+	r := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.ReconnectAuth(ctx, "tcp://"+l.Addr().String(), "some.test.token")
+
+	select {
+	case got := <-received:
+		if string(got) != "some.test.token\n" {
+			t.Errorf("Wrong token %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for token")
+	}
+
+	// the token was shouted back too
+	<-r.In
+
+	r.Out <- reconws.WsMessage{Data: []byte("hello"), Type: 2}
+
+	select {
+	case got := <-received:
+		if string(got) != "hello" {
+			t.Errorf("Wrong data %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for data")
+	}
+
+	select {
+	case msg := <-r.In:
+		if string(msg.Data) != "HELLO" {
+			t.Errorf("Wrong reply %s", msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reply")
+	}
+
+	if !r.IsConnected() {
+		t.Error("Not connected")
+	}
+}
+
+func TestTcpReconnect(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan struct{}, 10)
+
+	// hang up straight away
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	r := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.Reconnect(ctx, "tcp://"+l.Addr().String())
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-accepted:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d connections", i)
+		}
+	}
+}
+
+func TestUdp(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	r := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.Reconnect(ctx, "udp://"+pc.LocalAddr().String())
+
+	payload := bytes.Repeat([]byte{0x47}, 3*DefaultMaxDatagram+10)
+
+	select {
+	case r.Out <- reconws.WsMessage{Data: payload, Type: 2}:
+	case <-time.After(time.Second):
+		t.Fatal("timed out sending")
+	}
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 65536)
+	sizes := []int{}
+
+	for i := 0; i < 4; i++ {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, n)
+	}
+
+	if sizes[0] != DefaultMaxDatagram || sizes[3] != 10 {
+		t.Errorf("Wrong datagram sizes %v", sizes)
+	}
+}
+
+func TestBadScheme(t *testing.T) {
+
+	r := New()
+
+	if err := r.Dial(context.Background(), "ws://localhost:8888", ""); err == nil {
+		t.Error("Failed to reject websocket url")
+	}
+}
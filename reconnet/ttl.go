@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package reconnet
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setMulticastTTL sets how many hops multicast datagrams can travel
+func setMulticastTTL(c net.Conn, hops int) error {
+
+	udp, ok := c.(*net.UDPConn)
+	if !ok {
+		return errors.New("ttl only applies to udp")
+	}
+
+	raw, err := udp.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, hops)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,12 @@
+package reconnet
+
+import (
+	"errors"
+	"net"
+)
+
+// setMulticastTTL is not supported on windows, where the default of one
+// hop keeps multicast on the local network
+func setMulticastTTL(c net.Conn, hops int) error {
+	return errors.New("ttl is not supported on windows")
+}
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"math/rand"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -31,6 +32,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	crossbar "github.com/timdrysdale/crossbar/cmd"
 	"github.com/timdrysdale/vw/chanstats"
+	"github.com/timdrysdale/vw/events"
 )
 
 type WsMessage struct {
@@ -41,12 +43,16 @@ type WsMessage struct {
 // connects (retrying/reconnecting if necessary) to websocket server at url
 
 type ReconWs struct {
+	Events          *events.Bus //optional, nil is ok
 	ForwardIncoming bool
+	Id              string //identifies us in events
 	In              chan WsMessage
 	Out             chan WsMessage
 	Retry           RetryConfig
 	Stats           *chanstats.ChanStats
 	Url             string
+	mux             sync.Mutex
+	connected       bool
 }
 
 type RetryConfig struct {
@@ -72,6 +78,19 @@ func New() *ReconWs {
 	return r
 }
 
+// IsConnected reports whether there is currently a connection to the server
+func (r *ReconWs) IsConnected() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.connected
+}
+
+func (r *ReconWs) setConnected(connected bool) {
+	r.mux.Lock()
+	r.connected = connected
+	r.mux.Unlock()
+}
+
 // run this in a separate goroutine so that the connection can be
 // ended from where it was initialised, by close((* ReconWs).Stop)
 func (r *ReconWs) ReconnectAuth(ctx context.Context, url string, token string) {
@@ -103,9 +122,12 @@ func (r *ReconWs) ReconnectAuth(ctx context.Context, url string, token string) {
 			if err == nil {
 				boff.Reset()
 			} else {
-				time.Sleep(boff.Duration())
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(boff.Duration()):
+				}
 			}
-			//TODO immediate return if cancelled....
 		}
 	}
 }
@@ -141,9 +163,12 @@ func (r *ReconWs) Reconnect(ctx context.Context, url string) {
 			if err == nil {
 				boff.Reset()
 			} else {
-				time.Sleep(boff.Duration())
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(boff.Duration()):
+				}
 			}
-			//TODO immediate return if cancelled....
 		}
 	}
 }
@@ -217,6 +242,8 @@ func (r *ReconWs) DialAuth(ctx context.Context, urlStr string, token string) err
 				reason = string(data)
 			}
 
+			r.Events.Publish(events.Event{Kind: events.DestinationAuthFailed, Id: r.Id, Detail: reason})
+
 			return errors.New(reason)
 		}
 	} else {
@@ -228,6 +255,12 @@ func (r *ReconWs) DialAuth(ctx context.Context, urlStr string, token string) err
 
 	log.WithField("To", u).Info("Connected")
 
+	r.setConnected(true)
+	defer r.setConnected(false)
+
+	r.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: r.Id, Detail: urlStr})
+	defer r.Events.Publish(events.Event{Kind: events.DestinationDisconnected, Id: r.Id, Detail: urlStr})
+
 	// handle our reading tasks
 
 	readClosed := make(chan struct{})
@@ -281,8 +314,8 @@ LOOPWRITING:
 			r.Stats.Tx.Last = time.Now()
 
 		case <-ctx.Done(): // context has finished, either timeout or cancel
-			//TODO - do we need to do this?
 			// Cleanly close the connection by sending a close message
+			// so the other end knows we are shutting down
 			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 			if err != nil {
 				log.WithField("error", err).Error("Closing")
@@ -348,6 +381,12 @@ func (r *ReconWs) Dial(ctx context.Context, urlStr string) error {
 
 	log.WithField("To", u).Info("Connected")
 
+	r.setConnected(true)
+	defer r.setConnected(false)
+
+	r.Events.Publish(events.Event{Kind: events.DestinationConnected, Id: r.Id, Detail: urlStr})
+	defer r.Events.Publish(events.Event{Kind: events.DestinationDisconnected, Id: r.Id, Detail: urlStr})
+
 	// handle our reading tasks
 
 	readClosed := make(chan struct{})
@@ -401,8 +440,8 @@ LOOPWRITING:
 			r.Stats.Tx.Last = time.Now()
 
 		case <-ctx.Done(): // context has finished, either timeout or cancel
-			//TODO - do we need to do this?
 			// Cleanly close the connection by sending a close message
+			// so the other end knows we are shutting down
 			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 			if err != nil {
 				log.WithField("error", err).Error("Closing")
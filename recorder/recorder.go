@@ -0,0 +1,436 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+const (
+	DefaultTemplate = "{feed}-{time}.ts"
+	timeFormat      = "20060102T150405.000Z"
+
+	// roll anyway if there is no keyframe by this many times the limits
+	maxOver = 3
+)
+
+var errNoId = errors.New("Recording needs an id")
+var errNoTopic = errors.New("Recording needs a topic")
+var errNoTime = errors.New("Template must contain {time}")
+var errOutsideDir = errors.New("Template must stay inside the recording directory")
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub, dir string) *Recorder {
+
+	r := &Recorder{
+		Messages:   messages,
+		Dir:        dir,
+		Add:        make(chan Rule),
+		Delete:     make(chan string), //Id string
+		recordings: make(map[string]*Recording),
+	}
+
+	return r
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Id == "" || rule.Id == "deleteAll" {
+		return errNoId
+	}
+
+	if rule.Topic == "" {
+		return errNoTopic
+	}
+
+	template := rule.Template
+	if template == "" {
+		template = DefaultTemplate
+	}
+
+	if !strings.Contains(template, "{time}") {
+		return errNoTime
+	}
+
+	if filepath.IsAbs(template) || strings.Contains(template, "..") {
+		return errOutsideDir
+	}
+
+	return nil
+}
+
+func (r *Recorder) Run(closed chan struct{}) {
+
+	defer r.stopAll()
+
+	for {
+		select {
+		case <-closed:
+			r.closing = true
+			return
+		case rule := <-r.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad recording rule")
+				break
+			}
+
+			r.stop(rule.Id)
+
+			if rule.Template == "" {
+				rule.Template = DefaultTemplate
+			}
+
+			messageClient := &hub.Client{Hub: r.Messages.Hub,
+				Name:  "recorder-" + rule.Id,
+				Topic: rule.Topic,
+				Send:  make(chan hub.Message, 64),
+				Stats: hub.NewClientStats()}
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			recording := &Recording{Rule: rule,
+				Dir:      r.Dir,
+				Messages: messageClient,
+				Context:  ctx,
+				Cancel:   cancel,
+				Stopped:  make(chan struct{}),
+				pmt:      make(map[uint16][]byte),
+				pmtPIDs:  make(map[uint16]bool),
+				started:  time.Now()}
+
+			r.mux.Lock()
+			r.recordings[rule.Id] = recording
+			r.mux.Unlock()
+
+			r.Messages.Register <- messageClient
+
+			go recording.Record()
+
+			log.WithField("rule", rule).Info("Started recording")
+
+		case id := <-r.Delete:
+
+			if id == "deleteAll" {
+				r.stopAll()
+			} else {
+				r.stop(id)
+			}
+		}
+	}
+}
+
+// Report returns the status of every recording
+func (r *Recorder) Report() map[string]Status {
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for id, recording := range r.recordings {
+		report[id] = recording.Status()
+	}
+
+	return report
+}
+
+func (r *Recorder) stop(id string) {
+
+	r.mux.Lock()
+	recording, ok := r.recordings[id]
+	delete(r.recordings, id)
+	r.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !r.closing {
+		r.Messages.Unregister <- recording.Messages
+	}
+	recording.Cancel()
+	<-recording.Stopped
+
+	log.WithField("id", id).Info("Stopped recording")
+}
+
+func (r *Recorder) stopAll() {
+
+	r.mux.Lock()
+	ids := []string{}
+	for id := range r.recordings {
+		ids = append(ids, id)
+	}
+	r.mux.Unlock()
+
+	for _, id := range ids {
+		r.stop(id)
+	}
+}
+
+func (rec *Recording) Status() Status {
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	file := ""
+	if rec.file != nil {
+		file = rec.file.Name()
+	}
+
+	return Status{Rule: rec.Rule,
+		File:    file,
+		Bytes:   rec.total,
+		Files:   rec.files,
+		Started: rec.started.String()}
+}
+
+// Record writes messages to file until stopped
+func (rec *Recording) Record() {
+
+	defer func() {
+		rec.closeFile()
+		close(rec.Stopped)
+	}()
+
+	for {
+		select {
+		case <-rec.Context.Done():
+			return
+		case msg, ok := <-rec.Messages.Send:
+			if !ok {
+				return
+			}
+			if err := rec.write(msg.Data); err != nil {
+				log.WithFields(log.Fields{"id": rec.Rule.Id, "error": err}).Error("Writing recording")
+			}
+		}
+	}
+}
+
+// write splits data into packets, carrying over any part packet, so
+// that files can be cut between packets
+func (rec *Recording) write(data []byte) error {
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	buf := append(rec.partial, data...)
+
+	var err error
+
+	for len(buf) >= ts.PacketSize {
+
+		if buf[0] != ts.SyncByte {
+			// lost sync, so skip to the next sync byte
+			i := bytes.IndexByte(buf, ts.SyncByte)
+			if i < 0 {
+				buf = buf[:0]
+				break
+			}
+			buf = buf[i:]
+			continue
+		}
+
+		if err = rec.packet(buf[:ts.PacketSize]); err != nil {
+			break
+		}
+
+		buf = buf[ts.PacketSize:]
+	}
+
+	rec.partial = append([]byte{}, buf...)
+
+	return err
+}
+
+// packet writes one packet, first rolling to the next file if the
+// current one is due and this is a keyframe, so that every file can
+// be played by itself; call with lock held
+func (rec *Recording) packet(p []byte) error {
+
+	// keep the tables so every file can start with them
+	programs, isPAT := ts.PAT(p)
+
+	if isPAT {
+		rec.pat = append([]byte{}, p...)
+		rec.pmtPIDs = make(map[uint16]bool)
+		for _, pid := range programs {
+			rec.pmtPIDs[pid] = true
+		}
+	} else if rec.pmtPIDs[ts.PID(p)] && ts.PayloadUnitStart(p) {
+		rec.pmt[ts.PID(p)] = append([]byte{}, p...)
+	}
+
+	if rec.file == nil || (rec.due(1) && ts.RandomAccess(p)) || rec.due(maxOver) {
+		if err := rec.roll(); err != nil {
+			return err
+		}
+		if !isPAT {
+			if err := rec.tables(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return rec.put(p)
+}
+
+// tables writes the last PAT and PMTs, if we have them; call with lock held
+func (rec *Recording) tables() error {
+
+	if rec.pat == nil {
+		return nil
+	}
+
+	if err := rec.put(rec.pat); err != nil {
+		return err
+	}
+
+	for _, pmt := range rec.pmt {
+		if err := rec.put(pmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// put writes to the current file; call with lock held
+func (rec *Recording) put(data []byte) error {
+
+	n, err := rec.file.Write(data)
+
+	rec.written += int64(n)
+	rec.total += int64(n)
+
+	return err
+}
+
+// due reports whether the current file is over its limits, times the
+// given factor; call with lock held
+func (rec *Recording) due(factor int) bool {
+
+	if rec.Rule.MaxBytes > 0 && rec.written >= int64(factor)*rec.Rule.MaxBytes {
+		return true
+	}
+
+	maxDuration := time.Duration(factor*rec.Rule.MaxDurationMs) * time.Millisecond
+
+	return maxDuration > 0 && time.Since(rec.opened) >= maxDuration
+}
+
+// roll closes any current file, opens the next, then prunes; call with lock held
+func (rec *Recording) roll() error {
+
+	if rec.file != nil {
+		rec.file.Close()
+		rec.file = nil
+	}
+
+	rec.opened = time.Now()
+	name := filepath.Join(rec.Dir, rec.name(rec.opened.UTC().Format(timeFormat)))
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	rec.file = file
+	rec.written = 0
+	rec.files++
+
+	retain := rec.Rule.RetainBytes > 0 || rec.Rule.RetainAgeMs > 0
+
+	if retain && (len(rec.names) == 0 || rec.names[len(rec.names)-1] != name) {
+		rec.names = append(rec.names, name)
+	}
+
+	rec.prune()
+
+	return nil
+}
+
+func (rec *Recording) closeFile() {
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	if rec.file != nil {
+		if err := rec.file.Close(); err != nil {
+			log.WithFields(log.Fields{"id": rec.Rule.Id, "error": err}).Error("Closing recording")
+		}
+		rec.file = nil
+		rec.prune()
+	}
+}
+
+// prune removes the oldest files beyond the retention limits, but
+// never the current file, so it can go over by up to one file while
+// recording. Only files this recording opened are pruned, because
+// other recordings' names can match ours (feed a vs feed a-b), and
+// even be the same (two recordings of one topic); call with lock held
+func (rec *Recording) prune() {
+
+	if rec.Rule.RetainBytes <= 0 && rec.Rule.RetainAgeMs <= 0 {
+		return
+	}
+
+	maxAge := time.Duration(rec.Rule.RetainAgeMs) * time.Millisecond
+	var total int64
+
+	kept := []string{}
+
+	// newest first, so we keep those
+	for i := len(rec.names) - 1; i >= 0; i-- {
+
+		name := rec.names[i]
+
+		info, err := os.Stat(name)
+		if err != nil {
+			continue // removed by someone else, so forget it
+		}
+
+		total += info.Size()
+
+		tooBig := rec.Rule.RetainBytes > 0 && total > rec.Rule.RetainBytes
+		tooOld := maxAge > 0 && time.Since(info.ModTime()) > maxAge
+		current := rec.file != nil && name == rec.file.Name()
+
+		if (tooBig || tooOld) && !current {
+			err := os.Remove(name)
+			if err == nil {
+				log.WithField("file", name).Debug("Pruned recording")
+				continue
+			}
+			log.WithFields(log.Fields{"file": name, "error": err}).Error("Pruning recording")
+		}
+
+		kept = append([]string{name}, kept...)
+	}
+
+	rec.names = kept
+}
+
+// name fills in the template with our topic and the given time
+func (rec *Recording) name(t string) string {
+	feed := strings.Replace(strings.Trim(rec.Rule.Topic, "/"), "/", "_", -1)
+	name := strings.Replace(rec.Rule.Template, "{feed}", feed, -1)
+	return strings.Replace(name, "{time}", t, -1)
+}
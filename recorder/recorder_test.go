@@ -0,0 +1,242 @@
+package recorder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+func startRecorder(t *testing.T, closed chan struct{}) (*Recorder, *agg.Hub, string) {
+
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	r := New(mh, dir)
+	go r.Run(closed)
+
+	return r, mh, dir
+}
+
+func send(mh *agg.Hub, topic string, data []byte) {
+	c := &hub.Client{Hub: mh.Hub, Name: "ffmpeg", Topic: topic, Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+	mh.Broadcast <- hub.Message{Sender: *c, Data: data, Type: 2, Sent: time.Now()}
+	time.Sleep(2 * time.Millisecond)
+}
+
+// keyframe is a packet that a file can start at
+func keyframe() []byte {
+	return ts.Encode(ts.Header{PID: 0x100, PUSI: true, RandomAccess: true}, nil)
+}
+
+func TestCheck(t *testing.T) {
+
+	good := Rule{Id: "r0", Topic: "stream/large"}
+
+	if err := Check(good); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bad := map[error]Rule{
+		errNoId:       {Topic: "video0"},
+		errNoTopic:    {Id: "r0"},
+		errNoTime:     {Id: "r0", Topic: "video0", Template: "{feed}.ts"},
+		errOutsideDir: {Id: "r0", Topic: "video0", Template: "../{feed}-{time}.ts"},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error got/wanted %v/%v", err, expected)
+		}
+	}
+}
+
+func TestRecordAndRoll(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, mh, dir := startRecorder(t, closed)
+	defer os.RemoveAll(dir)
+
+	r.Add <- Rule{Id: "r0", Topic: "stream/large", Template: "{feed}/{time}.ts", MaxBytes: 376}
+
+	mh.Add <- agg.Rule{Stream: "stream/large", Feeds: []string{"video0"}}
+
+	time.Sleep(2 * time.Millisecond)
+
+	packet := keyframe()
+
+	for i := 0; i < 5; i++ {
+		send(mh, "video0", packet)
+	}
+
+	status := r.Report()["r0"]
+
+	if status.Bytes != 5*188 || status.Files != 3 {
+		t.Errorf("Wrong status %v", status)
+	}
+
+	r.Delete <- "r0"
+
+	time.Sleep(2 * time.Millisecond)
+
+	if _, ok := r.Report()["r0"]; ok {
+		t.Error("Recording not stopped")
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "stream_large", "*.ts"))
+
+	if len(names) != 3 {
+		t.Fatalf("Wrong number of files got/wanted %d/%d", len(names), 3)
+	}
+
+	var total int64
+	for _, name := range names {
+		info, _ := os.Stat(name)
+		total += info.Size()
+	}
+
+	if total != 5*188 {
+		t.Errorf("Wrong total size got/wanted %d/%d", total, 5*188)
+	}
+}
+
+func TestRetainBytes(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, mh, dir := startRecorder(t, closed)
+	defer os.RemoveAll(dir)
+
+	r.Add <- Rule{Id: "r0", Topic: "video0", MaxBytes: 188, RetainBytes: 3 * 188}
+
+	packet := keyframe()
+
+	for i := 0; i < 6; i++ {
+		send(mh, "video0", packet)
+	}
+
+	r.Delete <- "r0"
+
+	time.Sleep(2 * time.Millisecond)
+
+	names, _ := filepath.Glob(filepath.Join(dir, "video0-*.ts"))
+
+	if len(names) != 3 {
+		t.Errorf("Wrong number of files retained got/wanted %d/%d", len(names), 3)
+	}
+}
+
+func TestRetainOnlyOwnFiles(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, mh, dir := startRecorder(t, closed)
+	defer os.RemoveAll(dir)
+
+	// the other recordings' names all match a-*.ts, like r0's
+	r.Add <- Rule{Id: "r0", Topic: "a", MaxBytes: 188, RetainBytes: 188}
+	r.Add <- Rule{Id: "r1", Topic: "a-b", MaxBytes: 188}
+	r.Add <- Rule{Id: "r2", Topic: "a", Template: "{feed}-{time}-copy.ts"}
+
+	time.Sleep(2 * time.Millisecond)
+
+	packet := keyframe()
+
+	for i := 0; i < 3; i++ {
+		send(mh, "a-b", packet)
+	}
+
+	for i := 0; i < 3; i++ {
+		send(mh, "a", packet)
+	}
+
+	r.Delete <- "deleteAll"
+
+	time.Sleep(2 * time.Millisecond)
+
+	if names, _ := filepath.Glob(filepath.Join(dir, "a-b-*.ts")); len(names) != 3 {
+		t.Errorf("Pruned another feed's files; %d of %d left", len(names), 3)
+	}
+
+	if names, _ := filepath.Glob(filepath.Join(dir, "a-*-copy.ts")); len(names) != 1 {
+		t.Errorf("Pruned another recording's file; %d of %d left", len(names), 1)
+	}
+
+	if names, _ := filepath.Glob(filepath.Join(dir, "a-[0-9]*[0-9]Z.ts")); len(names) != 1 {
+		t.Errorf("Wrong number of files retained got/wanted %d/%d", len(names), 1)
+	}
+}
+
+func TestRollAtKeyframe(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, mh, dir := startRecorder(t, closed)
+	defer os.RemoveAll(dir)
+
+	r.Add <- Rule{Id: "r0", Topic: "video0", MaxBytes: 4 * 188}
+
+	time.Sleep(2 * time.Millisecond)
+
+	pat := ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))
+	pmt := ts.Encode(ts.Header{PID: 0x1000, PUSI: true}, []byte{0, 0x02})
+	frame := ts.Encode(ts.Header{PID: 0x100}, nil)
+
+	// the first file is due after the first frame, but must not be cut
+	// until the next keyframe, which arrives split across two messages
+	send(mh, "video0", append(append(pat, pmt...), keyframe()...))
+	send(mh, "video0", frame)
+	send(mh, "video0", frame)
+	send(mh, "video0", append(frame, keyframe()[:100]...))
+	send(mh, "video0", append(keyframe()[100:], frame...))
+
+	r.Delete <- "r0"
+
+	time.Sleep(2 * time.Millisecond)
+
+	names, _ := filepath.Glob(filepath.Join(dir, "video0-*.ts"))
+
+	if len(names) != 2 {
+		t.Fatalf("Wrong number of files got/wanted %d/%d", len(names), 2)
+	}
+
+	first, _ := ioutil.ReadFile(names[0])
+	second, _ := ioutil.ReadFile(names[1])
+
+	if len(first) != 6*188 {
+		t.Errorf("Wrong size of first file got/wanted %d/%d", len(first), 6*188)
+	}
+
+	if len(second) != 4*188 {
+		t.Fatalf("Wrong size of second file got/wanted %d/%d", len(second), 4*188)
+	}
+
+	if !bytes.Equal(second[:188], pat) || !bytes.Equal(second[188:2*188], pmt) {
+		t.Error("Second file does not start with the tables")
+	}
+
+	if !ts.RandomAccess(second[2*188 : 3*188]) {
+		t.Error("Second file does not start at a keyframe")
+	}
+}
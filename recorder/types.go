@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+)
+
+type Recorder struct {
+	Messages   *agg.Hub
+	Dir        string // all recordings are kept under here
+	Add        chan Rule
+	Delete     chan string //Id string
+	closing    bool        // messages hub has stopped, so don't unregister
+	mux        sync.Mutex
+	recordings map[string]*Recording //map Id string to Recording
+}
+
+type Rule struct {
+	Id            string `json:"id"`
+	Topic         string `json:"topic"`
+	Template      string `json:"template,omitempty"`      // default "{feed}-{time}.ts"
+	MaxDurationMs int    `json:"maxDurationMs,omitempty"` // roll after this long, 0 for never
+	MaxBytes      int64  `json:"maxBytes,omitempty"`      // roll after this many bytes, 0 for never
+	RetainBytes   int64  `json:"retainBytes,omitempty"`   // prune oldest files beyond this total, 0 for no limit
+	RetainAgeMs   int64  `json:"retainAgeMs,omitempty"`   // prune files older than this, 0 for no limit
+}
+
+type Recording struct {
+	Rule     Rule
+	Dir      string
+	Messages *hub.Client
+	Context  context.Context
+	Cancel   context.CancelFunc
+	Stopped  chan struct{} //closed when the current file is finalised
+	mux      sync.Mutex
+	file     *os.File
+	opened   time.Time
+	written  int64    // bytes in the current file
+	total    int64    // bytes since recording started
+	files    int      // files started since recording started
+	names    []string // files opened by this recording, oldest first, the only ones it prunes
+	partial  []byte   // part packet carried over to the next message
+	pat      []byte
+	pmt      map[uint16][]byte
+	pmtPIDs  map[uint16]bool
+	started  time.Time
+}
+
+// Status that we report externally
+type Status struct {
+	Rule    Rule   `json:"rule"`
+	File    string `json:"file"`
+	Bytes   int64  `json:"bytes"`
+	Files   int    `json:"files"`
+	Started string `json:"started"`
+}
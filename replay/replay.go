@@ -0,0 +1,491 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+const (
+	// send at least this often, even if the file has sparse timestamps
+	maxChunk = 100 * ts.PacketSize
+
+	// clock jumps bigger than this are treated as discontinuities
+	maxJump = 5 * time.Second
+
+	// small backwards steps are expected with PTS, due to B-frames
+	maxStepBack = time.Second
+)
+
+var errNoFeed = errors.New("Replay needs a feed")
+var errNoFile = errors.New("Replay needs a file")
+var errOutsideDir = errors.New("File must be inside the recording directory")
+var errNotFound = errors.New("Replay not found")
+var errNoSuchFile = errors.New("File not found in the recording directory")
+var errNotTS = errors.New("File is not MPEG-TS")
+
+// results of one pass through the file
+const (
+	passEOF = iota
+	passSeek
+	passStop
+)
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub, dir string) *Replayer {
+
+	r := &Replayer{
+		Messages:    messages,
+		Dir:         dir,
+		FallbackBps: 1000000,
+		Add:         make(chan Rule),
+		Delete:      make(chan string), //Feed string
+		players:     make(map[string]*Player),
+	}
+
+	return r
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Feed == "" || rule.Feed == "deleteAll" {
+		return errNoFeed
+	}
+
+	if rule.File == "" {
+		return errNoFile
+	}
+
+	if filepath.IsAbs(rule.File) || strings.Contains(rule.File, "..") {
+		return errOutsideDir
+	}
+
+	return nil
+}
+
+// CheckFile reports whether a file under dir can be replayed, i.e. it
+// exists, and there is a packet in the first chunk that is followed by
+// another, or ends the file, so that a typo'd name or a file that is not
+// MPEG-TS can be reported to the user, rather than replaying nothing
+func CheckFile(dir, file string) error {
+
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return errNoSuchFile
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return errNoSuchFile
+	}
+
+	head := make([]byte, maxChunk)
+	n, _ := io.ReadFull(f, head)
+
+	for i := 0; i+ts.PacketSize <= n; i++ {
+		if head[i] == ts.SyncByte && (i+ts.PacketSize == n || head[i+ts.PacketSize] == ts.SyncByte) {
+			return nil
+		}
+	}
+
+	return errNotTS
+}
+
+func (r *Replayer) Run(closed chan struct{}) {
+
+	defer r.stopAll()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case rule := <-r.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad replay rule")
+				break
+			}
+
+			r.stop(rule.Feed)
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			player := &Player{Rule: rule,
+				Path: filepath.Join(r.Dir, rule.File),
+				Messages: &hub.Client{Hub: r.Messages.Hub,
+					Name:  "replay",
+					Topic: rule.Feed,
+					Send:  make(chan hub.Message),
+					Stats: hub.NewClientStats()},
+				Broadcast:   r.Messages.Broadcast,
+				Events:      r.Events,
+				FallbackBps: r.FallbackBps,
+				Context:     ctx,
+				Cancel:      cancel,
+				Stopped:     make(chan struct{}),
+				seek:        make(chan int64, 1),
+				loop:        rule.Loop,
+				state:       StatePlaying}
+
+			r.mux.Lock()
+			r.players[rule.Feed] = player
+			r.mux.Unlock()
+
+			go player.Play()
+
+		case feed := <-r.Delete:
+
+			if feed == "deleteAll" {
+				r.stopAll()
+			} else {
+				r.stop(feed)
+			}
+		}
+	}
+}
+
+// Report returns the status of every player
+func (r *Replayer) Report() map[string]Status {
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for feed, player := range r.players {
+		report[feed] = player.Status()
+	}
+
+	return report
+}
+
+// Seek moves the player on a feed to a position in its file
+func (r *Replayer) Seek(feed string, positionMs int64) error {
+
+	player, err := r.player(feed)
+
+	if err != nil {
+		return err
+	}
+
+	// replace any seek that has not been actioned yet
+	select {
+	case <-player.seek:
+	default:
+	}
+
+	player.seek <- positionMs
+
+	return nil
+}
+
+// SetLoop sets whether the player on a feed loops at the end of its file
+func (r *Replayer) SetLoop(feed string, loop bool) error {
+
+	player, err := r.player(feed)
+
+	if err != nil {
+		return err
+	}
+
+	player.mux.Lock()
+	player.loop = loop
+	player.mux.Unlock()
+
+	return nil
+}
+
+func (r *Replayer) player(feed string) (*Player, error) {
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	player, ok := r.players[feed]
+
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return player, nil
+}
+
+func (r *Replayer) stop(feed string) {
+
+	r.mux.Lock()
+	player, ok := r.players[feed]
+	delete(r.players, feed)
+	r.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	player.Cancel()
+	<-player.Stopped
+}
+
+func (r *Replayer) stopAll() {
+
+	r.mux.Lock()
+	feeds := []string{}
+	for feed := range r.players {
+		feeds = append(feeds, feed)
+	}
+	r.mux.Unlock()
+
+	for _, feed := range feeds {
+		r.stop(feed)
+	}
+}
+
+func (p *Player) Status() Status {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return Status{Rule: p.Rule,
+		Loop:       p.loop,
+		PositionMs: p.positionMs,
+		State:      p.state,
+		Error:      p.failure}
+}
+
+// Play the file until stopped, or it ends without looping
+func (p *Player) Play() {
+
+	defer close(p.Stopped)
+
+	log.WithField("rule", p.Rule).Info("Started replay")
+	p.Events.Publish(events.Event{Kind: events.FeedStarted, Topic: p.Rule.Feed, Id: "replay", Detail: p.Rule.File})
+
+	seekMs := p.Rule.StartMs
+
+	for {
+		result, next := p.pass(seekMs)
+
+		switch result {
+		case passSeek:
+			seekMs = next
+			continue
+		case passEOF:
+			p.mux.Lock()
+			loop := p.loop
+			p.mux.Unlock()
+			if loop {
+				seekMs = 0
+				continue
+			}
+			p.mux.Lock()
+			p.state = StateFinished
+			p.mux.Unlock()
+		}
+
+		break
+	}
+
+	log.WithField("rule", p.Rule).Info("Stopped replay")
+	p.Events.Publish(events.Event{Kind: events.FeedSilent, Topic: p.Rule.Feed, Id: "replay", Detail: p.Rule.File})
+}
+
+// pass plays the file once, starting from seekMs, pacing the packets by their
+// timestamps. It stops early if cancelled or asked to seek.
+func (p *Player) pass(seekMs int64) (int, int64) {
+
+	f, err := os.Open(p.Path)
+	if err != nil {
+		log.WithFields(log.Fields{"file": p.Path, "error": err}).Error("Opening replay")
+		p.mux.Lock()
+		p.state = StateFailed
+		p.failure = err.Error()
+		p.mux.Unlock()
+		return passStop, 0
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, maxChunk)
+
+	pkt := make([]byte, ts.PacketSize)
+	chunk := []byte{}
+
+	var first, base uint64
+	var baseWall time.Time
+	haveFirst, haveBase, usingPCR := false, false, false
+	skipping := seekMs > 0
+
+	for {
+		select {
+		case <-p.Context.Done():
+			return passStop, 0
+		case next := <-p.seek:
+			return passSeek, next
+		default:
+		}
+
+		if err := readPacket(reader, pkt); err != nil {
+			if p.send(chunk) != nil {
+				return passStop, 0
+			}
+			return passEOF, 0
+		}
+
+		clock, ok := ts.PCR(pkt)
+
+		if ok && !usingPCR {
+			// prefer PCR, and don't mix clocks
+			usingPCR = true
+			haveFirst, haveBase = false, false
+		}
+
+		if !ok && !usingPCR {
+			var pts uint64
+			if pts, ok = ts.PTS(pkt); ok {
+				clock = pts * (ts.PCRHz / ts.PTSHz)
+			}
+		}
+
+		if ok {
+
+			if !haveFirst {
+				first, haveFirst = clock, true
+			}
+
+			position := int64(0)
+			if clock > first {
+				position = int64(ticks(clock-first) / time.Millisecond)
+			}
+
+			if skipping {
+				if position < seekMs {
+					continue
+				}
+				skipping, haveBase = false, false
+			}
+
+			if err := p.send(chunk); err != nil {
+				return passStop, 0
+			}
+			chunk = []byte{}
+
+			switch {
+			case !haveBase, clock+uint64(maxStepBack/time.Second)*ts.PCRHz < base, clock > base && ticks(clock-base) > maxJump:
+				base, baseWall, haveBase = clock, time.Now(), true
+			case clock > base:
+				if result, next := p.wait(baseWall.Add(ticks(clock - base))); result != passEOF {
+					return result, next
+				}
+			}
+
+			p.mux.Lock()
+			p.positionMs = position
+			p.mux.Unlock()
+
+		} else if skipping {
+			continue
+		}
+
+		chunk = append(chunk, pkt...)
+
+		if len(chunk) >= maxChunk {
+
+			if err := p.send(chunk); err != nil {
+				return passStop, 0
+			}
+
+			if !haveBase && p.FallbackBps > 0 {
+				// no timestamps (yet), so pace by bitrate instead
+				delay := time.Duration(len(chunk)*8) * time.Second / time.Duration(p.FallbackBps)
+				if result, next := p.wait(time.Now().Add(delay)); result != passEOF {
+					return result, next
+				}
+			}
+
+			chunk = []byte{}
+		}
+	}
+}
+
+// wait until due, returning passEOF if we got there without interruption
+func (p *Player) wait(due time.Time) (int, int64) {
+
+	delay := time.Until(due)
+
+	if delay <= 0 {
+		return passEOF, 0
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return passEOF, 0
+	case <-p.Context.Done():
+		return passStop, 0
+	case next := <-p.seek:
+		return passSeek, next
+	}
+}
+
+// send the chunk to the hub, like handleTs does
+func (p *Player) send(chunk []byte) error {
+
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	msg := hub.Message{Sender: *p.Messages, Type: hub.Binary, Data: chunk, Sent: time.Now()}
+
+	select {
+	case p.Broadcast <- msg:
+		return nil
+	case <-p.Context.Done():
+		return p.Context.Err()
+	}
+}
+
+// readPacket reads the next packet, skipping any junk before a sync byte
+func readPacket(reader *bufio.Reader, pkt []byte) error {
+
+	if _, err := io.ReadFull(reader, pkt); err != nil {
+		return err
+	}
+
+	for pkt[0] != ts.SyncByte {
+
+		i := bytes.IndexByte(pkt[1:], ts.SyncByte)
+
+		if i < 0 {
+			if _, err := io.ReadFull(reader, pkt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		n := copy(pkt, pkt[i+1:])
+
+		if _, err := io.ReadFull(reader, pkt[n:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ticks converts a number of 27MHz ticks to a duration
+func ticks(t uint64) time.Duration {
+	return time.Duration(t * 1000 / (ts.PCRHz / 1000000))
+}
@@ -0,0 +1,240 @@
+package replay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+// writeFile makes a file of frames, 40ms apart, each of which
+// is one packet with a PCR followed by some payload packets
+func writeFile(t *testing.T, dir, name string, frames int, junk []byte) {
+
+	data := append([]byte{}, junk...)
+
+	for i := 0; i < frames; i++ {
+		pcr := uint64(i) * 40 * ts.PCRHz / 1000
+		data = append(data, ts.Encode(ts.Header{PID: 0x100, HasPCR: true, PCR: pcr, CC: uint8(i)}, []byte{byte(i)})...)
+		for j := 0; j < 3; j++ {
+			data = append(data, ts.Encode(ts.Header{PID: 0x101, CC: uint8(j)}, []byte{byte(i)})...)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startReplayer(t *testing.T, closed chan struct{}) (*Replayer, *hub.Client, string) {
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	r := New(mh, dir)
+	go r.Run(closed)
+
+	rx := &hub.Client{Hub: mh.Hub, Name: "rx", Topic: "video0", Send: make(chan hub.Message, 100), Stats: hub.NewClientStats()}
+	mh.Register <- rx
+
+	return r, rx, dir
+}
+
+// receive collects packets until none arrive for a while
+func receive(rx *hub.Client, quiet time.Duration) []byte {
+
+	data := []byte{}
+
+	for {
+		select {
+		case msg := <-rx.Send:
+			data = append(data, msg.Data...)
+		case <-time.After(quiet):
+			return data
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	if err := Check(Rule{Feed: "video0", File: "video0/a.ts"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bad := map[error]Rule{
+		errNoFeed:     {File: "a.ts"},
+		errNoFile:     {Feed: "video0"},
+		errOutsideDir: {Feed: "video0", File: "../a.ts"},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error got/wanted %v/%v", err, expected)
+		}
+	}
+
+	if err := Check(Rule{Feed: "video0", File: "/etc/passwd"}); err != errOutsideDir {
+		t.Errorf("Wrong error got/wanted %v/%v", err, errOutsideDir)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "a.ts", 2, []byte{0x00, 0x01, 0x02})
+	writeFile(t, dir, "b.ts", 0, ts.Encode(ts.Header{PID: 0x100}, nil))
+	ioutil.WriteFile(filepath.Join(dir, "c.txt"), bytes.Repeat([]byte("G is for gopher\n"), 100), 0644)
+	os.Mkdir(filepath.Join(dir, "d.ts"), 0755)
+
+	files := map[string]error{
+		"a.ts":  nil,
+		"b.ts":  nil,
+		"c.txt": errNotTS,
+		"d.ts":  errNoSuchFile,
+		"e.ts":  errNoSuchFile,
+	}
+
+	for file, expected := range files {
+		if err := CheckFile(dir, file); err != expected {
+			t.Errorf("Wrong error for %s got/wanted %v/%v", file, err, expected)
+		}
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, _, dir := startReplayer(t, closed)
+	defer os.RemoveAll(dir)
+
+	r.Add <- Rule{Feed: "video0", File: "a.ts"}
+
+	time.Sleep(10 * time.Millisecond)
+
+	status := r.Report()["video0"]
+
+	if status.State != StateFailed || status.Error == "" {
+		t.Errorf("Wrong status for a missing file %v", status)
+	}
+}
+
+func TestPaced(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, rx, dir := startReplayer(t, closed)
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "a.ts", 10, []byte{0x00, 0x01, 0x02})
+
+	start := time.Now()
+	r.Add <- Rule{Feed: "video0", File: "a.ts"}
+
+	data := receive(rx, 100*time.Millisecond)
+
+	elapsed := time.Since(start) - 100*time.Millisecond
+
+	if len(data) != 40*ts.PacketSize {
+		t.Errorf("Wrong amount of data got/wanted %d/%d", len(data), 40*ts.PacketSize)
+	}
+
+	if data[0] != ts.SyncByte {
+		t.Error("Did not resync after junk")
+	}
+
+	if elapsed < 330*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Errorf("Not paced by PCR; took %v to send 360ms", elapsed)
+	}
+
+	report := r.Report()
+
+	if report["video0"].State != StateFinished {
+		t.Errorf("Wrong state got/wanted %s/%s", report["video0"].State, StateFinished)
+	}
+
+	if report["video0"].PositionMs != 360 {
+		t.Errorf("Wrong position got/wanted %d/%d", report["video0"].PositionMs, 360)
+	}
+}
+
+func TestSeekAndLoop(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	r, rx, dir := startReplayer(t, closed)
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "a.ts", 10, nil)
+
+	r.Add <- Rule{Feed: "video0", File: "a.ts", StartMs: 200}
+
+	data := receive(rx, 100*time.Millisecond)
+
+	if len(data) != 20*ts.PacketSize {
+		t.Errorf("Wrong amount of data after start position got/wanted %d/%d", len(data), 20*ts.PacketSize)
+	}
+
+	if err := r.Seek("nothere", 0); err != errNotFound {
+		t.Errorf("Wrong error got/wanted %v/%v", err, errNotFound)
+	}
+
+	r.Add <- Rule{Feed: "video0", File: "a.ts", Loop: true}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := r.Seek("video0", 320); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := r.SetLoop("video0", false); err != nil {
+		t.Error(err)
+	}
+
+	// seek to near the end, then play out without looping
+	if err := r.Seek("video0", 320); err != nil {
+		t.Error(err)
+	}
+
+	receive(rx, 100*time.Millisecond)
+
+	report := r.Report()
+
+	if report["video0"].State != StateFinished || report["video0"].Loop {
+		t.Errorf("Unexpected status %v", report["video0"])
+	}
+
+	r.Delete <- "video0"
+
+	time.Sleep(10 * time.Millisecond)
+
+	if len(r.Report()) != 0 {
+		t.Error("Replay not deleted")
+	}
+}
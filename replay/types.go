@@ -0,0 +1,63 @@
+package replay
+
+import (
+	"context"
+	"sync"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+// States that a player can be in
+const (
+	StatePlaying  = "playing"
+	StateFinished = "finished"
+	StateFailed   = "failed"
+)
+
+type Replayer struct {
+	Messages    *agg.Hub
+	Events      *events.Bus //optional, nil is ok
+	Dir         string      // files are replayed from under here
+	FallbackBps int         // pacing for files without PCR or PTS
+	Add         chan Rule
+	Delete      chan string //Feed string
+	mux         sync.Mutex
+	players     map[string]*Player //map Feed string to Player
+}
+
+type Rule struct {
+	Feed    string `json:"feed"`
+	File    string `json:"file"`
+	Loop    bool   `json:"loop"`
+	StartMs int64  `json:"startMs,omitempty"`
+}
+
+// Player publishes a file to a feed, as if it were a handleTs publisher
+type Player struct {
+	Rule        Rule
+	Path        string
+	Messages    *hub.Client
+	Broadcast   chan hub.Message
+	Events      *events.Bus
+	FallbackBps int
+	Context     context.Context
+	Cancel      context.CancelFunc
+	Stopped     chan struct{}
+	seek        chan int64
+	mux         sync.Mutex
+	loop        bool
+	positionMs  int64
+	state       string
+	failure     string // why the state is failed
+}
+
+// Status that we report externally
+type Status struct {
+	Rule       Rule   `json:"rule"`
+	Loop       bool   `json:"loop"`
+	PositionMs int64  `json:"positionMs"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+}
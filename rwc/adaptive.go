@@ -0,0 +1,236 @@
+package rwc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/ts"
+)
+
+// Thresholds for adaptive stream selection; vars so tests can shorten them
+var (
+	AdaptInterval  = time.Second      // how often to decide whether to switch
+	UpgradeHoldoff = 10 * time.Second // how long to stay put before upgrading
+	DowngradeAbove = 0.8              // fraction of time spent writing
+	UpgradeMargin  = 1.5              // capacity needed over the better stream's rate
+)
+
+// Adaptive chooses which of a list of streams to send, best first, by
+// measuring how long writes to the destination take
+type Adaptive struct {
+	Streams    []string
+	Variants   []*hub.Client // one per stream, in the same order
+	mux        sync.Mutex
+	current    int
+	pending    int // stream to switch to at its next keyframe, -1 for none
+	changed    time.Time
+	started    time.Time     // start of the measuring window
+	received   []int64       // bytes per stream in this window
+	rates      []float64     // bytes per second per stream, last window
+	written    int64         // bytes written in this window
+	writing    time.Duration // time spent writing in this window
+	writes     int
+	throughput float64 // bytes per second while writing, last window
+	latency    time.Duration
+}
+
+type variantMessage struct {
+	index int
+	msg   hub.Message
+}
+
+func newAdaptive(h *Hub, rule Rule) *Adaptive {
+
+	a := &Adaptive{Streams: rule.Streams,
+		pending:  -1,
+		changed:  time.Now(),
+		started:  time.Now(),
+		received: make([]int64, len(rule.Streams)),
+		rates:    make([]float64, len(rule.Streams))}
+
+	for _, stream := range rule.Streams {
+		a.Variants = append(a.Variants, &hub.Client{Hub: h.Messages.Hub,
+			Name:  rule.Destination,
+			Topic: stream,
+			Send:  make(chan hub.Message, 2),
+			Stats: hub.NewClientStats()})
+	}
+
+	return a
+}
+
+// Current returns the stream being sent
+func (a *Adaptive) Current() string {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.Streams[a.current]
+}
+
+func (a *Adaptive) index() int {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.current
+}
+
+// Measurements returns the write throughput in bytes per second, and mean
+// write latency, over the last window
+func (a *Adaptive) Measurements() (float64, time.Duration) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.throughput, a.latency
+}
+
+// accept reports whether a message should be sent, switching streams
+// first if this message is the keyframe we are waiting for
+func (a *Adaptive) accept(index int, data []byte) bool {
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.received[index] += int64(len(data))
+
+	if index == a.pending && hasKeyframe(data) {
+		a.current = index
+		a.pending = -1
+		a.changed = time.Now()
+	}
+
+	return index == a.current
+}
+
+// hasKeyframe reports whether a message has a keyframe in it, so the
+// stream can be switched there without breaking a GOP. Unlike isKey,
+// it is false for tables and for anything that isn't MPEG-TS.
+func hasKeyframe(data []byte) bool {
+
+	for i := 0; i+ts.PacketSize <= len(data); i += ts.PacketSize {
+
+		p := data[i : i+ts.PacketSize]
+
+		if ts.Valid(p) && ts.RandomAccess(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Adaptive) wrote(n int, took time.Duration) {
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.written += int64(n)
+	a.writing += took
+	a.writes++
+}
+
+// evaluate ends the measuring window, and decides whether to switch
+func (a *Adaptive) evaluate() {
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(a.started).Seconds()
+
+	if elapsed <= 0 {
+		return
+	}
+
+	for i := range a.received {
+		a.rates[i] = float64(a.received[i]) / elapsed
+		a.received[i] = 0
+	}
+
+	utilisation := a.writing.Seconds() / elapsed
+
+	a.throughput = 0
+	if a.writing > 0 {
+		a.throughput = float64(a.written) / a.writing.Seconds()
+	}
+
+	a.latency = 0
+	if a.writes > 0 {
+		a.latency = a.writing / time.Duration(a.writes)
+	}
+
+	switch {
+	case utilisation > DowngradeAbove && a.current < len(a.Streams)-1:
+		a.pending = a.current + 1
+	case a.current > 0 && now.Sub(a.changed) > UpgradeHoldoff &&
+		(a.writing == 0 || a.throughput > UpgradeMargin*a.rates[a.current-1]):
+		a.pending = a.current - 1
+	case utilisation <= DowngradeAbove && a.pending > a.current:
+		a.pending = -1 // things got better before we switched
+	}
+
+	a.started = now
+	a.written = 0
+	a.writing = 0
+	a.writes = 0
+}
+
+// relay messages from the selected stream to the destination until stopped
+func (c *Client) RelayAdaptive(ctx context.Context) {
+
+	in := make(chan variantMessage)
+
+	for i, variant := range c.Adaptive.Variants {
+		go func(index int, variant *hub.Client) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg, ok := <-variant.Send:
+					if !ok {
+						return
+					}
+					select {
+					case in <- variantMessage{index: index, msg: msg}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(i, variant)
+	}
+
+	ticker := time.NewTicker(AdaptInterval)
+	defer ticker.Stop()
+
+	defer close(c.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.draining:
+			c.drain(ctx, c.Adaptive.Variants[c.Adaptive.index()].Send)
+			return
+		case <-ticker.C:
+			c.Adaptive.evaluate()
+		case vm := <-in:
+
+			if !c.Adaptive.accept(vm.index, vm.msg.Data) || !c.shape(ctx, vm.msg.Data) {
+				break
+			}
+
+			// time spent waiting to reconnect says nothing about throughput
+			measure := c.Destination.IsConnected()
+			start := time.Now()
+
+			select {
+			case c.Out <- c.wsMessage(vm.msg):
+			case <-ctx.Done():
+				return
+			}
+
+			if measure {
+				c.Adaptive.wrote(len(vm.msg.Data), time.Since(start))
+			}
+		}
+	}
+}
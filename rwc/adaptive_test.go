@@ -0,0 +1,118 @@
+package rwc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/ts"
+)
+
+func TestAdaptiveSwitching(t *testing.T) {
+
+	h := New(agg.New())
+
+	a := newAdaptive(h, Rule{Id: "0", Destination: "ws://somewhere", Streams: []string{"stream/large", "stream/small"}})
+
+	plain := bytes.Repeat(ts.Encode(ts.Header{PID: 0x100}, []byte{1}), 2)
+	key := ts.Encode(ts.Header{PID: 0x100, RandomAccess: true}, []byte{1})
+
+	if len(a.Variants) != 2 || a.Variants[1].Topic != "stream/small" {
+		t.Fatalf("Wrong variants %v", a.Variants)
+	}
+
+	if !a.accept(0, plain) || a.accept(1, plain) {
+		t.Error("Not sending best stream to start with")
+	}
+
+	// spend most of the window writing
+	a.started = time.Now().Add(-time.Second)
+	a.wrote(1000, 900*time.Millisecond)
+	a.evaluate()
+
+	if a.pending != 1 {
+		t.Errorf("Did not decide to downgrade")
+	}
+
+	// wait for a keyframe on the new stream before switching
+	if !a.accept(0, plain) || a.accept(1, plain) {
+		t.Error("Switched before keyframe")
+	}
+
+	// tables and chunks that aren't MPEG-TS can come mid-GOP
+	pat := ts.Encode(ts.Header{PID: 0, PUSI: true}, ts.PATPayload(map[uint16]uint16{1: 0x1000}, 0))
+
+	if !a.accept(0, pat) || a.accept(1, pat) {
+		t.Error("Switched at PAT")
+	}
+
+	if !a.accept(0, []byte("not ts")) || a.accept(1, []byte("not ts")) {
+		t.Error("Switched at a chunk that isn't MPEG-TS")
+	}
+
+	if !a.accept(1, key) || a.accept(0, key) || a.Current() != "stream/small" {
+		t.Error("Did not switch at keyframe")
+	}
+
+	// not allowed to upgrade straight away
+	a.started = time.Now().Add(-time.Second)
+	a.received[0] = 1000 // large stream is 1000 bytes/s
+	a.wrote(1000, 10*time.Millisecond)
+	a.evaluate()
+
+	if a.pending != -1 {
+		t.Error("Upgraded during hold off")
+	}
+
+	throughput, latency := a.Measurements()
+
+	if throughput != 100000 || latency != 10*time.Millisecond {
+		t.Errorf("Wrong measurements %f %v", throughput, latency)
+	}
+
+	// plenty of capacity for the large stream, after hold off
+	a.changed = time.Now().Add(-UpgradeHoldoff - time.Second)
+	a.started = time.Now().Add(-time.Second)
+	a.received[0] = 1000
+	a.wrote(1000, 10*time.Millisecond)
+	a.evaluate()
+
+	if a.pending != 0 {
+		t.Error("Did not decide to upgrade")
+	}
+
+	if !a.accept(0, key) || a.Current() != "stream/large" {
+		t.Error("Did not switch back at keyframe")
+	}
+}
+
+func TestAdaptiveStatus(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	h := New(mh)
+	go h.Run(closed)
+
+	h.Add <- Rule{Id: "0", Destination: "ws://localhost:1/nowhere", Streams: []string{"stream/large", "stream/small"}}
+
+	time.Sleep(2 * time.Millisecond)
+
+	status := h.Status()["0"]
+
+	if status.Stream != "stream/large" || status.Variant != "stream/large" {
+		t.Errorf("Wrong status %v", status)
+	}
+
+	h.Delete <- "0"
+
+	time.Sleep(2 * time.Millisecond)
+
+	if len(h.Status()) != 0 {
+		t.Error("Adaptive destination not deleted")
+	}
+}
@@ -0,0 +1,137 @@
+package rwc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/ts"
+)
+
+// Bucket is a token bucket that limits the average rate of bytes sent,
+// while allowing bursts. A nil Bucket places no limit.
+type Bucket struct {
+	Rate   float64 // bytes per second
+	Burst  float64 // bytes
+	mux    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns nil, i.e. no limit, if rate is not positive;
+// burst defaults to one second's worth of bytes
+func NewBucket(rate, burst int64) *Bucket {
+
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &Bucket{Rate: float64(rate),
+		Burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now()}
+}
+
+// refill adds tokens for the time since the last call; call with lock held
+func (b *Bucket) refill() {
+
+	now := time.Now()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.Rate
+
+	if b.tokens > b.Burst {
+		b.tokens = b.Burst
+	}
+
+	b.last = now
+}
+
+// Take removes n tokens if they are available now, and reports whether it did.
+// Messages bigger than the burst can be taken when the bucket is full.
+func (b *Bucket) Take(n int) bool {
+
+	if b == nil {
+		return true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill()
+
+	need := float64(n)
+	if need > b.Burst {
+		need = b.Burst
+	}
+
+	if b.tokens < need {
+		return false
+	}
+
+	b.tokens -= float64(n)
+
+	return true
+}
+
+// Refund returns n tokens, e.g. after a Take that was not used
+func (b *Bucket) Refund(n int) {
+
+	if b == nil {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.tokens += float64(n)
+
+	if b.tokens > b.Burst {
+		b.tokens = b.Burst
+	}
+}
+
+// Reserve removes n tokens even if they are not available yet, and
+// returns how long to wait until they would have been
+func (b *Bucket) Reserve(n int) time.Duration {
+
+	if b == nil {
+		return 0
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill()
+
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.Rate * float64(time.Second))
+}
+
+// isKey reports whether a message should be delayed rather than dropped
+// when over the limit; that is anything with a keyframe or table in it,
+// and anything that isn't MPEG-TS, because we can't tell what it holds
+func isKey(data []byte) bool {
+
+	if len(data) == 0 || len(data)%ts.PacketSize != 0 {
+		return true
+	}
+
+	for i := 0; i < len(data); i += ts.PacketSize {
+
+		p := data[i : i+ts.PacketSize]
+
+		if !ts.Valid(p) || ts.RandomAccess(p) || ts.PID(p) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package rwc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/ts"
+)
+
+func TestBucket(t *testing.T) {
+
+	var none *Bucket
+
+	if !none.Take(1000000) || none.Reserve(1000000) != 0 {
+		t.Error("nil bucket should not limit")
+	}
+
+	if NewBucket(0, 100) != nil {
+		t.Error("zero rate should not limit")
+	}
+
+	b := NewBucket(1000, 500)
+
+	if !b.Take(400) {
+		t.Error("could not take within burst")
+	}
+
+	if b.Take(200) {
+		t.Error("took more than burst")
+	}
+
+	b.Refund(400)
+
+	if !b.Take(500) {
+		t.Error("could not take after refund")
+	}
+
+	// 100 bytes at 1000 bytes/s is about 100ms
+	delay := b.Reserve(100)
+
+	if delay < 90*time.Millisecond || delay > 110*time.Millisecond {
+		t.Errorf("Wrong delay %v", delay)
+	}
+
+	time.Sleep(delay + 50*time.Millisecond)
+
+	if !b.Take(40) {
+		t.Error("bucket did not refill")
+	}
+}
+
+func TestIsKey(t *testing.T) {
+
+	plain := ts.Encode(ts.Header{PID: 0x100}, []byte{1})
+	key := ts.Encode(ts.Header{PID: 0x100, RandomAccess: true}, []byte{1})
+
+	if isKey(bytes.Repeat(plain, 3)) {
+		t.Error("plain packets are not key")
+	}
+
+	if !isKey(append(bytes.Repeat(plain, 3), key...)) {
+		t.Error("keyframe not found")
+	}
+
+	if !isKey([]byte("hello")) {
+		t.Error("messages that aren't MPEG-TS should be kept")
+	}
+}
+
+func TestShape(t *testing.T) {
+
+	h := &Hub{Limit: NewBucket(100000, 1000)}
+
+	c := &Client{Hub: h, Limit: NewBucket(10000, 1000)}
+
+	ctx := context.Background()
+
+	plain := bytes.Repeat(ts.Encode(ts.Header{PID: 0x100}, []byte{1}), 4)                   // 752 bytes
+	key := bytes.Repeat(ts.Encode(ts.Header{PID: 0x100, RandomAccess: true}, []byte{1}), 3) // 564 bytes
+
+	if !c.shape(ctx, plain) {
+		t.Error("dropped message within burst")
+	}
+
+	if c.shape(ctx, plain) {
+		t.Error("sent message over limit")
+	}
+
+	if c.dropped != 1 || c.droppedBytes != 752 {
+		t.Errorf("Wrong drop counters %d/%d", c.dropped, c.droppedBytes)
+	}
+
+	start := time.Now()
+
+	if !c.shape(ctx, key) {
+		t.Error("dropped key message")
+	}
+
+	// 248 bytes were left, so wait about 32ms for the other 316
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("key message was not delayed, took %v", elapsed)
+	}
+
+	if c.shaped != 1 {
+		t.Errorf("Wrong shaped counter %d", c.shaped)
+	}
+
+	// the global limit applies too
+	h.Limit = NewBucket(1000, 1000)
+	h.Limit.Take(500)
+
+	c.Limit = nil
+
+	if c.shape(ctx, plain) {
+		t.Error("sent message over global limit")
+	}
+}
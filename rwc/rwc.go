@@ -2,10 +2,17 @@ package rwc
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
+	"github.com/timdrysdale/vw/reconhttp"
+	"github.com/timdrysdale/vw/reconnet"
 	"github.com/timdrysdale/vw/reconws"
 )
 
@@ -14,37 +21,82 @@ import (
 func New(messages *agg.Hub) *Hub {
 
 	h := &Hub{
-		Messages: messages,
-		Clients:  make(map[string]*Client), //map Id string to Client
-		Rules:    make(map[string]Rule),    //map Id string to Rule
-		Add:      make(chan Rule),
-		Delete:   make(chan string), //Id string
+		Messages:     messages,
+		Clients:      make(map[string]*Client), //map Id string to Client
+		Rules:        make(map[string]Rule),    //map Id string to Rule
+		Add:          make(chan Rule),
+		Delete:       make(chan string), //Id string
+		Ping:         make(chan struct{}),
+		DrainTimeout: time.Second,
 	}
 
 	return h
 }
 
-func (h *Hub) Run(closed chan struct{}) {
+var errNoId = errors.New("Destination needs an id")
+var errNoDestination = errors.New("Destination needs a destination")
+var errNoStream = errors.New("Destination needs a stream")
+var errBadMessageType = errors.New("Message type must be text or binary")
 
-	defer func() {
-		//on exit, go through the list of open clients and close them
-		//may panic if a client is individually closed just before exiting
-		//but if exiting, a panic is less of an issue
-		for _, client := range h.Clients {
-			client.Cancel()
-		}
-	}()
+// messageTypes maps Rule.MessageType to the kind of message to send
+var messageTypes = map[string]hub.Kind{
+	"":       0,
+	"text":   hub.Text,
+	"binary": hub.Binary,
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Id == "" || rule.Id == "deleteAll" {
+		return errNoId
+	}
+
+	if rule.Destination == "" {
+		return errNoDestination
+	}
+
+	if rule.Stream == "" && len(rule.Streams) == 0 {
+		return errNoStream
+	}
+
+	if _, ok := messageTypes[rule.MessageType]; !ok {
+		return errBadMessageType
+	}
+
+	return nil
+}
+
+// Run handles rules until closed, then returns once the destinations
+// have been given up to DrainTimeout to send what is queued for them,
+// and up to DrainTimeout more to close
+func (h *Hub) Run(closed chan struct{}) {
 
 	for {
 		select {
 		case <-closed:
+			h.shutdown()
 			return
+		case <-h.Ping:
 		case rule := <-h.Add:
 
 			if rule.Id == "deleteAll" {
 				break //reserved id (for deleting all rules)
 			}
 
+			// If only the stream(s) have changed, keep the connection
+			// and just swap what we send down it
+			if client, ok := h.Clients[rule.Id]; ok && sameConnection(h.Rules[rule.Id], rule) {
+				h.mux.Lock()
+				h.Rules[rule.Id] = rule
+				h.mux.Unlock()
+				client.stopRelay()
+				client.unregister()
+				h.startRelay(client, rule)
+				h.Events.Publish(events.Event{Kind: events.DestinationAdded, Topic: rule.Stream, Id: rule.Id, Detail: rule.Destination})
+				break
+			}
+
 			// Allow multiple destinations for a stream;
 			// allow multiple streams per destination;
 			// allow only one client per rule.Id.
@@ -52,51 +104,47 @@ func (h *Hub) Run(closed chan struct{}) {
 			// because it just became superseded
 			if client, ok := h.Clients[rule.Id]; ok {
 				client = h.Clients[rule.Id]
-				h.Messages.Unregister <- client.Messages
+				client.unregister()
 				client.Cancel() //stop RelayIn() & RelayOut()
+				h.mux.Lock()
 				delete(h.Clients, rule.Id)
+				h.mux.Unlock()
 			}
-			if _, ok := h.Rules[rule.Id]; ok {
-				delete(h.Rules, rule.Id)
-
-			}
-
 			//record the new rule for later convenience in reporting
+			h.mux.Lock()
 			h.Rules[rule.Id] = rule
+			h.mux.Unlock()
 
-			// create new reconnecting websocket client
-			ws := reconws.New()
+			// create new reconnecting client for the destination
+			client := h.newClient(rule)
 
 			urlStr := rule.Destination //no sanity check - don't dupe ws functionality
 
 			token := rule.Token
 
-			// create client to handle stream messages
-			messageClient := &hub.Client{Hub: h.Messages.Hub,
-				Name:  rule.Destination,
-				Topic: rule.Stream,
-				Send:  make(chan hub.Message, 2),
-				Stats: hub.NewClientStats()}
-
 			ctx, cancel := context.WithCancel(context.Background())
-			client := &Client{Hub: h,
-				Messages:  messageClient,
-				Context:   ctx,
-				Cancel:    cancel,
-				Websocket: ws}
+			client.Context = ctx
+			client.Cancel = cancel
 
+			h.startRelay(client, rule)
+
+			h.mux.Lock()
 			h.Clients[rule.Id] = client
+			h.mux.Unlock()
 
-			h.Messages.Register <- client.Messages //register for messages from hub
+			h.Events.Publish(events.Event{Kind: events.DestinationAdded, Topic: rule.Stream, Id: rule.Id, Detail: rule.Destination})
 
 			go client.RelayIn(client.Context)
-			go client.RelayOut(client.Context)
 
-			if token == "" {
-				go ws.Reconnect(client.Context, urlStr)
-			} else {
-				go ws.ReconnectAuth(client.Context, urlStr, token)
-			}
+			h.wg.Add(1)
+			go func() {
+				defer h.wg.Done()
+				if token == "" {
+					client.Destination.Reconnect(client.Context, urlStr)
+				} else {
+					client.Destination.ReconnectAuth(client.Context, urlStr, token)
+				}
+			}()
 			//user must check stats to learn of errors
 			// an RPC style return on start is of limited value because clients are long lived
 			// so we'll need to check the stats later anyway; better just to do things one way
@@ -104,41 +152,330 @@ func (h *Hub) Run(closed chan struct{}) {
 		case ruleId := <-h.Delete:
 
 			if ruleId == "deleteAll" {
-				for _, client := range h.Clients {
-					h.Messages.Unregister <- client.Messages
+				for id, client := range h.Clients {
+					client.unregister()
 					client.Cancel() //stop RelayIn() & RelayOut()
+					h.Events.Publish(events.Event{Kind: events.DestinationDeleted, Id: id})
 				}
+				h.mux.Lock()
 				h.Clients = make(map[string]*Client)
 				h.Rules = make(map[string]Rule)
+				h.mux.Unlock()
 
 			} else {
 				if client, ok := h.Clients[ruleId]; ok {
-					h.Messages.Unregister <- client.Messages
+					client.unregister()
 					client.Cancel() //stop RelayIn() & RelayOut()
+					h.mux.Lock()
 					delete(h.Clients, ruleId)
+					h.mux.Unlock()
+					h.Events.Publish(events.Event{Kind: events.DestinationDeleted, Id: ruleId})
 				}
-				if _, ok := h.Rules[ruleId]; ok {
-					delete(h.Rules, ruleId)
-				}
+				h.mux.Lock()
+				delete(h.Rules, ruleId)
+				h.mux.Unlock()
 			}
 		}
 	}
 }
 
+// sameConnection is true if a rule can be swapped for another without
+// reconnecting to the destination
+func sameConnection(a, b Rule) bool {
+	return a.Destination == b.Destination && a.Token == b.Token
+}
+
+// startRelay registers the client for the rule's stream(s) and starts
+// relaying them to the destination, without touching the connection
+func (h *Hub) startRelay(client *Client, rule Rule) {
+
+	// create client to handle stream messages
+	messageClient := &hub.Client{Hub: h.Messages.Hub,
+		Name:  rule.Destination,
+		Topic: rule.Stream,
+		Send:  make(chan hub.Message, 2),
+		Stats: hub.NewClientStats()}
+
+	var adaptive *Adaptive
+
+	if len(rule.Streams) > 0 {
+		// one client per candidate stream, with the best
+		// standing in for the others where only one is needed
+		adaptive = newAdaptive(h, rule)
+		messageClient = adaptive.Variants[0]
+	}
+
+	ctx, cancel := context.WithCancel(client.Context)
+
+	h.mux.Lock()
+	client.Messages = messageClient
+	client.Adaptive = adaptive
+	h.mux.Unlock()
+
+	client.Limit = NewBucket(rule.RateBytesPerSec, rule.BurstBytes)
+	client.Kind = messageTypes[rule.MessageType]
+	client.relayCancel = cancel
+	client.draining = make(chan struct{})
+	client.done = make(chan struct{})
+
+	client.register() //register for messages from hub
+
+	if client.Adaptive != nil {
+		go client.RelayAdaptive(ctx)
+	} else {
+		go client.RelayOut(ctx)
+	}
+}
+
+// stopRelay stops relaying messages to the destination, and returns
+// once the relay has stopped, so it can be restarted
+func (c *Client) stopRelay() {
+	c.relayCancel()
+	<-c.done
+}
+
+// Snapshot returns a copy of every destination rule, which is safe
+// to use while the hub is running
+func (h *Hub) Snapshot() map[string]Rule {
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	rules := make(map[string]Rule)
+
+	for id, rule := range h.Rules {
+		rules[id] = rule
+	}
+
+	return rules
+}
+
+// Status reports the connection state of each destination
+func (h *Hub) Status() map[string]Status {
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	status := make(map[string]Status)
+
+	for id, client := range h.Clients {
+		s := Status{Id: id,
+			Stream:       client.Messages.Topic,
+			Destination:  client.Messages.Name,
+			Connected:    client.Destination.IsConnected(),
+			Shaped:       atomic.LoadInt64(&client.shaped),
+			Dropped:      atomic.LoadInt64(&client.dropped),
+			DroppedBytes: atomic.LoadInt64(&client.droppedBytes)}
+
+		if client.Adaptive != nil {
+			throughput, latency := client.Adaptive.Measurements()
+			s.Variant = client.Adaptive.Current()
+			s.ThroughputBytesPerSec = int64(throughput)
+			s.LatencyMs = float64(latency) / float64(time.Millisecond)
+		}
+
+		status[id] = s
+	}
+
+	return status
+}
+
+func (c *Client) register() {
+
+	if c.Adaptive == nil {
+		c.Hub.Messages.Register <- c.Messages
+		return
+	}
+
+	for _, variant := range c.Adaptive.Variants {
+		c.Hub.Messages.Register <- variant
+	}
+}
+
+func (c *Client) unregister() {
+
+	if c.Adaptive == nil {
+		c.Hub.Messages.Unregister <- c.Messages
+		return
+	}
+
+	for _, variant := range c.Adaptive.Variants {
+		c.Hub.Messages.Unregister <- variant
+	}
+}
+
+// newClient makes a client with a destination to suit the scheme of
+// rule.Destination; anything that isn't http(s), tcp or udp is treated
+// as a websocket
+func (h *Hub) newClient(rule Rule) *Client {
+
+	if strings.HasPrefix(rule.Destination, "http://") || strings.HasPrefix(rule.Destination, "https://") {
+		post := reconhttp.New()
+		post.Events = h.Events
+		post.Id = rule.Id
+		return &Client{Hub: h, Destination: post, Out: post.Out}
+	}
+
+	if strings.HasPrefix(rule.Destination, "tcp://") || strings.HasPrefix(rule.Destination, "udp://") {
+		raw := reconnet.New()
+		raw.Events = h.Events
+		raw.Id = rule.Id
+		return &Client{Hub: h, Destination: raw, In: raw.In, Out: raw.Out}
+	}
+
+	ws := reconws.New()
+	ws.Events = h.Events
+	ws.Id = rule.Id
+	return &Client{Hub: h, Destination: ws, In: ws.In, Out: ws.Out}
+}
+
 //use label to break from the for?
 
 // relay messages from the hub to the websocket client until stopped
 func (c *Client) RelayOut(ctx context.Context) {
+
+	defer close(c.done)
+
 LOOP:
 	for {
 		select {
 		case <-ctx.Done():
 			break LOOP
+		case <-c.draining:
+			c.drain(ctx, c.Messages.Send)
+			break LOOP
 		case msg, ok := <-c.Messages.Send:
-			if ok {
-				c.Websocket.Out <- reconws.WsMessage{Data: msg.Data, Type: msg.Type}
+			if ok && c.shape(ctx, msg.Data) {
+				select {
+				case c.Out <- c.wsMessage(msg):
+				case <-ctx.Done():
+					break LOOP
+				}
+			}
+		}
+	}
+}
+
+// wsMessage is the message as sent to the destination, with its data
+// forced to the kind the destination needs, if it needs one
+func (c *Client) wsMessage(msg hub.Message) reconws.WsMessage {
+
+	kind := msg.Type
+
+	if c.Kind != 0 && !kind.IsControl() {
+		kind = c.Kind
+	}
+
+	return reconws.WsMessage{Data: msg.Data, Type: int(kind)}
+}
+
+// drain sends whatever is already queued, until there is nothing left
+// or we are cancelled
+func (c *Client) drain(ctx context.Context, queue chan hub.Message) {
+	for {
+		select {
+		case msg := <-queue:
+			select {
+			case c.Out <- c.wsMessage(msg):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		default:
+			return
+		}
+	}
+}
+
+// shutdown stops taking messages from the hub, which may already have
+// stopped, then drains and closes every destination
+func (h *Hub) shutdown() {
+
+	h.mux.Lock()
+	clients := []*Client{}
+	for _, client := range h.Clients {
+		clients = append(clients, client)
+	}
+	h.mux.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.DrainTimeout)
+	defer cancel()
+
+	for _, client := range clients {
+		close(client.draining)
+	}
+
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+		}
+	}
+
+	// cancelling makes the destinations close their connections
+	for _, client := range clients {
+		client.Cancel()
+	}
+
+	closed := make(chan struct{})
+
+	go func() {
+		h.wg.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		log.Info("Closed all destinations")
+	case <-time.After(h.DrainTimeout):
+		log.Warn("Timed out closing destinations")
+	}
+}
+
+// shape reports whether to send a message now, after waiting if needed
+// to stay under the destination's limit and the limit across all
+// destinations. When over the limit, messages without keyframes are
+// dropped, and the rest are delayed.
+func (c *Client) shape(ctx context.Context, data []byte) bool {
+
+	if c.Limit == nil && c.Hub.Limit == nil {
+		return true
+	}
+
+	n := len(data)
+
+	if !isKey(data) {
+
+		if c.Limit.Take(n) {
+			if c.Hub.Limit.Take(n) {
+				return true
 			}
+			c.Limit.Refund(n)
 		}
+
+		atomic.AddInt64(&c.dropped, 1)
+		atomic.AddInt64(&c.droppedBytes, int64(n))
+		return false
+	}
+
+	delay := c.Limit.Reserve(n)
+
+	if global := c.Hub.Limit.Reserve(n); global > delay {
+		delay = global
+	}
+
+	if delay <= 0 {
+		return true
+	}
+
+	atomic.AddInt64(&c.shaped, 1)
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -149,9 +486,16 @@ LOOP:
 		select {
 		case <-ctx.Done():
 			break LOOP
-		case msg, ok := <-c.Websocket.In:
+		case msg, ok := <-c.In:
 			if ok {
-				c.Hub.Messages.Broadcast <- hub.Message{Data: msg.Data, Type: msg.Type, Sender: *c.Messages, Sent: time.Now()}
+				c.Hub.mux.Lock()
+				sender := *c.Messages //can change if the stream is swapped
+				c.Hub.mux.Unlock()
+				select {
+				case c.Hub.Messages.Broadcast <- hub.Message{Data: msg.Data, Type: hub.Kind(msg.Type), Sender: sender, Sent: time.Now()}:
+				case <-ctx.Done():
+					break LOOP
+				}
 			}
 		}
 	}
@@ -5,12 +5,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -549,7 +551,7 @@ func TestSendMessageTopicAuth(t *testing.T) {
 	payload := []byte("test message")
 	shoutedPayload := []byte("TEST MESSAGE")
 
-	mh.Broadcast <- hub.Message{Data: payload, Type: websocket.TextMessage, Sender: *c, Sent: time.Now()}
+	mh.Broadcast <- hub.Message{Data: payload, Type: hub.Text, Sender: *c, Sent: time.Now()}
 
 	select {
 	case msg := <-reply:
@@ -607,7 +609,7 @@ func TestSendMessageTopic(t *testing.T) {
 	payload := []byte("test message")
 	shoutedPayload := []byte("TEST MESSAGE")
 
-	mh.Broadcast <- hub.Message{Data: payload, Type: websocket.TextMessage, Sender: *c, Sent: time.Now()}
+	mh.Broadcast <- hub.Message{Data: payload, Type: hub.Text, Sender: *c, Sent: time.Now()}
 
 	select {
 	case msg := <-reply:
@@ -754,8 +756,8 @@ func TestSendMessageStream(t *testing.T) {
 	// clients send messages ...
 	for i := 0; i < 5; i++ {
 		log.Debug("Sending message", i)
-		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: websocket.TextMessage, Sender: *c0, Sent: time.Now()}
-		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: websocket.TextMessage, Sender: *c1, Sent: time.Now()}
+		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: hub.Text, Sender: *c0, Sent: time.Now()}
+		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: hub.Text, Sender: *c1, Sent: time.Now()}
 		time.Sleep(time.Millisecond)
 	}
 
@@ -899,8 +901,8 @@ func TestSendMessageToChangingDestination(t *testing.T) {
 	// clients send messages ...
 	for i := 0; i < 5; i++ {
 		log.Debug("Sending message", i)
-		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: websocket.TextMessage, Sender: *c0, Sent: time.Now()}
-		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: websocket.TextMessage, Sender: *c1, Sent: time.Now()}
+		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: hub.Text, Sender: *c0, Sent: time.Now()}
+		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: hub.Text, Sender: *c1, Sent: time.Now()}
 		time.Sleep(time.Millisecond)
 	}
 	time.Sleep(100 * time.Millisecond)
@@ -920,8 +922,8 @@ func TestSendMessageToChangingDestination(t *testing.T) {
 	// clients send messages ...
 	for i := 0; i < 10; i++ {
 		log.Debug("Sending message", i)
-		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: websocket.TextMessage, Sender: *c0, Sent: time.Now()}
-		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: websocket.TextMessage, Sender: *c1, Sent: time.Now()}
+		c0.Hub.Broadcast <- hub.Message{Data: payload, Type: hub.Text, Sender: *c0, Sent: time.Now()}
+		c1.Hub.Broadcast <- hub.Message{Data: shoutedPayload, Type: hub.Text, Sender: *c1, Sent: time.Now()}
 		time.Sleep(time.Millisecond)
 	}
 
@@ -1051,3 +1053,385 @@ func suppressLog() {
 func displayLog() {
 	log.SetOutput(os.Stdout)
 }
+
+func TestSendMessageHttp(t *testing.T) {
+
+	received := make(chan []byte, 10)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				received <- append([]byte{}, buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	time.Sleep(time.Millisecond)
+
+	h := New(mh)
+	go h.Run(closed)
+
+	id := "rule0"
+	stream := "medium"
+
+	h.Add <- Rule{Id: id, Stream: stream, Destination: s.URL + "/ts/medium"}
+
+	time.Sleep(time.Millisecond)
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: stream, Send: make(chan hub.Message)}
+
+	payload := []byte("test message")
+
+	timeout := time.After(time.Second)
+
+	// keep sending until connected, because messages are dropped until then
+	for {
+		mh.Broadcast <- hub.Message{Data: payload, Type: hub.Binary, Sender: *c, Sent: time.Now()}
+
+		select {
+		case msg := <-received:
+			if bytes.Compare(msg, payload) != 0 {
+				t.Errorf("Got wrong message %s", msg)
+			}
+			if !h.Status()[id].Connected {
+				t.Error("Not connected")
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestSendMessageTcp(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// shout back whatever we receive
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			c.Write(bytes.ToUpper(buf[:n]))
+		}
+	}()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	time.Sleep(time.Millisecond)
+
+	h := New(mh)
+	go h.Run(closed)
+
+	id := "rule0"
+	stream := "data"
+
+	h.Add <- Rule{Id: id, Stream: stream, Destination: "tcp://" + l.Addr().String()}
+
+	reply := make(chan hub.Message)
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: stream, Send: reply}
+
+	h.Messages.Register <- c
+
+	timeout := time.After(time.Second)
+
+	for !h.Status()[id].Connected {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting to connect")
+		}
+	}
+
+	mh.Broadcast <- hub.Message{Data: []byte("test message"), Type: hub.Binary, Sender: *c, Sent: time.Now()}
+
+	select {
+	case msg := <-reply:
+		if string(msg.Data) != "TEST MESSAGE" {
+			t.Errorf("Got wrong message %s", msg.Data)
+		}
+	case <-timeout:
+		t.Error("timed out waiting for reply")
+	}
+}
+
+func TestShutdownDrainsAndCloses(t *testing.T) {
+
+	msgChan := make(chan reconws.WsMessage, 10)
+	closeChan := make(chan int, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, message, err := c.ReadMessage()
+			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					closeChan <- ce.Code
+				}
+				return
+			}
+			msgChan <- reconws.WsMessage{Data: message, Type: mt}
+		}
+	}))
+	defer s.Close()
+
+	closed := make(chan struct{})
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	time.Sleep(time.Millisecond)
+
+	h := New(mh)
+
+	stopped := make(chan struct{})
+	go func() {
+		h.Run(closed)
+		close(stopped)
+	}()
+
+	h.Add <- Rule{Id: "rule0", Stream: "medium", Destination: "ws" + strings.TrimPrefix(s.URL, "http")}
+
+	time.Sleep(10 * time.Millisecond)
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: "medium", Send: make(chan hub.Message)}
+
+	payloads := []string{"one", "two"}
+
+	for _, p := range payloads {
+		mh.Broadcast <- hub.Message{Data: []byte(p), Type: hub.Text, Sender: *c, Sent: time.Now()}
+	}
+
+	time.Sleep(time.Millisecond)
+
+	close(closed)
+
+	select {
+	case <-stopped:
+	case <-time.After(3 * h.DrainTimeout):
+		t.Fatal("timed out waiting for Run to return")
+	}
+
+	for _, p := range payloads {
+		select {
+		case msg := <-msgChan:
+			if string(msg.Data) != p {
+				t.Errorf("Got wrong message; got/wanted %s/%s", msg.Data, p)
+			}
+		case <-time.After(10 * time.Millisecond):
+			t.Errorf("timed out waiting for message %s", p)
+		}
+	}
+
+	select {
+	case code := <-closeChan:
+		if code != websocket.CloseNormalClosure {
+			t.Errorf("Wrong close code; got/wanted %d/%d", code, websocket.CloseNormalClosure)
+		}
+	case <-time.After(10 * time.Millisecond):
+		t.Error("timed out waiting for close frame")
+	}
+}
+
+func TestChangeStreamKeepsConnection(t *testing.T) {
+
+	var connections int32
+
+	msgChan := make(chan reconws.WsMessage, 10)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&connections, 1)
+		report(w, r, msgChan)
+	}))
+	defer s.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	time.Sleep(time.Millisecond)
+
+	h := New(mh)
+	go h.Run(closed)
+
+	destination := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	h.Add <- Rule{Id: "rule0", Stream: "medium", Destination: destination}
+
+	time.Sleep(10 * time.Millisecond)
+
+	h.mux.Lock()
+	client := h.Clients["rule0"]
+	h.mux.Unlock()
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: "medium", Send: make(chan hub.Message)}
+
+	expect := func(topic, payload string, want bool) {
+		sender := *c
+		sender.Topic = topic
+		mh.Broadcast <- hub.Message{Data: []byte(payload), Type: hub.Text, Sender: sender, Sent: time.Now()}
+		select {
+		case msg := <-msgChan:
+			if !want {
+				t.Errorf("Got message %s from %s after changing stream", msg.Data, topic)
+			} else if string(msg.Data) != payload {
+				t.Errorf("Got wrong message; got/wanted %s/%s", msg.Data, payload)
+			}
+		case <-time.After(10 * time.Millisecond):
+			if want {
+				t.Errorf("timed out waiting for message from %s", topic)
+			}
+		}
+	}
+
+	expect("medium", "before", true)
+
+	h.Add <- Rule{Id: "rule0", Stream: "large", Destination: destination}
+
+	time.Sleep(10 * time.Millisecond)
+
+	expect("medium", "old stream", false)
+	expect("large", "new stream", true)
+
+	h.mux.Lock()
+	if h.Clients["rule0"] != client {
+		t.Error("Client was replaced")
+	}
+	h.mux.Unlock()
+
+	if h.Status()["rule0"].Stream != "large" {
+		t.Errorf("Wrong stream in status; got/wanted %s/%s", h.Status()["rule0"].Stream, "large")
+	}
+
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("Wrong number of connections; got/wanted %d/%d", n, 1)
+	}
+
+	// a different destination still needs a new connection
+	h.Add <- Rule{Id: "rule0", Stream: "large", Destination: destination + "/other"}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&connections); n != 2 {
+		t.Errorf("Wrong number of connections; got/wanted %d/%d", n, 2)
+	}
+}
+
+func TestSendMessageType(t *testing.T) {
+
+	asSent := make(chan reconws.WsMessage, 10)
+	asText := make(chan reconws.WsMessage, 10)
+
+	s0 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { report(w, r, asSent) }))
+	defer s0.Close()
+
+	s1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { report(w, r, asText) }))
+	defer s1.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	time.Sleep(time.Millisecond)
+
+	h := New(mh)
+	go h.Run(closed)
+
+	h.Add <- Rule{Id: "rule0", Stream: "medium", Destination: "ws" + strings.TrimPrefix(s0.URL, "http")}
+	h.Add <- Rule{Id: "rule1", Stream: "medium", Destination: "ws" + strings.TrimPrefix(s1.URL, "http"), MessageType: "text"}
+
+	for i := 0; i < 100; i++ {
+		status := h.Status()
+		if status["rule0"].Connected && status["rule1"].Connected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c := &hub.Client{Hub: mh.Hub, Name: "a", Topic: "medium", Send: make(chan hub.Message, 10)}
+	mh.Register <- c
+
+	time.Sleep(time.Millisecond)
+
+	mh.Broadcast <- hub.Message{Data: []byte(`{"x":1}`), Type: hub.Binary, Sender: *c, Sent: time.Now()}
+
+	for expected, msgChan := range map[int]chan reconws.WsMessage{
+		websocket.BinaryMessage: asSent,
+		websocket.TextMessage:   asText} {
+
+		select {
+		case msg := <-msgChan:
+			if msg.Type != expected || string(msg.Data) != `{"x":1}` {
+				t.Errorf("Wrong message %q (type %d), wanted type %d", msg.Data, msg.Type, expected)
+			}
+		case <-time.After(time.Second):
+			t.Error("timed out waiting for message")
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	good := []Rule{
+		{Id: "00", Stream: "stream/large", Destination: "wss://somewhere"},
+		{Id: "00", Streams: []string{"stream/large", "stream/small"}, Destination: "wss://somewhere"},
+		{Id: "00", Stream: "stream/large", Destination: "wss://somewhere", MessageType: "text"},
+	}
+
+	for _, rule := range good {
+		if err := Check(rule); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+
+	bad := map[error]Rule{
+		errNoId:           {Id: "deleteAll", Stream: "stream/large", Destination: "wss://somewhere"},
+		errNoDestination:  {Id: "00", Stream: "stream/large"},
+		errNoStream:       {Id: "00", Destination: "wss://somewhere"},
+		errBadMessageType: {Id: "00", Stream: "stream/large", Destination: "wss://somewhere", MessageType: "json"},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error got/wanted %v/%v", err, expected)
+		}
+	}
+}
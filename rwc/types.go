@@ -2,32 +2,78 @@ package rwc
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
 	"github.com/timdrysdale/vw/hub"
 	"github.com/timdrysdale/vw/reconws"
 )
 
 type Hub struct {
-	Messages  *agg.Hub
-	Clients   map[string]*Client //map Id string to client
-	Rules     map[string]Rule    //map Id string to Rule
-	Add       chan Rule
-	Delete    chan string      //Id string
-	Broadcast chan hub.Message //for messages incoming from the websocket server(s)
+	Messages     *agg.Hub
+	Clients      map[string]*Client //map Id string to client
+	Rules        map[string]Rule    //map Id string to Rule
+	Add          chan Rule
+	Delete       chan string      //Id string
+	Broadcast    chan hub.Message //for messages incoming from the websocket server(s)
+	Events       *events.Bus      //optional, nil is ok
+	Limit        *Bucket          //optional cap across all destinations, nil is no limit
+	Ping         chan struct{}    //received whenever the loop is responsive
+	DrainTimeout time.Duration    //how long to spend sending what is queued when closing
+	mux          sync.Mutex       //guards Rules and Clients, and their Messages and Adaptive, for Snapshot() and Status()
+	wg           sync.WaitGroup   //destinations still running
 }
 
 type Rule struct {
-	Id          string `json:"id"`
-	Stream      string `json:"stream"`
-	Destination string `json:"destination"`
-	Token       string `json:"token"`
+	Id              string   `json:"id"`
+	Stream          string   `json:"stream"`
+	Destination     string   `json:"destination"`
+	Token           string   `json:"token"`
+	Streams         []string `json:"streams,omitempty"`                                //candidates, best first, to choose between by throughput
+	RateBytesPerSec int64    `json:"rateBytesPerSec,omitempty" doc:"bytes per second"` //cap, 0 for no limit
+	BurstBytes      int64    `json:"burstBytes,omitempty"`                             //default is one second at RateBytesPerSec
+	MessageType     string   `json:"messageType,omitempty"`                            //text or binary, for destinations that accept only one; default is as sent
+}
+
+// Status that we report externally
+type Status struct {
+	Id                    string  `json:"id"`
+	Stream                string  `json:"stream"`
+	Destination           string  `json:"destination"`
+	Connected             bool    `json:"connected"`
+	Shaped                int64   `json:"shaped"`  //messages delayed to keep under the limit
+	Dropped               int64   `json:"dropped"` //messages dropped to keep under the limit
+	DroppedBytes          int64   `json:"droppedBytes"`
+	Variant               string  `json:"variant,omitempty"` //stream currently chosen from Rule.Streams
+	ThroughputBytesPerSec int64   `json:"throughputBytesPerSec,omitempty" doc:"bytes per second"`
+	LatencyMs             float64 `json:"latencyMs,omitempty"`
 }
 
 type Client struct {
-	Hub       *Hub //can access messaging hub via <client>.Hub.Messages
-	Messages  *hub.Client
-	Context   context.Context
-	Cancel    context.CancelFunc
-	Websocket *reconws.ReconWs
+	shaped       int64 //atomic; these come first so they are 64-bit aligned on arm
+	dropped      int64 //atomic
+	droppedBytes int64 //atomic
+	Hub          *Hub  //can access messaging hub via <client>.Hub.Messages
+	Messages     *hub.Client
+	Context      context.Context
+	Cancel       context.CancelFunc
+	Destination  Destination
+	In           chan reconws.WsMessage //from the destination, nil if it never replies
+	Out          chan reconws.WsMessage //to the destination
+	Limit        *Bucket                //nil is no limit
+	Adaptive     *Adaptive              //nil unless the rule has Streams
+	Kind         hub.Kind               //sent as this kind, 0 for the kind each message arrived as
+	relayCancel  context.CancelFunc     //stops the relay but not the connection
+	draining     chan struct{}          //closed to ask the relay to send what is queued, then stop
+	done         chan struct{}          //closed by the relay when it stops
+}
+
+// Destination is a reconnecting connection to wherever a stream is sent,
+// chosen by the scheme of Rule.Destination
+type Destination interface {
+	Reconnect(ctx context.Context, url string)
+	ReconnectAuth(ctx context.Context, url string, token string)
+	IsConnected() bool
 }
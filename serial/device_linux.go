@@ -0,0 +1,80 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var speeds = map[int]uint32{
+	50: unix.B50, 75: unix.B75, 110: unix.B110, 134: unix.B134, 150: unix.B150,
+	200: unix.B200, 300: unix.B300, 600: unix.B600, 1200: unix.B1200,
+	1800: unix.B1800, 2400: unix.B2400, 4800: unix.B4800, 9600: unix.B9600,
+	19200: unix.B19200, 38400: unix.B38400, 57600: unix.B57600,
+	115200: unix.B115200, 230400: unix.B230400, 460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+var sizes = map[int]uint32{5: unix.CS5, 6: unix.CS6, 7: unix.CS7, 8: unix.CS8}
+
+// open opens the device without waiting for a carrier, in raw mode with
+// the rule's baud rate and framing. The file is non-blocking, so that
+// closing it stops a read.
+func open(rule Rule) (*os.File, error) {
+
+	fd, err := unix.Open(rule.Device, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: rule.Device, Err: err}
+	}
+
+	if err := configure(fd, rule); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), rule.Device), nil
+}
+
+func configure(fd int, rule Rule) error {
+
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("%s is not a serial device: %s", rule.Device, err)
+	}
+
+	data, parity, stop, err := parseFraming(rule.Framing)
+	if err != nil {
+		return err
+	}
+
+	speed := speeds[rule.Baud]
+
+	// raw mode, as cfmakeraw does
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.INPCK
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD | unix.CRTSCTS
+
+	t.Cflag |= unix.CREAD | unix.CLOCAL | sizes[data] | speed
+
+	switch parity {
+	case 'E':
+		t.Cflag |= unix.PARENB
+		t.Iflag |= unix.INPCK
+	case 'O':
+		t.Cflag |= unix.PARENB | unix.PARODD
+		t.Iflag |= unix.INPCK
+	}
+
+	if stop == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+import (
+	"errors"
+	"os"
+)
+
+// open is not supported yet, because the termios calls differ
+func open(rule Rule) (*os.File, error) {
+	return nil, errors.New("serial devices are only supported on linux")
+}
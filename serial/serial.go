@@ -0,0 +1,433 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jpillora/backoff"
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+// longest message we will wait for a delimiter to end
+const maxMessageSize = 64 * 1024
+
+var errNoFeed = errors.New("Serial port needs a feed")
+var errNoDevice = errors.New("Serial port needs a device")
+var errBadBaud = errors.New("Baud rate is not a standard rate")
+var errBadFraming = errors.New("Framing must be data bits 5-8, parity N, E or O, and stop bits 1 or 2, e.g. 8N1")
+var errBadLineEnding = errors.New("Line ending must be lf, cr or crlf")
+
+// standard rates, which every platform we support can set
+var bauds = map[int]bool{
+	50: true, 75: true, 110: true, 134: true, 150: true, 200: true, 300: true,
+	600: true, 1200: true, 1800: true, 2400: true, 4800: true, 9600: true,
+	19200: true, 38400: true, 57600: true, 115200: true, 230400: true,
+	460800: true, 921600: true,
+}
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub) *Serial {
+
+	s := &Serial{
+		Messages: messages,
+		Add:      make(chan Rule),
+		Delete:   make(chan string), //Feed string
+		Retry: RetryConfig{Factor: 2,
+			Min: 1 * time.Second,
+			Max: 10 * time.Second},
+		ports: make(map[string]*Port),
+	}
+
+	return s
+}
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Feed == "" || rule.Feed == "deleteAll" {
+		return errNoFeed
+	}
+
+	if rule.Device == "" {
+		return errNoDevice
+	}
+
+	if rule.Baud != 0 && !bauds[rule.Baud] {
+		return errBadBaud
+	}
+
+	if rule.Framing != "" {
+		if _, _, _, err := parseFraming(rule.Framing); err != nil {
+			return err
+		}
+	}
+
+	switch rule.LineEnding {
+	case "", LineEndingLF, LineEndingCR, LineEndingCRLF:
+	default:
+		return errBadLineEnding
+	}
+
+	return nil
+}
+
+// parseFraming splits framing like 8N1 into data bits, parity and stop bits
+func parseFraming(framing string) (int, byte, int, error) {
+
+	if len(framing) != 3 {
+		return 0, 0, 0, errBadFraming
+	}
+
+	data, parity, stop := int(framing[0]-'0'), framing[1], int(framing[2]-'0')
+
+	if data < 5 || data > 8 || stop < 1 || stop > 2 {
+		return 0, 0, 0, errBadFraming
+	}
+
+	switch parity {
+	case 'N', 'E', 'O':
+	default:
+		return 0, 0, 0, errBadFraming
+	}
+
+	return data, parity, stop, nil
+}
+
+// withDefaults fills in what the rule left out
+func withDefaults(rule Rule) Rule {
+
+	if rule.Baud == 0 {
+		rule.Baud = 9600
+	}
+	if rule.Framing == "" {
+		rule.Framing = "8N1"
+	}
+	if rule.LineEnding == "" {
+		rule.LineEnding = LineEndingLF
+	}
+	if rule.Delimiter == "" {
+		rule.Delimiter = "\n"
+	}
+
+	return rule
+}
+
+func (s *Serial) Run(closed chan struct{}) {
+
+	var closing bool // messages hub has stopped, so don't unregister
+
+	defer func() { s.stopAll(closing) }()
+
+	for {
+		select {
+		case <-closed:
+			closing = true
+			return
+		case rule := <-s.Add:
+
+			if err := Check(rule); err != nil {
+				log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Bad serial rule")
+				break
+			}
+
+			s.stop(rule.Feed, false)
+
+			rule = withDefaults(rule)
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			port := &Port{Rule: rule,
+				Messages: &hub.Client{Hub: s.Messages.Hub,
+					Name:  "serial-" + rule.Feed,
+					Topic: rule.Feed,
+					Send:  make(chan hub.Message, 64),
+					Stats: hub.NewClientStats()},
+				Broadcast: s.Messages.Broadcast,
+				Events:    s.Events,
+				Retry:     s.Retry,
+				Context:   ctx,
+				Cancel:    cancel,
+				Stopped:   make(chan struct{})}
+
+			s.mux.Lock()
+			s.ports[rule.Feed] = port
+			s.mux.Unlock()
+
+			s.Messages.Register <- port.Messages
+
+			go port.Relay()
+
+		case feed := <-s.Delete:
+
+			if feed == "deleteAll" {
+				s.stopAll(false)
+			} else {
+				s.stop(feed, false)
+			}
+		}
+	}
+}
+
+// Report returns the status of every port
+func (s *Serial) Report() map[string]Status {
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for feed, port := range s.ports {
+		report[feed] = port.Status()
+	}
+
+	return report
+}
+
+func (s *Serial) stop(feed string, closing bool) {
+
+	s.mux.Lock()
+	port, ok := s.ports[feed]
+	delete(s.ports, feed)
+	s.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !closing {
+		s.Messages.Unregister <- port.Messages
+	}
+	port.Cancel()
+	<-port.Stopped
+
+	log.WithField("feed", feed).Info("Closed serial port")
+}
+
+func (s *Serial) stopAll(closing bool) {
+
+	s.mux.Lock()
+	feeds := []string{}
+	for feed := range s.ports {
+		feeds = append(feeds, feed)
+	}
+	s.mux.Unlock()
+
+	for _, feed := range feeds {
+		s.stop(feed, closing)
+	}
+}
+
+func (p *Port) Status() Status {
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return Status{Rule: p.Rule,
+		Open:     p.file != nil,
+		Opens:    p.opens,
+		Received: p.received,
+		Sent:     p.sent,
+		Dropped:  p.dropped,
+		Error:    p.lastError}
+}
+
+// Relay opens the device, and keeps reopening it after it goes away,
+// e.g. because it was unplugged, until cancelled
+func (p *Port) Relay() {
+
+	defer close(p.Stopped)
+
+	go p.write()
+
+	boff := &backoff.Backoff{
+		Min:    p.Retry.Min,
+		Max:    p.Retry.Max,
+		Factor: p.Retry.Factor,
+	}
+
+	for {
+
+		opened, err := p.session()
+
+		if p.Context.Err() != nil {
+			return
+		}
+
+		if opened {
+			boff.Reset()
+		}
+
+		p.mux.Lock()
+		p.lastError = err.Error()
+		p.mux.Unlock()
+
+		log.WithFields(log.Fields{"device": p.Rule.Device, "error": err}).Warn("Serial port closed")
+
+		select {
+		case <-p.Context.Done():
+			return
+		case <-time.After(boff.Duration()):
+		}
+	}
+}
+
+// session opens the device and publishes messages from it until it
+// fails or the port is cancelled, reporting whether it opened
+func (p *Port) session() (bool, error) {
+
+	f, err := open(p.Rule)
+	if err != nil {
+		return false, err
+	}
+
+	p.mux.Lock()
+	p.file = f
+	p.opens++
+	p.lastError = ""
+	p.mux.Unlock()
+
+	log.WithField("rule", p.Rule).Info("Opened serial port")
+	p.Events.Publish(events.Event{Kind: events.FeedStarted, Topic: p.Rule.Feed, Id: "serial", Detail: p.Rule.Device})
+
+	// closing the file is what stops the read
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-p.Context.Done():
+		case <-done:
+		}
+		p.mux.Lock()
+		p.file = nil
+		p.mux.Unlock()
+		f.Close()
+	}()
+
+	err = p.read(f)
+
+	close(done)
+
+	p.Events.Publish(events.Event{Kind: events.FeedSilent, Topic: p.Rule.Feed, Id: "serial", Detail: p.Rule.Device})
+
+	return true, err
+}
+
+// read publishes each message from the device, until it fails
+func (p *Port) read(f *os.File) error {
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+	scanner.Split(split([]byte(toDevice(p.Rule.Delimiter, p.Rule.LineEnding))))
+
+	for scanner.Scan() {
+
+		data := []byte(fromDevice(scanner.Text(), p.Rule.LineEnding))
+
+		if len(data) == 0 {
+			continue
+		}
+
+		msg := hub.Message{Sender: *p.Messages, Type: hub.Text, Data: data, Sent: time.Now()}
+
+		select {
+		case p.Broadcast <- msg:
+			p.mux.Lock()
+			p.received++
+			p.mux.Unlock()
+		case <-p.Context.Done():
+			return p.Context.Err()
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("Device closed")
+}
+
+// write sends each message from the feed to the device, ending it with
+// the delimiter, or drops it if the device is not open
+func (p *Port) write() {
+
+	for {
+		select {
+		case <-p.Context.Done():
+			return
+		case msg := <-p.Messages.Send:
+
+			data := string(msg.Data)
+			if !strings.HasSuffix(data, p.Rule.Delimiter) {
+				data += p.Rule.Delimiter
+			}
+
+			p.mux.Lock()
+			f := p.file
+			p.mux.Unlock()
+
+			if f != nil {
+				if _, err := f.Write([]byte(toDevice(data, p.Rule.LineEnding))); err == nil {
+					p.mux.Lock()
+					p.sent++
+					p.mux.Unlock()
+					continue
+				}
+			}
+
+			p.mux.Lock()
+			p.dropped++
+			p.mux.Unlock()
+		}
+	}
+}
+
+// split finds messages ending in the delimiter
+func split(delimiter []byte) bufio.SplitFunc {
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+
+		if i := bytes.Index(data, delimiter); i >= 0 {
+			return i + len(delimiter), data[:i], nil
+		}
+
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	}
+}
+
+// toDevice translates \n into the device's line ending
+func toDevice(s, lineEnding string) string {
+
+	switch lineEnding {
+	case LineEndingCR:
+		return strings.Replace(s, "\n", "\r", -1)
+	case LineEndingCRLF:
+		return strings.Replace(s, "\n", "\r\n", -1)
+	}
+
+	return s
+}
+
+// fromDevice translates the device's line ending into \n
+func fromDevice(s, lineEnding string) string {
+
+	switch lineEnding {
+	case LineEndingCR:
+		return strings.Replace(s, "\r", "\n", -1)
+	case LineEndingCRLF:
+		return strings.Replace(s, "\r\n", "\n", -1)
+	}
+
+	return s
+}
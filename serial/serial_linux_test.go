@@ -0,0 +1,162 @@
+package serial
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/hub"
+	"golang.org/x/sys/unix"
+)
+
+// openPty makes a pty pair, and links the device to the slave,
+// as udev would link a USB serial adapter under /dev/serial/by-id
+func openPty(t *testing.T, device string) *os.File {
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("No ptys:", err)
+	}
+
+	// not master.Fd(), which would stop Close from ending a Read
+	raw, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+
+	raw.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err == nil {
+			n, err = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(fmt.Sprintf("/dev/pts/%d", n), device); err != nil {
+		t.Fatal(err)
+	}
+
+	return master
+}
+
+// readPty collects what is written to the device
+func readPty(master *os.File) chan string {
+
+	written := make(chan string, 10)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			written <- string(buf[:n])
+		}
+	}()
+
+	return written
+}
+
+func waitFor(t *testing.T, s *Serial, feed string, done func(Status) bool) {
+
+	for i := 0; i < 100; i++ {
+		if done(s.Report()[feed]) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out with status %+v", s.Report()[feed])
+}
+
+func receiveText(t *testing.T, rx *hub.Client, expected string) {
+
+	select {
+	case msg := <-rx.Send:
+		if string(msg.Data) != expected || msg.Type != hub.Text {
+			t.Errorf("Wrong message %q (type %d), wanted %q", msg.Data, msg.Type, expected)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive %q", expected)
+	}
+}
+
+func TestRelayReopens(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	device := filepath.Join(dir, "ttyUSB0")
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	mh := agg.New()
+	go mh.Run(closed)
+
+	s := New(mh)
+	s.Retry = RetryConfig{Factor: 2, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+	go s.Run(closed)
+
+	rx := &hub.Client{Hub: mh.Hub, Name: "rx", Topic: "pendulum", Send: make(chan hub.Message, 10), Stats: hub.NewClientStats()}
+	mh.Register <- rx
+
+	// missing until plugged in
+	s.Add <- Rule{Feed: "pendulum", Device: device, Baud: 57600, LineEnding: LineEndingCRLF}
+
+	waitFor(t, s, "pendulum", func(st Status) bool { return st.Error != "" })
+
+	master := openPty(t, device)
+	written := readPty(master)
+
+	waitFor(t, s, "pendulum", func(st Status) bool { return st.Open })
+
+	master.Write([]byte("swing 1\r\nswing"))
+	master.Write([]byte(" 2\r\n"))
+
+	receiveText(t, rx, "swing 1")
+	receiveText(t, rx, "swing 2")
+
+	mh.Broadcast <- hub.Message{Sender: *rx, Type: hub.Text, Data: []byte("start"), Sent: time.Now()}
+
+	select {
+	case got := <-written:
+		if got != "start\r\n" {
+			t.Errorf("Wrong write %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("Nothing written to the device")
+	}
+
+	// unplug, and plug in again
+	master.Close()
+	os.Remove(device)
+
+	waitFor(t, s, "pendulum", func(st Status) bool { return !st.Open })
+
+	master = openPty(t, device)
+	defer master.Close()
+
+	waitFor(t, s, "pendulum", func(st Status) bool { return st.Open && st.Opens == 2 })
+
+	master.Write([]byte("swing 3\r\n"))
+
+	receiveText(t, rx, "swing 3")
+
+	s.Delete <- "pendulum"
+
+	waitFor(t, s, "pendulum", func(st Status) bool { return st.Rule.Feed == "" })
+}
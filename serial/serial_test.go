@@ -0,0 +1,66 @@
+package serial
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+func TestCheck(t *testing.T) {
+
+	if err := Check(Rule{Feed: "pendulum", Device: "/dev/ttyUSB0", Baud: 57600, Framing: "7E2", LineEnding: "crlf"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bad := map[error]Rule{
+		errNoFeed:        {Device: "/dev/ttyUSB0"},
+		errNoDevice:      {Feed: "pendulum"},
+		errBadBaud:       {Feed: "pendulum", Device: "/dev/ttyUSB0", Baud: 57601},
+		errBadFraming:    {Feed: "pendulum", Device: "/dev/ttyUSB0", Framing: "8X1"},
+		errBadLineEnding: {Feed: "pendulum", Device: "/dev/ttyUSB0", LineEnding: "nl"},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error for %+v; got %v wanted %v", rule, err, expected)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	for _, tc := range []struct {
+		lineEnding string
+		delimiter  string
+		input      string
+		expected   []string
+	}{
+		{LineEndingLF, "\n", "a\nb\n\nc", []string{"a", "b", "", "c"}},
+		{LineEndingCRLF, "\n", "a\r\nb\rc\r\n", []string{"a", "b\rc"}},
+		{LineEndingCR, "\n", "a\rb\r", []string{"a", "b"}},
+		{LineEndingCRLF, ";", "x=1\r\n;y=2;", []string{"x=1\n", "y=2"}},
+	} {
+
+		scanner := bufio.NewScanner(strings.NewReader(tc.input))
+		scanner.Split(split([]byte(toDevice(tc.delimiter, tc.lineEnding))))
+
+		got := []string{}
+		for scanner.Scan() {
+			got = append(got, fromDevice(scanner.Text(), tc.lineEnding))
+		}
+
+		if strings.Join(got, "|") != strings.Join(tc.expected, "|") {
+			t.Errorf("Wrong messages for %q with %s; got %q wanted %q", tc.input, tc.lineEnding, got, tc.expected)
+		}
+	}
+
+	if got := toDevice("go\n", LineEndingCRLF); got != "go\r\n" {
+		t.Errorf("Wrong translation %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package serial
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+// Line endings that a device can use
+const (
+	LineEndingLF   = "lf"
+	LineEndingCR   = "cr"
+	LineEndingCRLF = "crlf"
+)
+
+type Serial struct {
+	Messages *agg.Hub
+	Events   *events.Bus //optional, nil is ok
+	Retry    RetryConfig
+	Add      chan Rule
+	Delete   chan string //Feed string
+	mux      sync.Mutex
+	ports    map[string]*Port //map Feed string to Port
+}
+
+// RetryConfig sets how often to try reopening a device that is missing
+type RetryConfig struct {
+	Factor float64
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Rule opens a device, publishing what it sends to the feed,
+// and writing to it whatever is sent to the feed
+type Rule struct {
+	Feed       string `json:"feed"`
+	Device     string `json:"device"`
+	Baud       int    `json:"baud,omitempty"`       // default 9600
+	Framing    string `json:"framing,omitempty"`    // data bits, parity N, E or O, and stop bits, default 8N1
+	LineEnding string `json:"lineEnding,omitempty"` // lf (default), cr or crlf, translated to and from \n
+	Delimiter  string `json:"delimiter,omitempty"`  // between messages, after translating line endings, default \n
+}
+
+// Port relays between a device and a feed, reopening the device if needed
+type Port struct {
+	Rule      Rule
+	Messages  *hub.Client
+	Broadcast chan hub.Message
+	Events    *events.Bus
+	Retry     RetryConfig
+	Context   context.Context
+	Cancel    context.CancelFunc
+	Stopped   chan struct{}
+	mux       sync.Mutex
+	file      *os.File // nil while the device is closed
+	opens     int
+	received  int64
+	sent      int64
+	dropped   int64
+	lastError string
+}
+
+// Status that we report externally
+type Status struct {
+	Rule     Rule   `json:"rule"`
+	Open     bool   `json:"open"`
+	Opens    int    `json:"opens"`
+	Received int64  `json:"received"` // messages from the device
+	Sent     int64  `json:"sent"`     // messages to the device
+	Dropped  int64  `json:"dropped"`  // messages for the device that arrived while it was closed
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,100 @@
+package ts
+
+// Header describes a packet to Encode
+type Header struct {
+	PID          uint16
+	PUSI         bool
+	CC           uint8
+	RandomAccess bool
+	HasPCR       bool
+	PCR          uint64 // 27MHz ticks
+}
+
+// Encode builds a packet, stuffing the adaptation field so the payload
+// fits exactly; payload beyond what fits is dropped
+func Encode(h Header, payload []byte) []byte {
+
+	p := make([]byte, PacketSize)
+
+	p[0] = SyncByte
+	p[1] = byte(h.PID >> 8 & 0x1f)
+	if h.PUSI {
+		p[1] |= 0x40
+	}
+	p[2] = byte(h.PID)
+	p[3] = h.CC & 0x0f
+
+	// adaptation field contents, after the length byte
+	var a []byte
+
+	if h.RandomAccess || h.HasPCR {
+		flags := byte(0)
+		if h.RandomAccess {
+			flags |= 0x40
+		}
+		a = append(a, flags)
+		if h.HasPCR {
+			a[0] |= 0x10
+			base := h.PCR / 300
+			ext := h.PCR % 300
+			a = append(a,
+				byte(base>>25),
+				byte(base>>17),
+				byte(base>>9),
+				byte(base>>1),
+				byte(base<<7)|0x7e|byte(ext>>8),
+				byte(ext))
+		}
+	}
+
+	room := PacketSize - 4
+	if a != nil {
+		room -= 1 + len(a)
+	}
+
+	if len(payload) < room {
+		// stuff the adaptation field to fill the packet
+		if a == nil {
+			a = []byte{}
+			room-- // length byte
+		}
+		for len(payload) < room {
+			if len(a) == 0 {
+				a = append(a, 0) // flags
+			} else {
+				a = append(a, 0xff)
+			}
+			room--
+		}
+	} else {
+		payload = payload[:room]
+	}
+
+	i := 4
+
+	if a != nil {
+		p[3] |= 0x20
+		p[4] = byte(len(a))
+		copy(p[5:], a)
+		i += 1 + len(a)
+	}
+
+	if len(payload) > 0 {
+		p[3] |= 0x10
+		copy(p[i:], payload)
+	}
+
+	return p
+}
+
+// PESHeader returns the start of a PES packet with a PTS, and
+// unbounded length as used for video
+func PESHeader(streamId uint8, pts uint64) []byte {
+	return []byte{0, 0, 1, streamId, 0, 0,
+		0x80, 0x80, 5,
+		byte(0x21 | (pts>>29)&0x0e),
+		byte(pts >> 22),
+		byte(0x01 | (pts>>14)&0xfe),
+		byte(pts >> 7),
+		byte(0x01 | (pts<<1)&0xfe)}
+}
@@ -0,0 +1,171 @@
+package ts
+
+import "sort"
+
+// section returns the PSI section that starts in this packet, if any,
+// without its CRC; sections that continue into the next packet are cut short
+func section(p []byte) []byte {
+	if !PayloadUnitStart(p) {
+		return nil
+	}
+	payload := Payload(p)
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0]) // skip the pointer field
+	if start+3 > len(payload) {
+		return nil
+	}
+	s := payload[start:]
+	length := int(s[1]&0x0f)<<8 | int(s[2])
+	end := 3 + length - 4
+	if end > len(s) {
+		end = len(s)
+	}
+	if end < 8 {
+		return nil
+	}
+	return s[:end]
+}
+
+// PAT returns the PMT PID of each program, keyed by program number, if
+// this packet starts a program association table
+func PAT(p []byte) (map[uint16]uint16, bool) {
+	if PID(p) != 0 {
+		return nil, false
+	}
+	s := section(p)
+	if s == nil || s[0] != 0x00 {
+		return nil, false
+	}
+	programs := make(map[uint16]uint16)
+	for i := 8; i+4 <= len(s); i += 4 {
+		program := uint16(s[i])<<8 | uint16(s[i+1])
+		if program == 0 {
+			continue // network PID
+		}
+		programs[program] = uint16(s[i+2]&0x1f)<<8 | uint16(s[i+3])
+	}
+	return programs, true
+}
+
+// PATPayload builds the payload of a packet carrying a program association
+// table, including the pointer field, for use with Encode
+func PATPayload(programs map[uint16]uint16, version uint8) []byte {
+
+	// keep output stable for the same programs
+	numbers := []uint16{}
+	for program := range programs {
+		numbers = append(numbers, program)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	s := []byte{0x00, 0, 0, 0x00, 0x01, 0xc1 | version<<1&0x3e, 0x00, 0x00}
+	for _, program := range numbers {
+		pid := programs[program]
+		s = append(s, byte(program>>8), byte(program), 0xe0|byte(pid>>8&0x1f), byte(pid))
+	}
+
+	return psiPayload(s)
+}
+
+// Program describes the elementary streams in a program map table
+type Program struct {
+	PCRPID  uint16
+	Streams []ES
+}
+
+// ES is an elementary stream listed in a program map table
+type ES struct {
+	Type uint8
+	PID  uint16
+	Info []byte // descriptors
+}
+
+// Kinds of elementary stream
+const (
+	KindVideo = "video"
+	KindAudio = "audio"
+	KindData  = "data"
+)
+
+// Kind says whether a PMT stream type is video, audio or data
+func Kind(streamType uint8) string {
+	switch streamType {
+	case 0x01, 0x02, 0x10, 0x1b, 0x24, 0x42, 0xea:
+		return KindVideo
+	case 0x03, 0x04, 0x0f, 0x11, 0x81, 0x87:
+		return KindAudio
+	}
+	return KindData
+}
+
+// PMT returns the program described by this packet, if it starts a
+// program map table; the caller must know which PIDs carry PMTs
+func PMT(p []byte) (uint16, Program, bool) {
+	var program Program
+	s := section(p)
+	if s == nil || s[0] != 0x02 || len(s) < 12 {
+		return 0, program, false
+	}
+	number := uint16(s[3])<<8 | uint16(s[4])
+	program.PCRPID = uint16(s[8]&0x1f)<<8 | uint16(s[9])
+	i := 12 + (int(s[10]&0x0f)<<8 | int(s[11])) // skip program info
+	for i+5 <= len(s) {
+		es := ES{Type: s[i], PID: uint16(s[i+1]&0x1f)<<8 | uint16(s[i+2])}
+		n := int(s[i+3]&0x0f)<<8 | int(s[i+4])
+		i += 5
+		if i+n > len(s) {
+			break
+		}
+		if n > 0 {
+			es.Info = append([]byte{}, s[i:i+n]...)
+		}
+		program.Streams = append(program.Streams, es)
+		i += n
+	}
+	return number, program, true
+}
+
+// PMTPayload builds the payload of a packet carrying a program map table,
+// including the pointer field, for use with Encode
+func PMTPayload(number uint16, program Program, version uint8) []byte {
+
+	s := []byte{0x02, 0, 0, byte(number >> 8), byte(number), 0xc1 | version<<1&0x3e, 0x00, 0x00,
+		0xe0 | byte(program.PCRPID>>8&0x1f), byte(program.PCRPID), 0xf0, 0x00}
+
+	for _, es := range program.Streams {
+		s = append(s, es.Type, 0xe0|byte(es.PID>>8&0x1f), byte(es.PID),
+			0xf0|byte(len(es.Info)>>8&0x0f), byte(len(es.Info)))
+		s = append(s, es.Info...)
+	}
+
+	return psiPayload(s)
+}
+
+// psiPayload fills in the section length, appends the CRC and
+// prepends the pointer field
+func psiPayload(s []byte) []byte {
+	length := len(s) - 3 + 4
+	s[1] = 0xb0 | byte(length>>8&0x0f)
+	s[2] = byte(length)
+	crc := CRC32(s)
+	s = append(s, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+	return append([]byte{0}, s...)
+}
+
+// CRC32 is the MPEG-2 CRC used by PSI sections
+func CRC32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
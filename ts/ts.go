@@ -0,0 +1,135 @@
+/*
+   ts reads the parts of MPEG transport stream packets that vw needs
+   Copyright (C) 2019 Timothy Drysdale <timothy.d.drysdale@gmail.com>
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as
+   published by the Free Software Foundation, either version 3 of the
+   License, or (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ts
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+
+	// PCR ticks at 27MHz, PTS at 90kHz
+	PCRHz = 27000000
+	PTSHz = 90000
+)
+
+// Valid reports whether p looks like a transport stream packet
+func Valid(p []byte) bool {
+	return len(p) >= PacketSize && p[0] == SyncByte
+}
+
+func PID(p []byte) uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+// PayloadUnitStart is set on the first packet of a PES packet or section
+func PayloadUnitStart(p []byte) bool {
+	return p[1]&0x40 != 0
+}
+
+func ContinuityCounter(p []byte) uint8 {
+	return p[3] & 0x0f
+}
+
+func HasAdaptation(p []byte) bool {
+	return p[3]&0x20 != 0
+}
+
+func HasPayload(p []byte) bool {
+	return p[3]&0x10 != 0
+}
+
+// adaptation returns the adaptation field, without its length byte
+func adaptation(p []byte) []byte {
+	if !HasAdaptation(p) {
+		return nil
+	}
+	length := int(p[4])
+	if length == 0 || 5+length > PacketSize {
+		return nil
+	}
+	return p[5 : 5+length]
+}
+
+// Discontinuity reports whether the discontinuity indicator is set
+func Discontinuity(p []byte) bool {
+	a := adaptation(p)
+	return len(a) > 0 && a[0]&0x80 != 0
+}
+
+// RandomAccess reports whether the random access indicator is set,
+// which encoders use to mark keyframes
+func RandomAccess(p []byte) bool {
+	a := adaptation(p)
+	return len(a) > 0 && a[0]&0x40 != 0
+}
+
+// PCR returns the program clock reference in 27MHz ticks, if present
+func PCR(p []byte) (uint64, bool) {
+	a := adaptation(p)
+	if len(a) < 7 || a[0]&0x10 == 0 {
+		return 0, false
+	}
+	base := uint64(a[1])<<25 | uint64(a[2])<<17 | uint64(a[3])<<9 | uint64(a[4])<<1 | uint64(a[5])>>7
+	ext := uint64(a[5]&0x01)<<8 | uint64(a[6])
+	return base*300 + ext, true
+}
+
+// Payload returns the packet payload, after any adaptation field
+func Payload(p []byte) []byte {
+	if !HasPayload(p) {
+		return nil
+	}
+	start := 4
+	if HasAdaptation(p) {
+		start += 1 + int(p[4])
+	}
+	if start >= PacketSize {
+		return nil
+	}
+	return p[start:PacketSize]
+}
+
+// PTS returns the presentation time stamp in 90kHz ticks, if this
+// packet starts a PES packet that has one
+func PTS(p []byte) (uint64, bool) {
+	if !PayloadUnitStart(p) {
+		return 0, false
+	}
+	pes := Payload(p)
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return 0, false
+	}
+	if pes[7]&0x80 == 0 {
+		return 0, false
+	}
+	t := pes[9:14]
+	pts := uint64(t[0]>>1&0x07)<<30 | uint64(t[1])<<22 | uint64(t[2]>>1)<<15 | uint64(t[3])<<7 | uint64(t[4]>>1)
+	return pts, true
+}
+
+// StreamId returns the PES stream id, if this packet starts a PES packet
+func StreamId(p []byte) (uint8, bool) {
+	if !PayloadUnitStart(p) {
+		return 0, false
+	}
+	pes := Payload(p)
+	if len(pes) < 4 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return 0, false
+	}
+	return pes[3], true
+}
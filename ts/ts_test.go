@@ -0,0 +1,141 @@
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeAndRead(t *testing.T) {
+
+	payload := bytes.Repeat([]byte{0xaa}, 184)
+
+	p := Encode(Header{PID: 0x100, PUSI: true, CC: 7}, payload)
+
+	if len(p) != PacketSize || !Valid(p) {
+		t.Fatal("Invalid packet")
+	}
+
+	if PID(p) != 0x100 || !PayloadUnitStart(p) || ContinuityCounter(p) != 7 {
+		t.Errorf("Wrong header %x", p[:4])
+	}
+
+	if HasAdaptation(p) {
+		t.Error("Unexpected adaptation field")
+	}
+
+	if !bytes.Equal(Payload(p), payload) {
+		t.Error("Wrong payload")
+	}
+}
+
+func TestStuffing(t *testing.T) {
+
+	for _, size := range []int{0, 1, 182, 183} {
+
+		payload := bytes.Repeat([]byte{0xaa}, size)
+
+		p := Encode(Header{PID: 0x101}, payload)
+
+		if len(p) != PacketSize {
+			t.Errorf("Wrong size %d for payload of %d", len(p), size)
+		}
+
+		if !bytes.Equal(Payload(p), payload) {
+			t.Errorf("Wrong payload for size %d got %d bytes", size, len(Payload(p)))
+		}
+	}
+}
+
+func TestPCR(t *testing.T) {
+
+	pcr := uint64(8589934591*300 + 299) //largest
+
+	p := Encode(Header{PID: 0x100, HasPCR: true, PCR: pcr, RandomAccess: true}, nil)
+
+	got, ok := PCR(p)
+
+	if !ok || got != pcr {
+		t.Errorf("Wrong PCR got/wanted %d/%d", got, pcr)
+	}
+
+	if !RandomAccess(p) || Discontinuity(p) {
+		t.Error("Wrong adaptation flags")
+	}
+
+	if _, ok := PCR(Encode(Header{PID: 0x100}, nil)); ok {
+		t.Error("Found PCR that was not there")
+	}
+}
+
+func TestPTS(t *testing.T) {
+
+	for _, pts := range []uint64{0, 90000, 8589934591} {
+
+		p := Encode(Header{PID: 0x100, PUSI: true}, PESHeader(0xe0, pts))
+
+		got, ok := PTS(p)
+
+		if !ok || got != pts {
+			t.Errorf("Wrong PTS got/wanted %d/%d", got, pts)
+		}
+
+		id, ok := StreamId(p)
+
+		if !ok || id != 0xe0 {
+			t.Errorf("Wrong stream id %x", id)
+		}
+	}
+}
+
+func TestPAT(t *testing.T) {
+
+	programs := map[uint16]uint16{1: 0x1000, 2: 0x1010}
+
+	payload := PATPayload(programs, 3)
+
+	// the CRC of a whole section, including its CRC, is zero
+	if CRC32(payload[1:]) != 0 {
+		t.Error("Wrong CRC")
+	}
+
+	p := Encode(Header{PID: 0, PUSI: true}, payload)
+
+	got, ok := PAT(p)
+
+	if !ok || len(got) != 2 || got[1] != 0x1000 || got[2] != 0x1010 {
+		t.Errorf("Wrong programs %v", got)
+	}
+
+	if _, ok := PAT(Encode(Header{PID: 0x100, PUSI: true}, payload)); ok {
+		t.Error("Found PAT on wrong PID")
+	}
+}
+
+func TestPMT(t *testing.T) {
+
+	program := Program{PCRPID: 0x100, Streams: []ES{
+		{Type: 0x1b, PID: 0x100},
+		{Type: 0x0f, PID: 0x101, Info: []byte{0x0a, 0x04, 'e', 'n', 'g', 0x00}},
+	}}
+
+	payload := PMTPayload(7, program, 2)
+
+	if CRC32(payload[1:]) != 0 {
+		t.Error("Wrong CRC")
+	}
+
+	number, got, ok := PMT(Encode(Header{PID: 0x1000, PUSI: true}, payload))
+
+	if !ok || number != 7 {
+		t.Fatalf("Did not find program 7, got %d", number)
+	}
+
+	if !reflect.DeepEqual(got, program) {
+		t.Errorf("Wrong program; got/wanted\n%+v\n%+v", got, program)
+	}
+
+	if _, _, ok := PMT(Encode(Header{PID: 0, PUSI: true}, PATPayload(map[uint16]uint16{1: 0x1000}, 0))); ok {
+		t.Error("Found PMT in a PAT")
+	}
+}
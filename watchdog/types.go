@@ -0,0 +1,55 @@
+package watchdog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+// Actions that can be taken when a feed goes stale
+const (
+	ActionLog      = "log"
+	ActionEvent    = "event"
+	ActionFailover = "failover"
+	ActionRestart  = "restart"
+)
+
+type Watchdog struct {
+	Messages *agg.Hub
+	Events   *events.Bus //optional, nil is ok
+	Add      chan Rule
+	Delete   chan string //Feed string
+	Interval time.Duration
+	Restart  []string // command and arguments, run without a shell and with {feed} replaced, e.g. systemctl restart capture@{feed}
+	mux      sync.Mutex
+	watches  map[string]*Watch //map Feed string to Watch
+}
+
+type Rule struct {
+	Feed        string   `json:"feed"`
+	ThresholdMs int      `json:"thresholdMs"`
+	Actions     []string `json:"actions"`
+	Failover    string   `json:"failover,omitempty"`
+}
+
+// Watch is a passive client on a feed that notes when messages arrive
+type Watch struct {
+	Rule     Rule
+	Messages *hub.Client
+	Context  context.Context
+	Cancel   context.CancelFunc
+	Last     time.Time
+	Stale    bool
+	Swapped  map[string][]string //original feeds of any streams we failed over
+}
+
+// Status that we report externally
+type Status struct {
+	Rule  Rule   `json:"rule"`
+	Stale bool   `json:"stale"`
+	Last  string `json:"last"`
+}
